@@ -0,0 +1,58 @@
+package cli
+
+import (
+	"fmt"
+	"time"
+
+	"pwm/salt"
+)
+
+// pwm calibrate, benchmarks the kdfs and suggests parameters that take about --target to unlock
+func calibrateCommand(args []string) error {
+	flags := newFlagSet("calibrate", "")
+	target := flags.Duration("target", 500*time.Millisecond, "unlock time to aim for, such as 500ms or 1s")
+	kdf := flags.String("kdf", "all", "kdf to calibrate, argon2id, scrypt or all")
+	memory := flags.Uint("memory", 256, "most memory the kdf may use in MiB, it should fit the smallest machine the vault is opened on")
+	threads := flags.Uint("threads", 4, "argon2id threads")
+
+	positional, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 0 {
+		flags.Usage()
+		return usage("calibrate takes no arguments")
+	}
+	if *kdf != "all" && *kdf != "argon2id" && *kdf != "scrypt" {
+		return usage("unknown kdf %s", *kdf)
+	}
+	if *target <= 0 {
+		return usage("--target must be positive")
+	}
+	if *memory < 1 || *memory > salt.MaxMemory>>20 {
+		return usage("--memory must be between 1 and %d MiB", salt.MaxMemory>>20)
+	}
+	if *threads < 1 || *threads > 255 {
+		return usage("--threads must be between 1 and 255")
+	}
+
+	fmt.Printf("Calibrating for %v, this runs the kdf several times\n", *target)
+
+	if *kdf != "scrypt" {
+		params, elapsed, err := salt.CalibrateArgon2(*target, uint32(*memory)<<10, uint8(*threads))
+		if err != nil {
+			return usage("%s", err)
+		}
+		fmt.Printf("argon2id: time %d, memory %d KiB, threads %d, took %v\n", params.Time, params.Memory, params.Threads, elapsed.Round(time.Millisecond))
+	}
+
+	if *kdf != "argon2id" {
+		params, elapsed, err := salt.CalibrateScrypt(*target, uint64(*memory)<<20)
+		if err != nil {
+			return usage("%s", err)
+		}
+		fmt.Printf("scrypt: log2 N %d, r %d, p %d, memory %d MiB, took %v\n", params.LogN, params.R, params.P, params.MemoryUsage()>>20, elapsed.Round(time.Millisecond))
+	}
+
+	return nil
+}
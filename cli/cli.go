@@ -5,130 +5,328 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
     "pwm/encrypt"
     "pwm/database"
+    "pwm/generate"
+    "pwm/salt"
 
 	"golang.org/x/term"
 )
 
+const usageText = `Usage: --encrypt <file> [-o out] [--cipher aes-256-gcm|xchacha20-poly1305] --decrypt <file> [-o out] --file <file> [--keyfile path] [--backups n] --new [--keyfile path] [--kdf argon2id|scrypt] [--backups n]
+       get <vault> <entry> | add <vault> --username <username> | rm <vault> <entry> | ls <vault> | find <vault> <query> | otp <vault> <entry> | import <vault> <file> | export <vault> <file> | gen | calibrate | recovery split|unlock <vault>`
+
 func Init() error {
 	if len(os.Args) < 2 {
-		fmt.Println("Usage: --encrypt <file> --decrypt <file> --file <file> --new")
+		fmt.Println(usageText)
 	} else {
 		switch strings.ToLower(os.Args[1]) {
+		case "get":
+			return getCommand(os.Args[2:])
+		case "add":
+			return addCommand(os.Args[2:])
+		case "rm":
+			return rmCommand(os.Args[2:])
+		case "ls":
+			return lsCommand(os.Args[2:])
+		case "find":
+			return findCommand(os.Args[2:])
+		case "gen":
+			return genCommand(os.Args[2:])
+		case "otp":
+			return otpCommand(os.Args[2:])
+		case "import":
+			return importCommand(os.Args[2:])
+		case "export":
+			return exportCommand(os.Args[2:])
+		case "calibrate":
+			return calibrateCommand(os.Args[2:])
+		case "recovery":
+			return recoveryCommand(os.Args[2:])
 		case "--encrypt":
-			if len(os.Args) < 3 {
-				fmt.Println("Expected file\nUsage: --encrypt <file>")
-			} else {
-				contents, err := os.ReadFile(os.Args[2])
-				if err != nil {
-					return err
-				}
-				password := passwordConfirmation("What will the password be for this file?")
+			return encryptFile(os.Args[2:])
+		case "--decrypt":
+			return decryptFile(os.Args[2:])
+		case "--file":
+			flags := newFlagSet("--file", "<file>")
+			keyFilePath := flags.String("keyfile", "", "key file the vault is locked with along with the password")
+			backupsFlag := flags.Int("backups", -1, "number of previous versions to keep when saving, the default is $"+backupsEnv+" or 2")
+			positional, err := parseArgs(flags, os.Args[2:])
+			if err != nil {
+				return err
+			}
+			if len(positional) != 1 {
+				fmt.Println("Expected file\nUsage: --file <file> [--keyfile path] [--backups n]")
+				return nil
+			}
+			fileName := positional[0]
 
-				fmt.Println("Encrypting", os.Args[2])
-				ciphertext, err := encrypt.EncryptScrypt([]byte(password), contents, 18)
-				if err != nil {
-					return err
-				}
+			backups, err := backupCount(*backupsFlag)
+			if err != nil {
+				return err
+			}
 
-				var outfile string
-				if len(os.Args) >= 5 && strings.Compare(os.Args[3], "-o") == 0 {
-					outfile = os.Args[4]
-				} else {
-					outfile = os.Args[2]
-				}
+			keyFile, err := loadKeyFile(*keyFilePath, false)
+			if err != nil {
+				return err
+			}
 
-				fmt.Printf("Writing to %s\n", outfile)
-				err = os.WriteFile(outfile, ciphertext, 0644)
-				if err != nil {
-					return err
-				}
+			fmt.Println("Enter the password to this file")
+			password, err := term.ReadPassword(int(os.Stdin.Fd()))
+			if err != nil {
+				return err
 			}
-		case "--decrypt":
-			if len(os.Args) < 3 {
-				fmt.Println("Expected file\nUsage: --decrypt <file>")
-			} else {
-				contents, err := os.ReadFile(os.Args[2])
-				if err != nil {
-					return err
-				}
-				fmt.Println("Enter the files password")
-				password, err := term.ReadPassword(int(os.Stdin.Fd()))
-				if err != nil {
-					return err
-				}
 
-				fmt.Println("Decrypting", os.Args[2])
-				ciphertext, err := encrypt.DecryptScrypt([]byte(password), contents, 18)
-				if err != nil {
-					return err
-				}
+			// keepass files are saved back as kdbx rather than converted
+			var keePass *keePassVault
+			if isKDBXFile(fileName) {
+				keePass = &keePassVault{fileName: fileName, password: string(password), keyFile: keyFile}
+			}
 
-				var outfile string
-				if len(os.Args) >= 5 && strings.Compare(os.Args[3], "-o") == 0 {
-					outfile = os.Args[4]
+			channel := make(chan *database.Database)
+			go func() {
+				var db *database.Database
+				var err error
+				if keePass != nil {
+					db, err = keePass.open()
 				} else {
-					outfile = os.Args[2]
+					db, err = database.FromFile(masterKey(string(password), keyFile), fileName)
 				}
-
-				fmt.Printf("Writing to %s\n", outfile)
-				err = os.WriteFile(outfile, ciphertext, 0644)
 				if err != nil {
-					return err
+					fmt.Println("Could not open file")
+					channel <- nil
+				} else {
+					if backups >= 0 {
+						db.SetBackups(backups)
+						if keePass != nil {
+							keePass.disk.SetBackups(backups)
+						}
+					}
+					channel <- db
 				}
+				close(channel)
+			}()
+
+			return cliLoop(channel, keePass, keyFile, backups)
+		case "--new":
+			flags := newFlagSet("--new", "")
+			keyFilePath := flags.String("keyfile", "", "lock the vault with this key file along with the password, a new key file is made if it doesn't exist")
+			kdfOptions := addKDFFlags(flags)
+			backupsFlag := flags.Int("backups", -1, "number of previous versions to keep when saving, the default is $"+backupsEnv+" or 2")
+			_, err := parseArgs(flags, os.Args[2:])
+			if err != nil {
+				return err
 			}
-		case "--file":
-			if len(os.Args) < 3 {
-				fmt.Println("Expected file\nUsage: --file <file>")
-			} else {
-				fmt.Println("Enter the password to this file")
-				password, err := term.ReadPassword(int(os.Stdin.Fd()))
-				if err != nil {
-					return err
-				}
 
-				channel := make(chan *database.Database)
-				go func() {
-					db, err := database.FromFile(string(password), os.Args[2])
-					if err != nil {
-						fmt.Println("Could not open file")
-						channel <- nil
-					} else {
-						channel <- db
-					}
-					close(channel)
-				}()
+			kdf, err := kdfOptions.resolve(database.DefaultKDF())
+			if err != nil {
+				return err
+			}
+			backups, err := backupCount(*backupsFlag)
+			if err != nil {
+				return err
+			}
 
-				return cliLoop(channel)
+			keyFile, err := loadKeyFile(*keyFilePath, true)
+			if err != nil {
+				return err
 			}
-		case "--new":
+
 			password := passwordConfirmation("Creating new database, what should the master password be?")
 
 			channel := make(chan *database.Database)
 			go func() {
-				db, err := database.New(password)
+				db, err := database.New(masterKey(password, keyFile), kdf)
 				if err != nil {
 					fmt.Println("Failed to create database")
 					channel <- nil
 				} else {
+					if backups >= 0 {
+						db.SetBackups(backups)
+					}
 					channel <- db
 				}
 				close(channel)
 			}()
 
-			return cliLoop(channel)
+			return cliLoop(channel, nil, keyFile, backups)
 		default:
-			fmt.Println("Usage: --encrypt <file> --decrypt <file> --file <file> --new")
+			fmt.Println(usageText)
+			return usage("unknown command %s", os.Args[1])
 		}
 	}
 	return nil
 }
 
-func cliLoop(channelDb chan *database.Database) error {
+// --encrypt <file> [-o out], the file is overwritten unless -o is given
+func encryptFile(args []string) error {
+	flags := newFlagSet("--encrypt", "<file> [-o out]")
+	outfile := flags.String("o", "", "write the encrypted file here instead of over the original")
+	cipherName := flags.String("cipher", encrypt.AES256GCM.Name(), "cipher to encrypt with, aes-256-gcm or xchacha20-poly1305 for machines without aes instructions")
+
+	positional, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		fmt.Println("Expected file\nUsage: --encrypt <file>")
+		return usage("--encrypt expects a file")
+	}
+
+	c, err := encrypt.CipherByName(*cipherName)
+	if err != nil {
+		return usage("%s", err)
+	}
+
+	in, err := os.Open(positional[0])
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	password := passwordConfirmation("What will the password be for this file?")
+
+	saltResult, err := salt.Scrypt([]byte(password), nil, 18)
+	if err != nil {
+		return err
+	}
+
+	if len(*outfile) == 0 {
+		*outfile = positional[0]
+	}
+
+	fmt.Println("Encrypting", positional[0])
+	fmt.Printf("Writing to %s\n", *outfile)
+	return replaceFile(*outfile, 0644, func(out io.Writer) error {
+		w, err := encrypt.NewEncryptWriter(out, c, saltResult)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, in)
+		if err != nil {
+			return err
+		}
+		return w.Close()
+	})
+}
+
+// --decrypt <file> [-o out], the cipher is read from the file
+func decryptFile(args []string) error {
+	flags := newFlagSet("--decrypt", "<file> [-o out]")
+	outfile := flags.String("o", "", "write the decrypted file here instead of over the original")
+
+	positional, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		fmt.Println("Expected file\nUsage: --decrypt <file>")
+		return usage("--decrypt expects a file")
+	}
+
+	f, err := os.Open(positional[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	in := bufio.NewReader(f)
+
+	fmt.Println("Enter the files password")
+	password, err := term.ReadPassword(int(os.Stdin.Fd()))
+	if err != nil {
+		return err
+	}
+
+	if len(*outfile) == 0 {
+		*outfile = positional[0]
+	}
+
+	fmt.Println("Decrypting", positional[0])
+	prefix, _ := in.Peek(encrypt.StreamPrefixLength)
+	if !encrypt.IsStream(prefix) {
+		// files encrypted before streaming are a single envelope
+		contents, err := io.ReadAll(in)
+		if err != nil {
+			return err
+		}
+		plaintext, err := encrypt.DecryptScrypt([]byte(password), contents, 18)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Writing to %s\n", *outfile)
+		return replaceFile(*outfile, 0644, func(out io.Writer) error {
+			_, err := out.Write(plaintext)
+			return err
+		})
+	}
+
+	saltBytes, err := encrypt.ReadStreamSalt(in)
+	if err != nil {
+		return err
+	}
+	saltResult, err := salt.Scrypt([]byte(password), saltBytes, 18)
+	if err != nil {
+		return err
+	}
+	r, err := encrypt.NewDecryptReader(in, saltResult.Key)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Writing to %s\n", *outfile)
+	return replaceFile(*outfile, 0644, func(out io.Writer) error {
+		_, err := io.Copy(out, r)
+		return err
+	})
+}
+
+// write goes to a temporary file next to path which only replaces path once
+// write succeeds, so a failed decryption leaves no partial plaintext behind and
+// a file can be written over while it is still being read
+func replaceFile(path string, perm os.FileMode, write func(io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	out := bufio.NewWriter(tmp)
+	err = write(out)
+	if err != nil {
+		return err
+	}
+	err = out.Flush()
+	if err != nil {
+		return err
+	}
+
+	err = tmp.Chmod(perm)
+	if err != nil {
+		return err
+	}
+	err = tmp.Sync()
+	if err != nil {
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// keePass is the keepass file the database was loaded from, nil for pwm vaults
+// keyFile is the key from --keyfile, nil without one
+// backups is the number of backups from --backups or $PWM_BACKUPS, -1 when neither is set
+func cliLoop(channelDb chan *database.Database, keePass *keePassVault, keyFile []byte, backups int) error {
 
 	scanner := bufio.NewScanner(os.Stdin)
 	dbOpened := false
@@ -137,7 +335,11 @@ func cliLoop(channelDb chan *database.Database) error {
 	for {
 		fmt.Println("Welcome, to pwm: (help) for commands")
 		scanner.Scan()
-		command := scanner.Text()
+		args := strings.Fields(scanner.Text())
+		command := ""
+		if len(args) > 0 {
+			command = args[0]
+		}
 
 		openDb := func() error {
 			if dbOpened == false {
@@ -161,16 +363,29 @@ func cliLoop(channelDb chan *database.Database) error {
 		case "help":
 			fmt.Println("q: exits program")
 			fmt.Println("ls: lists accounts")
+			fmt.Println("find <query>: searches titles, usernames, urls and tags")
 			fmt.Println("add: adds an account")
 			fmt.Println("rm: removes an account")
 			fmt.Println("get: gets a password")
+			fmt.Println("edit: changes a password, keeping the old one in its history")
+			fmt.Println("history: shows the previous passwords of an account")
+			fmt.Println("otp: shows the current 2fa code of an account")
 			fmt.Println("save: encrypts the db and saves it to a file")
+			fmt.Println("passwd [--kdf argon2id|scrypt] [--time n] [--memory n] [--threads n]: changes the master password and kdf")
+			fmt.Println("gen [length]: generates a random password")
+			fmt.Println("gen words [count]: generates a random passphrase")
 		case "ls":
 			err := openDb()
 			if err != nil {
 				return err
 			}
 			listAccounts(db)
+		case "find":
+			err := openDb()
+			if err != nil {
+				return err
+			}
+			findAccounts(db, strings.Join(args[1:], " "))
 		case "add":
 			err := openDb()
 			if err != nil {
@@ -189,13 +404,42 @@ func cliLoop(channelDb chan *database.Database) error {
 				return err
 			}
 			getPassword(db)
+		case "edit":
+			err := openDb()
+			if err != nil {
+				return err
+			}
+			editPassword(db)
+		case "otp":
+			err := openDb()
+			if err != nil {
+				return err
+			}
+			showOTP(db)
+		case "history":
+			err := openDb()
+			if err != nil {
+				return err
+			}
+			passwordHistory(db)
 		case "save":
 			err := openDb()
 			if err != nil {
 				return err
 			}
-			channelDb = saveDatabase(db)
-			dbOpened = false
+			if keePass != nil {
+				keePass.save(db)
+			} else {
+				db = saveDatabase(db, keyFile, backups)
+			}
+		case "passwd":
+			err := openDb()
+			if err != nil {
+				return err
+			}
+			changeMasterPassword(db, keePass, keyFile, args[1:])
+		case "gen":
+			generatePassword(args[1:])
 		default:
 			fmt.Println("Unknown command.")
 		}
@@ -224,10 +468,33 @@ func passwordConfirmation(message string) string {
 	return string(password)
 }
 
+const pageSize = 20
+
+// lists the accounts sorted by title, pageSize at a time
 func listAccounts(db *database.Database) {
-	accounts := db.GetAccounts()
-	for _, account := range accounts {
-		fmt.Println(account)
+	entries := db.Entries()
+	scanner := bufio.NewScanner(os.Stdin)
+	for i, entry := range entries {
+		if i > 0 && i%pageSize == 0 {
+			fmt.Printf("%d of %d shown, enter for more or q to stop\n", i, len(entries))
+			scanner.Scan()
+			if strings.ToLower(scanner.Text()) == "q" {
+				return
+			}
+		}
+		fmt.Printf("%s  %s (%s)\n", entry.ID, entry.Username, entry.Title)
+	}
+}
+
+func findAccounts(db *database.Database, query string) {
+	results := db.Search(query)
+	if len(results) == 0 {
+		fmt.Println("No accounts match")
+		return
+	}
+
+	for _, result := range results {
+		fmt.Printf("%s  %s (%s) %s\n", result.Entry.ID, result.Entry.Username, result.Entry.Title, strings.Join(result.Entry.URLs, " "))
 	}
 }
 
@@ -237,79 +504,304 @@ func addAccount(db *database.Database) {
 	scanner.Scan()
 	username := scanner.Text()
 
-	password := passwordConfirmation("Enter user password")
-
-	fmt.Println("Enter master password to confirm new account")
-	masterPassword, err := term.ReadPassword(int(os.Stdin.Fd()))
-	if err != nil {
-		panic(err)
+	var password string
+	fmt.Println("Generate a random password? (y/N)")
+	scanner.Scan()
+	if strings.ToLower(scanner.Text()) == "y" {
+		opts := generate.DefaultOptions()
+		var err error
+		password, err = generate.Password(opts)
+		if err != nil {
+			fmt.Println("Failed to generate password")
+			return
+		}
+		entropy, _ := generate.PasswordEntropy(opts)
+		fmt.Printf("Generated a %d character password with %.1f bits of entropy\n", opts.Length, entropy)
+	} else {
+		password = passwordConfirmation("Enter user password")
 	}
 
-	err = db.AddAccount(string(masterPassword), username, string(password))
+	fmt.Println("Enter an otpauth:// uri for 2fa codes, or leave empty")
+	scanner.Scan()
+	otpURI := strings.TrimSpace(scanner.Text())
+
+	_, err := db.AddEntry(database.Entry{Title: username, Username: username, Password: password, OTP: otpURI})
 	if err != nil {
 		fmt.Println("Failed to add account")
 	}
 }
 
-func removeAccount(db *database.Database) {
-	fmt.Println("Enter username")
+// asks for a username or entry id, when several entries share the username the user picks one
+func selectEntry(db *database.Database) (string, error) {
+	fmt.Println("Enter username or id")
 	scanner := bufio.NewScanner(os.Stdin)
 	scanner.Scan()
 	username := scanner.Text()
 
-	fmt.Println("Enter master password to delete account")
-	masterPassword, err := term.ReadPassword(int(os.Stdin.Fd()))
+	for _, entry := range db.Entries() {
+		if entry.ID == username {
+			return entry.ID, nil
+		}
+	}
+
+	entries := db.FindByUsername(username)
+	switch len(entries) {
+	case 0:
+		return "", database.ErrNotFound
+	case 1:
+		return entries[0].ID, nil
+	}
+
+	fmt.Printf("%d entries have the username %s\n", len(entries), username)
+	for i, entry := range entries {
+		fmt.Printf("%d: %s %s %s\n", i+1, entry.Title, strings.Join(entry.URLs, " "), entry.ID)
+	}
+
+	fmt.Println("Enter the number of the entry")
+	scanner.Scan()
+	choice, err := strconv.Atoi(scanner.Text())
+	if err != nil || choice < 1 || choice > len(entries) {
+		return "", errors.New("invalid entry number")
+	}
+
+	return entries[choice-1].ID, nil
+}
+
+func removeAccount(db *database.Database) {
+	id, err := selectEntry(db)
 	if err != nil {
-		panic(err)
+		fmt.Println("Failed to find account")
+		return
 	}
 
-	err = db.RemoveAccount(string(masterPassword), username)
+	err = db.RemoveEntry(id)
 	if err != nil {
 		fmt.Println("Failed to remove account")
 	}
 }
 
 func getPassword(db *database.Database) {
-	fmt.Println("Enter username")
-	scanner := bufio.NewScanner(os.Stdin)
-	scanner.Scan()
-	username := scanner.Text()
-
-	fmt.Println("Enter master password to retrieve password")
-	masterPassword, err := term.ReadPassword(int(os.Stdin.Fd()))
+	id, err := selectEntry(db)
 	if err != nil {
-		panic(err)
+		fmt.Println("Failed to find account")
+		return
 	}
 
-	password, err := db.GetPassword(string(masterPassword), username)
+	entry, err := db.GetEntry(id)
 	if err != nil {
 		fmt.Println("Failed to get account password")
+		return
+	}
+	fmt.Printf("Password: [%s]\n", entry.Password)
+}
+
+// gen [length] or gen words [count]
+func generatePassword(args []string) {
+	if len(args) > 0 && strings.ToLower(args[0]) == "words" {
+		words := 6
+		if len(args) > 1 {
+			count, err := strconv.Atoi(args[1])
+			if err != nil {
+				fmt.Println("Expected a number of words")
+				return
+			}
+			words = count
+		}
+
+		passphrase, err := generate.Passphrase(words, "-")
+		if err != nil {
+			fmt.Println("Failed to generate passphrase")
+			return
+		}
+		fmt.Printf("Passphrase: [%s]\n", passphrase)
+		fmt.Printf("Entropy: %.1f bits\n", generate.PassphraseEntropy(words))
+		return
+	}
+
+	opts := generate.DefaultOptions()
+	if len(args) > 0 {
+		length, err := strconv.Atoi(args[0])
+		if err != nil {
+			fmt.Println("Expected a password length")
+			return
+		}
+		opts.Length = length
+	}
+
+	password, err := generate.Password(opts)
+	if err != nil {
+		fmt.Println("Failed to generate password")
+		return
+	}
+	entropy, err := generate.PasswordEntropy(opts)
+	if err != nil {
+		fmt.Println("Failed to generate password")
+		return
 	}
 	fmt.Printf("Password: [%s]\n", password)
+	fmt.Printf("Entropy: %.1f bits\n", entropy)
+}
+
+func editPassword(db *database.Database) {
+	id, err := selectEntry(db)
+	if err != nil {
+		fmt.Println("Failed to find account")
+		return
+	}
+
+	password := passwordConfirmation("Enter new user password")
+
+	err = db.UpdatePassword(id, password)
+	if err != nil {
+		fmt.Println("Failed to change password")
+	}
 }
 
-func saveDatabase(db *database.Database) chan *database.Database {
+func showOTP(db *database.Database) {
+	id, err := selectEntry(db)
+	if err != nil {
+		fmt.Println("Failed to find account")
+		return
+	}
+
+	code, remaining, changed, err := currentOTP(db, id)
+	if err != nil {
+		fmt.Println("Failed to get 2fa code")
+		return
+	}
+
+	if changed {
+		fmt.Printf("Code: [%s], save the database to keep the new counter\n", code)
+	} else {
+		fmt.Printf("Code: [%s] %d seconds remaining\n", code, int(remaining.Seconds()))
+	}
+}
+
+func passwordHistory(db *database.Database) {
+	id, err := selectEntry(db)
+	if err != nil {
+		fmt.Println("Failed to find account")
+		return
+	}
+
+	history, err := db.GetPasswordHistory(id)
+	if err != nil {
+		fmt.Println("Failed to get password history")
+		return
+	}
+	for _, h := range history {
+		fmt.Printf("%s Password: [%s]\n", h.Changed.Format(time.DateTime), h.Password)
+	}
+}
+
+// the key file stays the same, only the password and, with --kdf and its
+// parameters in args, the kdf change
+func changeMasterPassword(db *database.Database, keePass *keePassVault, keyFile []byte, args []string) {
+	flags := newFlagSet("passwd", "")
+	kdfOptions := addKDFFlags(flags)
+	_, err := parseArgs(flags, args)
+	if err != nil {
+		return
+	}
+	current := db.KDF()
+	if keePass != nil {
+		current = database.Argon2KDF(keePass.file.KDF)
+	}
+	kdf, err := kdfOptions.resolve(current)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	// the database of a kdbx session is never saved as a vault and keeps its own
+	// kdf, the file's parameters may stay as weak as they were read
+	sessionKDF := kdf
+	if keePass != nil {
+		if kdf.Algorithm != database.KDFArgon2id {
+			fmt.Println("kdbx files can only use argon2id")
+			return
+		}
+		if kdf.Argon2 != keePass.file.KDF {
+			if err := kdf.Argon2.Validate(); err != nil {
+				fmt.Println(err)
+				return
+			}
+		}
+		sessionKDF = db.KDF()
+	}
+
+	fmt.Println("Enter the current master password")
+	oldPassword, err := term.ReadPassword(int(os.Stdin.Fd()))
+	if err != nil {
+		panic(err)
+	}
+
+	newPassword := passwordConfirmation("What should the new master password be?")
+
+	fmt.Println("Moving the entries to a new data key")
+	err = db.ChangeMasterPassword(masterKey(string(oldPassword), keyFile), masterKey(newPassword, keyFile), sessionKDF)
+	if err != nil {
+		fmt.Printf("Failed to change master password, the old one is still in use, %s\n", err)
+		return
+	}
+	fmt.Println("Master password changed, save the database to write it with the new password")
+	if keePass != nil {
+		keePass.password = newPassword
+		keePass.file.KDF = kdf.Argon2
+	} else {
+		fmt.Println("Recovery shares made before this no longer open the vault, split it again")
+	}
+}
+
+// returns the database to keep using, which is the one on disk if the user chose to reload it
+func saveDatabase(db *database.Database, keyFile []byte, backups int) *database.Database {
 	fmt.Println("Enter name of file to save to")
 	scanner := bufio.NewScanner(os.Stdin)
 	scanner.Scan()
 	filename := scanner.Text()
 
-	fmt.Println("Enter master password to save database")
-	masterPassword, err := term.ReadPassword(int(os.Stdin.Fd()))
-	if err != nil {
-		panic(err)
+	err := db.ToFile(filename)
+	if errors.Is(err, database.ErrExists) {
+		fmt.Printf("[%s] already exists and is not the vault this session was loaded from, save to another file\n", filename)
+		return db
 	}
+	if errors.Is(err, database.ErrModified) {
+		fmt.Printf("[%s] was changed since it was loaded, it may be open in another pwm\n", filename)
+		fmt.Println("(m)erge it into this session and save, (r)eload it discarding this session's changes, or (c)ancel")
+		scanner.Scan()
+		choice := strings.ToLower(scanner.Text())
+		if choice != "m" && choice != "r" {
+			fmt.Println("Database was not saved")
+			return db
+		}
 
-	channelDb := make(chan *database.Database)
-	go func(db *database.Database, masterPassword []byte, filename string) {
-		err = db.ToFile(string(masterPassword), filename)
+		fmt.Println("Enter the password to this file")
+		password, err := term.ReadPassword(int(os.Stdin.Fd()))
 		if err != nil {
-			fmt.Printf("Failed to save database to the file [%s]\n", filename)
+			panic(err)
 		}
 
-		channelDb <- db
-		close(channelDb)
-	}(db, masterPassword, filename)
+		onDisk, err := database.FromFile(masterKey(string(password), keyFile), filename)
+		if err != nil {
+			fmt.Println("Could not open file")
+			return db
+		}
+
+		if choice == "r" {
+			// the reloaded vault is saved with the session's backups, as when it was opened
+			if backups >= 0 {
+				onDisk.SetBackups(backups)
+			}
+			return onDisk
+		}
+
+		err = db.Merge(onDisk)
+		if err == nil {
+			err = db.ToFile(filename)
+		}
+	}
+	if err != nil {
+		fmt.Printf("Failed to save database to the file [%s]\n", filename)
+	}
 
-	return channelDb
+	return db
 }
@@ -0,0 +1,655 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"pwm/clipboard"
+	"pwm/database"
+	"pwm/exporter"
+	"pwm/generate"
+	"pwm/kdbx"
+	"pwm/otp"
+
+	"golang.org/x/term"
+)
+
+// exit codes are stable so scripts can tell failures apart
+const (
+	ExitOK        = 0
+	ExitFailure   = 1
+	ExitUsage     = 2
+	ExitAuth      = 3
+	ExitNotFound  = 4
+	ExitAmbiguous = 5
+	ExitIO        = 6
+	ExitConflict  = 7
+)
+
+// the master password is read from this file descriptor when --password-fd isn't given
+const passwordFdEnv = "PWM_PASSWORD_FD"
+
+// the number of previous versions kept as name.1, name.2 ... when a vault is saved
+const backupsEnv = "PWM_BACKUPS"
+
+type usageError struct {
+	message string
+}
+
+func (err *usageError) Error() string {
+	return err.message
+}
+
+func usage(format string, args ...any) error {
+	return &usageError{message: fmt.Sprintf(format, args...)}
+}
+
+func ExitCode(err error) int {
+	var usageErr *usageError
+	var pathErr *fs.PathError
+
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.As(err, &usageErr), errors.Is(err, flag.ErrHelp):
+		return ExitUsage
+	case errors.Is(err, database.ErrWrongPassword), errors.Is(err, exporter.ErrWrongPassphrase), errors.Is(err, kdbx.ErrWrongPassword):
+		return ExitAuth
+	case errors.Is(err, database.ErrNotFound):
+		return ExitNotFound
+	case errors.Is(err, database.ErrAmbiguous):
+		return ExitAmbiguous
+	case errors.Is(err, database.ErrModified), errors.Is(err, database.ErrExists):
+		return ExitConflict
+	case errors.As(err, &pathErr):
+		return ExitIO
+	}
+	return ExitFailure
+}
+
+// flags may come before, after or between the positional arguments
+func parseArgs(flags *flag.FlagSet, args []string) ([]string, error) {
+	positional := make([]string, 0)
+	for {
+		err := flags.Parse(args)
+		if errors.Is(err, flag.ErrHelp) {
+			return nil, err
+		}
+		// an unknown flag or a bad value is a usage error, the flag set has printed it
+		if err != nil {
+			return nil, usage("%s", err.Error())
+		}
+
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+func newFlagSet(name string, positional string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(os.Stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: pwm %s %s\n", name, positional)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// reads the master password from passwordFd, then $PWM_PASSWORD_FD, then the terminal
+func readMasterPassword(passwordFd int) (string, error) {
+	if passwordFd < 0 {
+		if env, ok := os.LookupEnv(passwordFdEnv); ok {
+			fd, err := strconv.Atoi(env)
+			if err != nil {
+				return "", usage("%s is not a file descriptor", passwordFdEnv)
+			}
+			passwordFd = fd
+		}
+	}
+
+	if passwordFd >= 0 {
+		return readFd(passwordFd)
+	}
+
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return "", usage("no terminal to read the master password from, use --password-fd or %s", passwordFdEnv)
+	}
+
+	fmt.Fprintln(os.Stderr, "Enter the password to this file")
+	password, err := term.ReadPassword(int(os.Stdin.Fd()))
+	if err != nil {
+		return "", err
+	}
+	return string(password), nil
+}
+
+// reads one line from a file descriptor passed in by the caller
+// stdin is read but left open, add reads the entry password from the line after
+func readFd(fd int) (string, error) {
+	if fd == 0 {
+		return readLine(os.Stdin)
+	}
+
+	file := os.NewFile(uintptr(fd), "password-fd")
+	if file == nil {
+		return "", usage("invalid password file descriptor %d", fd)
+	}
+	defer file.Close()
+	return readLine(file)
+}
+
+// the trailing newline is not part of the value
+// read a byte at a time so nothing after the line is consumed
+func readLine(reader io.Reader) (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := reader.Read(b)
+		if n == 1 {
+			if b[0] == '\n' {
+				break
+			}
+			line = append(line, b[0])
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return strings.TrimRight(string(line), "\r"), nil
+}
+
+func openVault(vault string, passwordFd int, keyFilePath string) (*database.Database, error) {
+	password, err := readMasterPassword(passwordFd)
+	if err != nil {
+		return nil, err
+	}
+
+	keyFile, err := vaultKeyFile(keyFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	backups, err := backupCount(-1)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := database.FromFile(masterKey(password, keyFile), vault)
+	if err != nil {
+		return nil, err
+	}
+	if backups >= 0 {
+		db.SetBackups(backups)
+	}
+	return db, nil
+}
+
+// flagValue when it isn't negative, otherwise $PWM_BACKUPS, -1 when neither
+// is set and the database default applies
+func backupCount(flagValue int) (int, error) {
+	if flagValue >= 0 {
+		return flagValue, nil
+	}
+
+	env, ok := os.LookupEnv(backupsEnv)
+	if !ok {
+		return -1, nil
+	}
+	backups, err := strconv.Atoi(env)
+	if err != nil || backups < 0 {
+		return -1, usage("%s is not a number of backups", backupsEnv)
+	}
+	return backups, nil
+}
+
+// an entry is named by its id or, when it is the only one with it, its username
+func resolveEntry(db *database.Database, name string) (string, error) {
+	for _, entry := range db.Entries() {
+		if entry.ID == name {
+			return entry.ID, nil
+		}
+	}
+
+	entries := db.FindByUsername(name)
+	switch len(entries) {
+	case 0:
+		return "", database.ErrNotFound
+	case 1:
+		return entries[0].ID, nil
+	}
+
+	fmt.Fprintf(os.Stderr, "%d entries have the username %s, use an id instead\n", len(entries), name)
+	for _, entry := range entries {
+		fmt.Fprintf(os.Stderr, "%s  %s %s\n", entry.ID, entry.Title, strings.Join(entry.URLs, " "))
+	}
+	return "", database.ErrAmbiguous
+}
+
+// repeatable string flag
+type stringList []string
+
+func (list *stringList) String() string {
+	return strings.Join(*list, ",")
+}
+
+func (list *stringList) Set(value string) error {
+	*list = append(*list, value)
+	return nil
+}
+
+// pwm get <vault> <entry>
+func getCommand(args []string) error {
+	flags := newFlagSet("get", "<vault> <entry>")
+	passwordFd := flags.Int("password-fd", -1, "read the master password from this file descriptor")
+	keyFilePath := addKeyFileFlag(flags)
+	field := flags.String("field", "password", "field to print: password, username, title, url or notes")
+	asJSON := flags.Bool("json", false, "print the entry as json, the password is left out unless --show-secrets is given")
+	showSecrets := flags.Bool("show-secrets", false, "include the password in --json output")
+	clip := flags.Bool("clip", false, "copy the field to the clipboard instead of printing it")
+	clipTimeout := flags.Duration("clip-timeout", 45*time.Second, "how long the clipboard holds the field before it is cleared")
+	clipBackend := flags.String("clip-backend", "", "wl-copy, xclip, xsel or osc52, detected when not given")
+
+	positional, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 2 {
+		flags.Usage()
+		return usage("get expects a vault and an entry")
+	}
+
+	var backend clipboard.Backend
+	if *clip {
+		if len(*clipBackend) != 0 {
+			backend, err = clipboard.ByName(*clipBackend)
+		} else {
+			backend, err = clipboard.Detect()
+		}
+		if err != nil && !errors.Is(err, clipboard.ErrNoClipboard) {
+			return usage("%s", err)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	db, err := openVault(positional[0], *passwordFd, *keyFilePath)
+	if err != nil {
+		return err
+	}
+
+	id, err := resolveEntry(db, positional[1])
+	if err != nil {
+		return err
+	}
+
+	entry, err := db.GetEntry(id)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return printJSON(newJSONEntry(entry, *showSecrets))
+	}
+
+	var value string
+	switch *field {
+	case "password":
+		value = entry.Password
+	case "username":
+		value = entry.Username
+	case "title":
+		value = entry.Title
+	case "url":
+		value = strings.Join(entry.URLs, "\n")
+	case "notes":
+		value = entry.Notes
+	default:
+		return usage("unknown field %s", *field)
+	}
+
+	if *clip {
+		err = backend.Copy(value)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Copied %s to the clipboard, clearing in %s\n", *field, *clipTimeout)
+		return clipboard.ClearAfter(backend, value, *clipTimeout)
+	}
+
+	fmt.Println(value)
+	return nil
+}
+
+// pwm add <vault> --username <username>
+// the entry password is generated, or read from stdin when it isn't a terminal
+func addCommand(args []string) error {
+	flags := newFlagSet("add", "<vault> --username <username>")
+	passwordFd := flags.Int("password-fd", -1, "read the master password from this file descriptor")
+	keyFilePath := addKeyFileFlag(flags)
+	username := flags.String("username", "", "username of the new entry")
+	title := flags.String("title", "", "title of the new entry, defaults to the username")
+	notes := flags.String("notes", "", "notes for the new entry")
+	otpURI := flags.String("otp", "", "otpauth:// uri of the new entry")
+	generatePassword := flags.Bool("generate", false, "generate the entry password instead of reading it")
+	length := flags.Int("length", generate.DefaultOptions().Length, "length of a generated password")
+	var urls, tags stringList
+	flags.Var(&urls, "url", "url of the new entry, can be repeated")
+	flags.Var(&tags, "tag", "tag for the new entry, can be repeated")
+
+	positional, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 || len(*username) == 0 {
+		flags.Usage()
+		return usage("add expects a vault and a username")
+	}
+
+	entry := database.Entry{
+		Title:    *title,
+		Username: *username,
+		URLs:     urls,
+		Notes:    *notes,
+		Tags:     tags,
+		OTP:      *otpURI,
+	}
+	if len(entry.Title) == 0 {
+		entry.Title = entry.Username
+	}
+
+	db, err := openVault(positional[0], *passwordFd, *keyFilePath)
+	if err != nil {
+		return err
+	}
+
+	if *generatePassword {
+		opts := generate.DefaultOptions()
+		opts.Length = *length
+		entry.Password, err = generate.Password(opts)
+		if err != nil {
+			return usage("%s", err)
+		}
+	} else if term.IsTerminal(int(os.Stdin.Fd())) {
+		entry.Password = passwordConfirmation("Enter user password")
+	} else {
+		entry.Password, err = readLine(os.Stdin)
+		if err != nil {
+			return err
+		}
+	}
+
+	id, err := db.AddEntry(entry)
+	if err != nil {
+		return err
+	}
+
+	err = db.ToFile(positional[0])
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(id)
+	return nil
+}
+
+// pwm rm <vault> <entry>
+func rmCommand(args []string) error {
+	flags := newFlagSet("rm", "<vault> <entry>")
+	passwordFd := flags.Int("password-fd", -1, "read the master password from this file descriptor")
+	keyFilePath := addKeyFileFlag(flags)
+
+	positional, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 2 {
+		flags.Usage()
+		return usage("rm expects a vault and an entry")
+	}
+
+	db, err := openVault(positional[0], *passwordFd, *keyFilePath)
+	if err != nil {
+		return err
+	}
+
+	id, err := resolveEntry(db, positional[1])
+	if err != nil {
+		return err
+	}
+
+	err = db.RemoveEntry(id)
+	if err != nil {
+		return err
+	}
+
+	return db.ToFile(positional[0])
+}
+
+// pwm ls <vault>, one tab separated id, username and title per line
+func lsCommand(args []string) error {
+	flags := newFlagSet("ls", "<vault>")
+	passwordFd := flags.Int("password-fd", -1, "read the master password from this file descriptor")
+	keyFilePath := addKeyFileFlag(flags)
+	asJSON := flags.Bool("json", false, "print the entries as a json array, passwords are left out unless --show-secrets is given")
+	showSecrets := flags.Bool("show-secrets", false, "include passwords in --json output")
+	page := flags.Int("page", 1, "page to print when --per-page is given, starting at 1")
+	perPage := flags.Int("per-page", 0, "entries per page, 0 prints every entry")
+
+	positional, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		flags.Usage()
+		return usage("ls expects a vault")
+	}
+	if *page < 1 || *perPage < 0 {
+		return usage("--page must be at least 1 and --per-page can't be negative")
+	}
+
+	db, err := openVault(positional[0], *passwordFd, *keyFilePath)
+	if err != nil {
+		return err
+	}
+
+	entries := db.Entries()
+	if *perPage > 0 {
+		start := min((*page-1)**perPage, len(entries))
+		end := min(start+*perPage, len(entries))
+		entries = entries[start:end]
+	}
+
+	return printEntries(db, entries, *asJSON, *showSecrets)
+}
+
+// pwm find <vault> <query>, the best matches first
+func findCommand(args []string) error {
+	flags := newFlagSet("find", "<vault> <query>")
+	passwordFd := flags.Int("password-fd", -1, "read the master password from this file descriptor")
+	keyFilePath := addKeyFileFlag(flags)
+	asJSON := flags.Bool("json", false, "print the matches as a json array, passwords are left out unless --show-secrets is given")
+	showSecrets := flags.Bool("show-secrets", false, "include passwords in --json output")
+	limit := flags.Int("limit", 0, "print at most this many matches, 0 prints every match")
+
+	positional, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) < 2 {
+		flags.Usage()
+		return usage("find expects a vault and a query")
+	}
+
+	db, err := openVault(positional[0], *passwordFd, *keyFilePath)
+	if err != nil {
+		return err
+	}
+
+	results := db.Search(strings.Join(positional[1:], " "))
+	if *limit > 0 && len(results) > *limit {
+		results = results[:*limit]
+	}
+
+	entries := make([]database.Entry, 0, len(results))
+	for _, result := range results {
+		entries = append(entries, result.Entry)
+	}
+
+	return printEntries(db, entries, *asJSON, *showSecrets)
+}
+
+// one tab separated id, username and title per line, or a json array
+func printEntries(db *database.Database, entries []database.Entry, asJSON bool, showSecrets bool) error {
+	if asJSON {
+		result, err := entriesJSON(db, entries, showSecrets)
+		if err != nil {
+			return err
+		}
+		return printJSON(result)
+	}
+
+	for _, entry := range entries {
+		fmt.Printf("%s\t%s\t%s\n", entry.ID, entry.Username, entry.Title)
+	}
+	return nil
+}
+
+// hotp entries have their counter moved on, the caller has to save the database
+func currentOTP(db *database.Database, id string) (string, time.Duration, bool, error) {
+	entry, err := db.GetEntry(id)
+	if err != nil {
+		return "", 0, false, err
+	}
+	if len(entry.OTP) == 0 {
+		return "", 0, false, errors.New("entry has no otp secret")
+	}
+
+	key, err := otp.ParseURI(entry.OTP)
+	if err != nil {
+		return "", 0, false, err
+	}
+
+	code, remaining, err := key.Code(time.Now())
+	if err != nil {
+		return "", 0, false, err
+	}
+
+	if key.Type != "hotp" {
+		return code, remaining, false, nil
+	}
+
+	key.Counter++
+	entry.OTP = key.URI()
+	err = db.UpdateEntry(id, entry)
+	return code, remaining, true, err
+}
+
+// pwm otp <vault> <entry>, prints the code on stdout and how long it is valid for on stderr
+func otpCommand(args []string) error {
+	flags := newFlagSet("otp", "<vault> <entry>")
+	passwordFd := flags.Int("password-fd", -1, "read the master password from this file descriptor")
+	keyFilePath := addKeyFileFlag(flags)
+
+	positional, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 2 {
+		flags.Usage()
+		return usage("otp expects a vault and an entry")
+	}
+
+	db, err := openVault(positional[0], *passwordFd, *keyFilePath)
+	if err != nil {
+		return err
+	}
+
+	id, err := resolveEntry(db, positional[1])
+	if err != nil {
+		return err
+	}
+
+	code, remaining, changed, err := currentOTP(db, id)
+	if err != nil {
+		return err
+	}
+
+	if changed {
+		err = db.ToFile(positional[0])
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Println(code)
+	if remaining > 0 {
+		fmt.Fprintf(os.Stderr, "%d seconds remaining\n", int(remaining.Seconds()))
+	}
+	return nil
+}
+
+// pwm gen, prints the password on stdout and its entropy on stderr
+func genCommand(args []string) error {
+	flags := newFlagSet("gen", "")
+	opts := generate.DefaultOptions()
+	flags.IntVar(&opts.Length, "length", opts.Length, "password length")
+	words := flags.Int("words", 0, "generate a passphrase of this many words instead")
+	separator := flags.String("separator", "-", "separator between passphrase words")
+	noLower := flags.Bool("no-lower", false, "leave out lowercase letters")
+	noUpper := flags.Bool("no-upper", false, "leave out uppercase letters")
+	noDigits := flags.Bool("no-digits", false, "leave out digits")
+	noSymbols := flags.Bool("no-symbols", false, "leave out symbols")
+	flags.BoolVar(&opts.ExcludeAmbiguous, "exclude-ambiguous", opts.ExcludeAmbiguous, "leave out characters that are easily confused")
+
+	positional, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 0 {
+		flags.Usage()
+		return usage("gen takes no arguments")
+	}
+
+	if *words > 0 {
+		passphrase, err := generate.Passphrase(*words, *separator)
+		if err != nil {
+			return err
+		}
+		fmt.Println(passphrase)
+		fmt.Fprintf(os.Stderr, "Entropy: %.1f bits\n", generate.PassphraseEntropy(*words))
+		return nil
+	}
+
+	opts.Lowercase = !*noLower
+	opts.Uppercase = !*noUpper
+	opts.Digits = !*noDigits
+	opts.Symbols = !*noSymbols
+
+	password, err := generate.Password(opts)
+	if err != nil {
+		return usage("%s", err)
+	}
+	entropy, err := generate.PasswordEntropy(opts)
+	if err != nil {
+		return usage("%s", err)
+	}
+
+	fmt.Println(password)
+	fmt.Fprintf(os.Stderr, "Entropy: %.1f bits\n", entropy)
+	return nil
+}
@@ -0,0 +1,155 @@
+package cli_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"pwm/cli"
+	"pwm/database"
+	"pwm/salt"
+)
+
+// the weakest kdf the database accepts, so the commands stay fast
+var testKDF = database.Argon2KDF(salt.Argon2Params{Time: 1, Memory: salt.MinArgon2Memory, Threads: 1, KeyLength: salt.KeyLength})
+
+func testVault(t *testing.T) string {
+	t.Helper()
+	db, err := database.New("master", testKDF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range []database.Entry{
+		{Title: "Mail", Username: "alice", Password: "hunter2"},
+		{Title: "Forum", Username: "bob", Password: "one"},
+		{Title: "Shop", Username: "bob", Password: "two"},
+	} {
+		if _, err := db.AddEntry(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fileName := filepath.Join(t.TempDir(), "vault")
+	if err := db.ToFile(fileName); err != nil {
+		t.Fatal(err)
+	}
+	return fileName
+}
+
+// runs pwm with args, stdin holding input, and returns the exit code and stdout
+func run(t *testing.T, input string, args ...string) (int, string) {
+	t.Helper()
+	dir := t.TempDir()
+	stdin, err := os.Create(filepath.Join(dir, "stdin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+	if _, err := stdin.WriteString(input); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stdin.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := os.Create(filepath.Join(dir, "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdout.Close()
+
+	oldArgs, oldStdin, oldStdout := os.Args, os.Stdin, os.Stdout
+	defer func() {
+		os.Args, os.Stdin, os.Stdout = oldArgs, oldStdin, oldStdout
+	}()
+	os.Args = append([]string{"pwm"}, args...)
+	os.Stdin, os.Stdout = stdin, stdout
+
+	code := cli.ExitCode(cli.Init())
+
+	output, err := os.ReadFile(stdout.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code, string(output)
+}
+
+func TestExitCodes(t *testing.T) {
+	t.Setenv("PWM_KEYFILE", "")
+	vault := testVault(t)
+
+	for _, test := range []struct {
+		name     string
+		input    string
+		args     []string
+		expected int
+	}{
+		{"found", "master\n", []string{"get", vault, "alice", "--password-fd", "0"}, cli.ExitOK},
+		{"missing arguments", "", []string{"get", vault}, cli.ExitUsage},
+		{"unknown flag", "", []string{"ls", vault, "--no-such-flag"}, cli.ExitUsage},
+		{"wrong password", "wrong\n", []string{"get", vault, "alice", "--password-fd", "0"}, cli.ExitAuth},
+		{"not found", "master\n", []string{"get", vault, "carol", "--password-fd", "0"}, cli.ExitNotFound},
+		{"ambiguous", "master\n", []string{"rm", vault, "bob", "--password-fd", "0"}, cli.ExitAmbiguous},
+		{"no vault", "master\n", []string{"ls", vault + ".missing", "--password-fd", "0"}, cli.ExitIO},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if code, _ := run(t, test.input, test.args...); code != test.expected {
+				t.Errorf("expected exit code %d, got %d", test.expected, code)
+			}
+		})
+	}
+
+	for _, err := range []error{database.ErrModified, database.ErrExists} {
+		if code := cli.ExitCode(err); code != cli.ExitConflict {
+			t.Errorf("expected %v to exit with %d, got %d", err, cli.ExitConflict, code)
+		}
+	}
+}
+
+// the master password and the entry password both come from stdin, one line each
+func TestAddPasswordFromStdin(t *testing.T) {
+	t.Setenv("PWM_KEYFILE", "")
+	vault := testVault(t)
+
+	code, _ := run(t, "master\nentry password\n", "add", vault, "--username", "carol", "--password-fd", "0")
+	if code != cli.ExitOK {
+		t.Fatalf("expected add to succeed, got %d", code)
+	}
+
+	code, output := run(t, "master\n", "get", vault, "carol", "--password-fd", "0")
+	if code != cli.ExitOK || strings.TrimSpace(output) != "entry password" {
+		t.Errorf("expected the entry password from the second line of stdin, got %d %q", code, output)
+	}
+}
+
+// --force replaces the file rather than writing into it, so it doesn't keep the old mode
+func TestExportForce(t *testing.T) {
+	t.Setenv("PWM_KEYFILE", "")
+	vault := testVault(t)
+	fileName := filepath.Join(t.TempDir(), "export.json")
+	if err := os.WriteFile(fileName, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if code, _ := run(t, "master\n", "export", vault, fileName, "--format", "json", "--yes", "--password-fd", "0"); code != cli.ExitIO {
+		t.Errorf("expected export without --force to refuse an existing file, got %d", code)
+	}
+	if code, _ := run(t, "master\n", "export", vault, fileName, "--format", "json", "--yes", "--force", "--password-fd", "0"); code != cli.ExitOK {
+		t.Fatalf("expected export --force to succeed, got %d", code)
+	}
+
+	info, err := os.Stat(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected the export to be only readable by its owner, got %v", info.Mode().Perm())
+	}
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), "hunter2") {
+		t.Errorf("expected the export to replace the old file, got %q", content)
+	}
+}
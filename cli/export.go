@@ -0,0 +1,193 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"pwm/database"
+	"pwm/exporter"
+
+	"golang.org/x/term"
+)
+
+const plaintextWarning = `
+################################################################
+#  WARNING: this export is NOT encrypted.                      #
+#  Anyone who can read the file can read every password in it. #
+#  Delete it as soon as it has been imported elsewhere.        #
+################################################################
+`
+
+// pwm export <vault> <file>, "-" writes to stdout
+func exportCommand(args []string) error {
+	flags := newFlagSet("export", "<vault> <file>")
+	passwordFd := flags.Int("password-fd", -1, "read the master password from this file descriptor")
+	keyFilePath := addKeyFileFlag(flags)
+	passphraseFd := flags.Int("passphrase-fd", -1, "read the passphrase of an encrypted export from this file descriptor")
+	format := flags.String("format", string(exporter.Encrypted), fmt.Sprintf("format of the export, one of %v", exporter.Formats))
+	confirmed := flags.Bool("yes", false, "write a plaintext export without asking")
+	force := flags.Bool("force", false, "overwrite the file if it exists")
+	var tags, names stringList
+	flags.Var(&tags, "tag", "only export entries with this tag, can be repeated")
+	flags.Var(&names, "entry", "only export this entry, can be repeated")
+
+	positional, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 2 {
+		flags.Usage()
+		return usage("export expects a vault and a file")
+	}
+
+	exportFormat := exporter.Format(*format)
+	if !slices.Contains(exporter.Formats, exportFormat) {
+		return usage("unknown export format %s", *format)
+	}
+
+	db, err := openVault(positional[0], *passwordFd, *keyFilePath)
+	if err != nil {
+		return err
+	}
+
+	entries, err := selectExport(db, tags, names)
+	if err != nil {
+		return err
+	}
+
+	var passphrase string
+	if exportFormat.Plaintext() {
+		fmt.Fprint(os.Stderr, plaintextWarning)
+		if !*confirmed && !confirmPlaintext(len(entries), positional[1]) {
+			return usage("plaintext export not confirmed")
+		}
+	} else {
+		passphrase, err = readPassphrase(*passphraseFd, true)
+		if err != nil {
+			return err
+		}
+	}
+
+	if positional[1] == "-" {
+		err = exporter.Write(exportFormat, os.Stdout, entries, passphrase)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Exported %d entries\n", len(entries))
+		return nil
+	}
+
+	write := func(out io.Writer) error {
+		return exporter.Write(exportFormat, out, entries, passphrase)
+	}
+	if *force {
+		// written beside the old file and renamed over it, so the old file is kept if
+		// the export fails and the new one is only readable by its owner
+		err = replaceFile(positional[1], 0600, write)
+	} else {
+		err = createFile(positional[1], write)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Exported %d entries\n", len(entries))
+	return nil
+}
+
+// writes a file that must not exist yet, removing it again if writing fails as half
+// an export is of no use and may hold plaintext passwords
+func createFile(fileName string, write func(io.Writer) error) error {
+	out, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+
+	err = write(out)
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(fileName)
+		return err
+	}
+	return nil
+}
+
+// every entry, or the ones named or tagged, with their passwords
+func selectExport(db *database.Database, tags []string, names []string) ([]database.Entry, error) {
+	ids := make([]string, 0)
+	for _, name := range names {
+		id, err := resolveEntry(db, name)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	for _, entry := range db.Entries() {
+		if slices.Contains(ids, entry.ID) {
+			continue
+		}
+		selected := len(tags) == 0 && len(names) == 0
+		for _, tag := range tags {
+			selected = selected || slices.Contains(entry.Tags, tag)
+		}
+		if selected {
+			ids = append(ids, entry.ID)
+		}
+	}
+
+	entries := make([]database.Entry, 0, len(ids))
+	for _, id := range ids {
+		entry, err := db.GetEntry(id)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// scripts have to pass --yes, there is nobody to ask
+func confirmPlaintext(count int, fileName string) bool {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return false
+	}
+
+	fmt.Fprintf(os.Stderr, "Type yes to write %d passwords unencrypted to %s\n", count, fileName)
+	answer, err := readLine(os.Stdin)
+	if err != nil {
+		return false
+	}
+	return strings.TrimSpace(strings.ToLower(answer)) == "yes"
+}
+
+// the passphrase of an encrypted export, from passphraseFd or the terminal
+// confirm asks twice, for a passphrase that is being chosen
+func readPassphrase(passphraseFd int, confirm bool) (string, error) {
+	if passphraseFd >= 0 {
+		return readFd(passphraseFd)
+	}
+
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return "", usage("no terminal to read the export passphrase from, use --passphrase-fd")
+	}
+
+	if confirm {
+		return passwordConfirmation("Enter a passphrase for the export, it should not be the master password"), nil
+	}
+
+	fmt.Fprintln(os.Stderr, "Enter the passphrase of the export")
+	passphrase, err := term.ReadPassword(int(os.Stdin.Fd()))
+	if err != nil {
+		return "", err
+	}
+	return string(passphrase), nil
+}
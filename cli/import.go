@@ -0,0 +1,144 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"pwm/database"
+	"pwm/exporter"
+	"pwm/importer"
+	"pwm/kdbx"
+)
+
+var conflictPolicies = map[string]importer.ConflictPolicy{
+	"skip":    importer.SkipConflicts,
+	"replace": importer.ReplaceConflicts,
+	"keep":    importer.KeepBoth,
+}
+
+// pwm import <vault> <file>, prints what the import does before saving the vault
+func importCommand(args []string) error {
+	flags := newFlagSet("import", "<vault> <file>")
+	passwordFd := flags.Int("password-fd", -1, "read the master password from this file descriptor")
+	keyFilePath := addKeyFileFlag(flags)
+	format := flags.String("format", "", fmt.Sprintf("format of the file, one of %v, guessed from .json, .xml and .1pux file names, encrypted pwm exports and kdbx files are recognised", importer.Formats))
+	mapping := flags.String("map", "", "column=field list for --format csv, fields are title, username, password, url, notes, tags, otp or a custom field name")
+	passphraseFd := flags.Int("passphrase-fd", -1, "read the passphrase of an encrypted pwm export or the password of a kdbx file from this file descriptor")
+	sourceKeyFile := flags.String("source-keyfile", "", "key file the kdbx file being imported is locked with, --keyfile is the vault's")
+	dryRun := flags.Bool("dry-run", false, "only report what would be imported")
+	onConflict := flags.String("on-conflict", "skip", "what to do with entries whose account already has another password, skip, replace or keep both")
+
+	positional, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 2 {
+		flags.Usage()
+		return usage("import expects a vault and a file")
+	}
+
+	policy, ok := conflictPolicies[*onConflict]
+	if !ok {
+		return usage("unknown conflict policy %s", *onConflict)
+	}
+
+	var columns importer.Mapping
+	if len(*mapping) != 0 {
+		columns, err = importer.ParseMapping(*mapping)
+		if err != nil {
+			return usage("%s", err)
+		}
+	}
+
+	content, err := os.ReadFile(positional[1])
+	if err != nil {
+		return err
+	}
+
+	var entries []database.Entry
+	if len(*sourceKeyFile) != 0 && !kdbx.IsKDBX(content) {
+		return usage("--source-keyfile is only for kdbx files")
+	}
+	if kdbx.IsKDBX(content) {
+		key, err := loadKeyFile(*sourceKeyFile, false)
+		if err != nil {
+			return err
+		}
+		passphrase, err := readPassphrase(*passphraseFd, false)
+		if err != nil {
+			return err
+		}
+		f, err := kdbx.Read(bytes.NewReader(content), passphrase, key)
+		if err != nil {
+			return err
+		}
+		entries = importer.FromKeePass(f.Entries())
+	}
+
+	// encrypted pwm exports hold bitwarden json
+	if exporter.IsEncrypted(content) {
+		passphrase, err := readPassphrase(*passphraseFd, false)
+		if err != nil {
+			return err
+		}
+		content, err = exporter.ReadEncrypted(bytes.NewReader(content), passphrase)
+		if err != nil {
+			return err
+		}
+		*format = string(importer.Bitwarden)
+	}
+
+	if entries == nil && len(*format) == 0 {
+		detected, ok := importer.DetectFormat(positional[1])
+		if !ok {
+			return usage("can't tell the format of %s, use --format", positional[1])
+		}
+		*format = string(detected)
+	}
+
+	if entries == nil {
+		entries, err = importer.Parse(importer.Format(*format), bytes.NewReader(content), columns)
+		if err != nil {
+			return err
+		}
+	}
+
+	db, err := openVault(positional[0], *passwordFd, *keyFilePath)
+	if err != nil {
+		return err
+	}
+
+	report, err := importer.Check(db, entries)
+	if err != nil {
+		return err
+	}
+	printReport(report)
+
+	if *dryRun {
+		return nil
+	}
+
+	count, err := report.Apply(db, policy)
+	if err != nil {
+		return err
+	}
+
+	err = db.ToFile(positional[0])
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Imported %d entries\n", count)
+	return nil
+}
+
+func printReport(report importer.Report) {
+	fmt.Printf("%d new, %d duplicates, %d conflicts\n", len(report.New), len(report.Duplicates), len(report.Conflicts))
+	for _, duplicate := range report.Duplicates {
+		fmt.Printf("duplicate\t%s\t%s\n", duplicate.Imported.Username, duplicate.Imported.Title)
+	}
+	for _, conflict := range report.Conflicts {
+		fmt.Printf("conflict\t%s\t%s\t%s\n", conflict.Imported.Username, conflict.Imported.Title, conflict.Existing.ID)
+	}
+}
@@ -0,0 +1,75 @@
+package cli
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"pwm/database"
+)
+
+// the --json representation of an entry, secrets are only filled in when asked for
+type jsonEntry struct {
+	ID       string            `json:"id"`
+	Title    string            `json:"title"`
+	Username string            `json:"username"`
+	Password string            `json:"password,omitempty"`
+	URLs     []string          `json:"urls"`
+	Notes    string            `json:"notes"`
+	Tags     []string          `json:"tags"`
+	Fields   map[string]string `json:"fields"`
+	OTP      string            `json:"otp,omitempty"`
+	Created  time.Time         `json:"created"`
+	Modified time.Time         `json:"modified"`
+}
+
+func newJSONEntry(entry database.Entry, withSecrets bool) jsonEntry {
+	j := jsonEntry{
+		ID:       entry.ID,
+		Title:    entry.Title,
+		Username: entry.Username,
+		URLs:     entry.URLs,
+		Notes:    entry.Notes,
+		Tags:     entry.Tags,
+		Fields:   entry.Fields,
+		Created:  entry.Created,
+		Modified: entry.Modified,
+	}
+	if withSecrets {
+		j.Password = entry.Password
+		j.OTP = entry.OTP
+	}
+
+	// empty lists are written as [] rather than null
+	if j.URLs == nil {
+		j.URLs = []string{}
+	}
+	if j.Tags == nil {
+		j.Tags = []string{}
+	}
+	if j.Fields == nil {
+		j.Fields = map[string]string{}
+	}
+
+	return j
+}
+
+// entries from db.Entries have no password, it is decrypted only when withSecrets is set
+func entriesJSON(db *database.Database, entries []database.Entry, withSecrets bool) ([]jsonEntry, error) {
+	result := make([]jsonEntry, 0, len(entries))
+	for _, entry := range entries {
+		if withSecrets {
+			var err error
+			entry, err = db.GetEntry(entry.ID)
+			if err != nil {
+				return nil, err
+			}
+		}
+		result = append(result, newJSONEntry(entry, withSecrets))
+	}
+	return result, nil
+}
+
+func printJSON(value any) error {
+	return json.NewEncoder(os.Stdout).Encode(value)
+}
@@ -0,0 +1,109 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"pwm/database"
+	"pwm/kdbx"
+)
+
+// a keepass file opened with --file, the session works on a pwm database made
+// from its entries and save writes them back into the file
+type keePassVault struct {
+	fileName string
+	password string
+	keyFile  []byte
+	file     *kdbx.File
+	// saves go through it to be locked, checked for changes by others and backed up like a vault
+	disk *database.File
+	// the keepass uuid of each database entry that came from or was saved to the file
+	uuids map[string]string
+}
+
+func isKDBXFile(fileName string) bool {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	prefix := make([]byte, 12)
+	_, err = io.ReadFull(f, prefix)
+	return err == nil && kdbx.IsKDBX(prefix)
+}
+
+func (vault *keePassVault) open() (*database.Database, error) {
+	disk, contents, err := database.ReadFile(vault.fileName)
+	if err != nil {
+		return nil, err
+	}
+	vault.disk = disk
+
+	vault.file, err = kdbx.Read(bytes.NewReader(contents), vault.password, vault.keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := database.New(masterKey(vault.password, vault.keyFile), database.DefaultKDF())
+	if err != nil {
+		return nil, err
+	}
+
+	vault.uuids = make(map[string]string)
+	for _, entry := range vault.file.Entries() {
+		id, err := db.AddEntry(entry)
+		if err != nil {
+			return nil, err
+		}
+		vault.uuids[id] = entry.ID
+	}
+	return db, nil
+}
+
+// groups, attachments and history in the file are kept, see kdbx.File.Update
+func (vault *keePassVault) save(db *database.Database) {
+	fmt.Printf("Enter name of file to save to, empty for [%s]\n", vault.fileName)
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Scan()
+	filename := scanner.Text()
+	if len(filename) == 0 {
+		filename = vault.fileName
+	}
+
+	listed := db.Entries()
+	entries := make([]database.Entry, 0, len(listed))
+	for _, e := range listed {
+		entry, err := db.GetEntry(e.ID)
+		if err != nil {
+			fmt.Println("Database was not saved")
+			return
+		}
+		// entries added in this session have no uuid yet and are added to the file
+		entry.ID = vault.uuids[e.ID]
+		entries = append(entries, entry)
+	}
+
+	uuids := vault.file.Update(entries)
+	for i, e := range listed {
+		vault.uuids[e.ID] = uuids[i]
+	}
+
+	var contents bytes.Buffer
+	err := vault.file.Write(&contents, vault.password, vault.keyFile)
+	if err == nil {
+		err = vault.disk.Write(filename, contents.Bytes())
+	}
+	switch {
+	case errors.Is(err, database.ErrExists):
+		fmt.Printf("[%s] already exists and is not the file this session was loaded from, save to another file\n", filename)
+	case errors.Is(err, database.ErrModified):
+		fmt.Printf("[%s] was changed since it was loaded, it may be open in another program, the file was not saved\n", filename)
+	case err != nil:
+		fmt.Printf("Failed to save database to the file [%s]\n", filename)
+	}
+}
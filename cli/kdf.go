@@ -0,0 +1,81 @@
+package cli
+
+import (
+	"flag"
+	"math"
+
+	"pwm/database"
+	"pwm/salt"
+)
+
+// --kdf and its parameters, as `pwm calibrate` suggests them
+type kdfFlags struct {
+	flags   *flag.FlagSet
+	kdf     *string
+	time    *uint
+	memory  *uint
+	threads *uint
+}
+
+func addKDFFlags(flags *flag.FlagSet) kdfFlags {
+	return kdfFlags{
+		flags:   flags,
+		kdf:     flags.String("kdf", "", "kdf the data key is wrapped with, argon2id or scrypt"),
+		time:    flags.Uint("time", 0, "argon2id passes or scrypt log2 N"),
+		memory:  flags.Uint("memory", 0, "argon2id memory in KiB or scrypt r"),
+		threads: flags.Uint("threads", 0, "argon2id threads or scrypt p"),
+	}
+}
+
+// the kdf the flags ask for, parameters that aren't given are those of current
+// when it is the same kdf and the defaults of the kdf otherwise
+func (options kdfFlags) resolve(current database.KDF) (database.KDF, error) {
+	set := make(map[string]bool)
+	options.flags.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	kdf := current
+	switch *options.kdf {
+	case "":
+	case "scrypt":
+		if current.Algorithm != database.KDFScrypt {
+			kdf = database.DefaultKDF()
+		}
+	case "argon2id", "argon2":
+		if current.Algorithm != database.KDFArgon2id {
+			// calibrate's default memory and threads, with a few passes
+			kdf = database.Argon2KDF(salt.Argon2Params{Time: 3, Memory: 256 << 10, Threads: 4, KeyLength: salt.KeyLength})
+		}
+	default:
+		return kdf, usage("unknown kdf %s, expected argon2id or scrypt", *options.kdf)
+	}
+
+	// values too large for a field are clamped rather than wrapped, so the
+	// database rejects them instead of using something smaller
+	clamp := func(value uint, max uint) uint {
+		return min(value, max)
+	}
+	if kdf.Algorithm == database.KDFArgon2id {
+		if set["time"] {
+			kdf.Argon2.Time = uint32(clamp(*options.time, math.MaxUint32))
+		}
+		if set["memory"] {
+			kdf.Argon2.Memory = uint32(clamp(*options.memory, math.MaxUint32))
+		}
+		if set["threads"] {
+			kdf.Argon2.Threads = uint8(clamp(*options.threads, math.MaxUint8))
+		}
+	} else {
+		if set["time"] {
+			kdf.Scrypt.LogN = uint8(clamp(*options.time, math.MaxUint8))
+		}
+		if set["memory"] {
+			kdf.Scrypt.R = int(clamp(*options.memory, math.MaxUint32))
+		}
+		if set["threads"] {
+			kdf.Scrypt.P = int(clamp(*options.threads, math.MaxUint32))
+		}
+	}
+	return kdf, nil
+}
@@ -0,0 +1,66 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"pwm/keyfile"
+)
+
+// the scripting commands read the vault's key file from this path when --keyfile isn't given
+const keyFileEnv = "PWM_KEYFILE"
+
+func addKeyFileFlag(flags *flag.FlagSet) *string {
+	return flags.String("keyfile", "", "key file the vault is locked with along with the password, the default is $"+keyFileEnv)
+}
+
+// the key in the file from --keyfile, or from $PWM_KEYFILE without it
+func vaultKeyFile(flagValue string) ([]byte, error) {
+	if len(flagValue) == 0 {
+		flagValue = os.Getenv(keyFileEnv)
+	}
+	return loadKeyFile(flagValue, false)
+}
+
+// the key in the file at path, nil when path is empty
+// create makes a new xml key file when there is none at path yet
+func loadKeyFile(path string, create bool) ([]byte, error) {
+	if len(path) == 0 {
+		return nil, nil
+	}
+
+	key, err := keyfile.Load(path)
+	if !create || !errors.Is(err, fs.ErrNotExist) {
+		return key, err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	key, err = keyfile.Generate(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+
+	fmt.Printf("Created the key file [%s], the vault can't be opened without it, keep a copy apart from the vault\n", path)
+	return key, nil
+}
+
+// what the vault is locked with, the password itself when there is no key file
+// so vaults without one open as they always have
+func masterKey(password string, keyFile []byte) string {
+	if keyFile == nil {
+		return password
+	}
+	return string(keyfile.Composite(password, keyFile))
+}
@@ -0,0 +1,236 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"pwm/database"
+	"pwm/recovery"
+
+	"golang.org/x/term"
+)
+
+// pwm recovery split|unlock ...
+func recoveryCommand(args []string) error {
+	if len(args) == 0 {
+		return usage("recovery expects split or unlock")
+	}
+
+	switch args[0] {
+	case "split":
+		return recoverySplit(args[1:])
+	case "unlock":
+		return recoveryUnlock(args[1:])
+	}
+	return usage("unknown recovery command %s, expected split or unlock", args[0])
+}
+
+// pwm recovery split <vault> --shares n --threshold k [--out dir]
+func recoverySplit(args []string) error {
+	flags := newFlagSet("recovery split", "<vault>")
+	passwordFd := flags.Int("password-fd", -1, "read the master password from this file descriptor")
+	keyFilePath := addKeyFileFlag(flags)
+	count := flags.Int("shares", 5, "number of shares to make")
+	threshold := flags.Int("threshold", 3, "number of shares that unlock the vault")
+	outDir := flags.String("out", "", "write each share to its own file in this directory instead of printing them")
+
+	positional, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		flags.Usage()
+		return usage("recovery split expects a vault")
+	}
+	if *threshold < recovery.MinThreshold || *threshold > *count || *count > recovery.MaxShares {
+		return usage("the threshold must be from %d to --shares, which is at most %d", recovery.MinThreshold, recovery.MaxShares)
+	}
+
+	db, err := openVault(positional[0], *passwordFd, *keyFilePath)
+	if err != nil {
+		return err
+	}
+
+	// vaults from older versions are given their data key when they are saved,
+	// so the shares always match the file
+	err = db.ToFile(positional[0])
+	if err != nil {
+		return err
+	}
+
+	shares, err := recovery.Split(db.DataKey(), *count, *threshold)
+	if err != nil {
+		return err
+	}
+
+	if len(*outDir) == 0 {
+		for _, share := range shares {
+			fmt.Println(share)
+		}
+	} else {
+		for _, share := range shares {
+			fileName := filepath.Join(*outDir, fmt.Sprintf("share-%d.txt", share.Index))
+			f, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintln(f, share)
+			if err == nil {
+				err = f.Sync()
+			}
+			f.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "Any %d of these %d shares unlock %s, give each to a different person\n", *threshold, *count, positional[0])
+	fmt.Fprintln(os.Stderr, "Changing the master password makes these shares useless, split the vault again afterwards")
+	return nil
+}
+
+// pwm recovery unlock <vault> [share file]..., shares are read from stdin
+// when no files are given, the vault is then saved with a new master password
+func recoveryUnlock(args []string) error {
+	flags := newFlagSet("recovery unlock", "<vault> [share file]...")
+	passwordFd := flags.Int("new-password-fd", -1, "read the new master password from this file descriptor")
+	keyFilePath := addKeyFileFlag(flags)
+
+	positional, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) < 1 {
+		flags.Usage()
+		return usage("recovery unlock expects a vault")
+	}
+
+	var shares []recovery.Share
+	if len(positional) > 1 {
+		shares, err = readShareFiles(positional[1:])
+	} else {
+		shares, err = readShares()
+	}
+	if err != nil {
+		return err
+	}
+
+	dataKey, err := recovery.Combine(shares)
+	if err != nil {
+		return err
+	}
+
+	db, err := database.FromFileWithDataKey(dataKey, positional[0])
+	if err != nil {
+		return err
+	}
+	backups, err := backupCount(-1)
+	if err != nil {
+		return err
+	}
+	if backups >= 0 {
+		db.SetBackups(backups)
+	}
+
+	var password string
+	if *passwordFd >= 0 {
+		password, err = readFd(*passwordFd)
+		if err != nil {
+			return err
+		}
+	} else {
+		if !term.IsTerminal(int(os.Stdin.Fd())) {
+			return usage("no terminal to read the new master password from, use --new-password-fd")
+		}
+		password = passwordConfirmation("What should the new master password be?")
+	}
+
+	keyFile, err := vaultKeyFile(*keyFilePath)
+	if err != nil {
+		return err
+	}
+	err = db.ResetMasterPassword(masterKey(password, keyFile))
+	if err != nil {
+		return err
+	}
+
+	err = db.ToFile(positional[0])
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Unlocked %s with %d shares, it now opens with the new master password\n", positional[0], len(shares))
+	fmt.Fprintln(os.Stderr, "The vault has a new data key, split it again for new recovery shares")
+	return nil
+}
+
+// the first share in each file
+func readShareFiles(fileNames []string) ([]recovery.Share, error) {
+	shares := make([]recovery.Share, 0, len(fileNames))
+	for _, fileName := range fileNames {
+		content, err := os.ReadFile(fileName)
+		if err != nil {
+			return nil, err
+		}
+
+		found := false
+		for _, line := range strings.Split(string(content), "\n") {
+			if !strings.HasPrefix(strings.ToLower(strings.TrimSpace(line)), "pwm-share-") {
+				continue
+			}
+			share, err := recovery.ParseShare(line)
+			if err != nil {
+				return nil, usage("%s: %s", fileName, err)
+			}
+			shares = append(shares, share)
+			found = true
+			break
+		}
+		if !found {
+			return nil, usage("%s has no recovery share", fileName)
+		}
+	}
+	return shares, nil
+}
+
+// one share per line until the threshold of the first is reached
+func readShares() ([]recovery.Share, error) {
+	interactive := term.IsTerminal(int(os.Stdin.Fd()))
+	scanner := bufio.NewScanner(os.Stdin)
+	shares := make([]recovery.Share, 0)
+
+	for len(shares) == 0 || len(shares) < int(shares[0].Threshold) {
+		if interactive {
+			if len(shares) == 0 {
+				fmt.Fprintln(os.Stderr, "Enter a recovery share")
+			} else {
+				fmt.Fprintf(os.Stderr, "Enter recovery share %d of %d\n", len(shares)+1, shares[0].Threshold)
+			}
+		}
+		if !scanner.Scan() {
+			if scanner.Err() != nil {
+				return nil, scanner.Err()
+			}
+			return nil, recovery.ErrTooFewShares
+		}
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+
+		share, err := recovery.ParseShare(scanner.Text())
+		if err != nil {
+			// a typo in one share shouldn't mean typing in the others again
+			if interactive {
+				fmt.Fprintln(os.Stderr, err)
+				continue
+			}
+			return nil, err
+		}
+		shares = append(shares, share)
+	}
+	return shares, nil
+}
@@ -0,0 +1,169 @@
+package clipboard
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+var (
+	ErrPasteUnsupported = errors.New("clipboard backend can't read the clipboard")
+	ErrNoClipboard      = errors.New("no clipboard program was found and stderr is not a terminal")
+)
+
+type Backend interface {
+	Copy(text string) error
+	// returns ErrPasteUnsupported when the clipboard can only be written
+	Paste() (string, error)
+}
+
+// a backend driven by external programs that read the text on stdin and write it on stdout
+type commandBackend struct {
+	copyCommand  []string
+	pasteCommand []string
+}
+
+func (c *commandBackend) Copy(text string) error {
+	cmd := exec.Command(c.copyCommand[0], c.copyCommand[1:]...)
+	cmd.Stdin = strings.NewReader(text)
+	return cmd.Run()
+}
+
+func (c *commandBackend) Paste() (string, error) {
+	var out bytes.Buffer
+	cmd := exec.Command(c.pasteCommand[0], c.pasteCommand[1:]...)
+	cmd.Stdout = &out
+	err := cmd.Run()
+	if err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+func WlCopy() Backend {
+	return &commandBackend{
+		copyCommand:  []string{"wl-copy"},
+		pasteCommand: []string{"wl-paste", "--no-newline"},
+	}
+}
+
+func Xclip() Backend {
+	return &commandBackend{
+		copyCommand:  []string{"xclip", "-selection", "clipboard"},
+		pasteCommand: []string{"xclip", "-selection", "clipboard", "-o"},
+	}
+}
+
+func Xsel() Backend {
+	return &commandBackend{
+		copyCommand:  []string{"xsel", "--clipboard", "--input"},
+		pasteCommand: []string{"xsel", "--clipboard", "--output"},
+	}
+}
+
+// sets the clipboard of the terminal itself with an escape sequence, which
+// works over ssh but can't be read back
+type osc52 struct {
+	terminal io.Writer
+}
+
+func OSC52(terminal io.Writer) Backend {
+	return &osc52{terminal: terminal}
+}
+
+func (o *osc52) Copy(text string) error {
+	_, err := fmt.Fprintf(o.terminal, "\x1b]52;c;%s\a", base64.StdEncoding.EncodeToString([]byte(text)))
+	return err
+}
+
+func (o *osc52) Paste() (string, error) {
+	return "", ErrPasteUnsupported
+}
+
+// name is one of wl-copy, xclip, xsel or osc52
+func ByName(name string) (Backend, error) {
+	switch name {
+	case "wl-copy":
+		return WlCopy(), nil
+	case "xclip":
+		return Xclip(), nil
+	case "xsel":
+		return Xsel(), nil
+	case "osc52":
+		return stderrOSC52()
+	}
+	return nil, errors.New(fmt.Sprintf("unknown clipboard backend %s", name))
+}
+
+// the escape sequence holds the secret in base64, so it is only written when
+// stderr is a terminal and not a log file or pipe
+func stderrOSC52() (Backend, error) {
+	if !term.IsTerminal(int(os.Stderr.Fd())) {
+		return nil, ErrNoClipboard
+	}
+	return OSC52(os.Stderr), nil
+}
+
+// picks a backend for the current session, falling back to osc52 when no clipboard
+// program is available, and returns ErrNoClipboard when that isn't possible either
+func Detect() (Backend, error) {
+	available := func(program string) bool {
+		_, err := exec.LookPath(program)
+		return err == nil
+	}
+
+	if os.Getenv("WAYLAND_DISPLAY") != "" && available("wl-copy") && available("wl-paste") {
+		return WlCopy(), nil
+	}
+	if os.Getenv("DISPLAY") != "" {
+		if available("xclip") {
+			return Xclip(), nil
+		}
+		if available("xsel") {
+			return Xsel(), nil
+		}
+	}
+	return stderrOSC52()
+}
+
+// empties the clipboard if it still holds value, so anything copied since is left alone
+// backends that can't be read are emptied regardless
+func ClearIfUnchanged(backend Backend, value string) error {
+	current, err := backend.Paste()
+	if errors.Is(err, ErrPasteUnsupported) {
+		return backend.Copy("")
+	}
+	if err != nil {
+		return err
+	}
+
+	if current != value {
+		return nil
+	}
+	return backend.Copy("")
+}
+
+// copies value and blocks until timeout has passed and the clipboard has been cleared
+func CopyAndClear(backend Backend, value string, timeout time.Duration) error {
+	err := backend.Copy(value)
+	if err != nil {
+		return err
+	}
+
+	return ClearAfter(backend, value, timeout)
+}
+
+// blocks until timeout has passed and then clears value as ClearIfUnchanged does
+func ClearAfter(backend Backend, value string, timeout time.Duration) error {
+	time.Sleep(timeout)
+
+	return ClearIfUnchanged(backend, value)
+}
@@ -0,0 +1,103 @@
+package clipboard_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"pwm/clipboard"
+
+	"golang.org/x/term"
+)
+
+type memoryBackend struct {
+	contents string
+	copies   int
+}
+
+func (m *memoryBackend) Copy(text string) error {
+	m.contents = text
+	m.copies++
+	return nil
+}
+
+func (m *memoryBackend) Paste() (string, error) {
+	return m.contents, nil
+}
+
+func TestCopyAndClear(t *testing.T) {
+	backend := &memoryBackend{}
+
+	err := clipboard.CopyAndClear(backend, "hunter2", time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if backend.contents != "" {
+		t.Error("expected the clipboard to be cleared")
+	}
+	if backend.copies != 2 {
+		t.Errorf("expected a copy and a clear, got %d copies", backend.copies)
+	}
+}
+
+func TestClearIfUnchanged(t *testing.T) {
+	backend := &memoryBackend{}
+	if err := backend.Copy("hunter2"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the user copied something else in the meantime
+	if err := backend.Copy("something else"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := clipboard.ClearIfUnchanged(backend, "hunter2"); err != nil {
+		t.Fatal(err)
+	}
+	if backend.contents != "something else" {
+		t.Error("expected the clipboard to be left alone")
+	}
+}
+
+func TestOSC52(t *testing.T) {
+	var terminal bytes.Buffer
+	backend := clipboard.OSC52(&terminal)
+
+	if err := clipboard.CopyAndClear(backend, "hunter2", time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	// aHVudGVyMg== is base64 for hunter2, the clear can't check the clipboard so it always happens
+	if terminal.String() != "\x1b]52;c;aHVudGVyMg==\a\x1b]52;c;\a" {
+		t.Errorf("unexpected escape sequences %q", terminal.String())
+	}
+
+	if _, err := backend.Paste(); err != clipboard.ErrPasteUnsupported {
+		t.Error("expected osc52 paste to be unsupported")
+	}
+}
+
+func TestByName(t *testing.T) {
+	for _, name := range []string{"wl-copy", "xclip", "xsel"} {
+		if _, err := clipboard.ByName(name); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// the secret mustn't end up in a log when go test's stderr isn't a terminal
+	_, err := clipboard.ByName("osc52")
+	if term.IsTerminal(int(os.Stderr.Fd())) {
+		if err != nil {
+			t.Error(err)
+		}
+	} else if !errors.Is(err, clipboard.ErrNoClipboard) {
+		t.Errorf("expected ErrNoClipboard without a terminal, got %v", err)
+	}
+	if _, err := clipboard.ByName("pbcopy"); err == nil || !strings.Contains(err.Error(), "pbcopy") {
+		t.Error("expected an unknown backend to fail")
+	}
+}
@@ -1,50 +1,114 @@
 package database
 
 import (
+	"crypto/rand"
 	"errors"
-	"golang.org/x/crypto/bcrypt"
-	"os"
+	"sort"
+	"strings"
+	"time"
 
 	"pwm/encrypt"
-	"pwm/serialize"
+	"pwm/otp"
 )
 
 const (
 	majorCost = 18
-	minorCost = 12
+	entryCost = 14
+
+	historyLength = 10
+)
+
+var (
+	ErrNotFound  = errors.New("entry not found")
+	ErrAmbiguous = errors.New("more than one entry has that username")
+	// the vault could not be authenticated, either the password is wrong or the file was corrupted
+	ErrWrongPassword = errors.New("wrong master password or corrupted vault")
+	// a vault opened with its data key can't be saved until it is given a master password
+	ErrNoMasterPassword = errors.New("vault has no master password, set one with ResetMasterPassword")
 )
 
+// entries are encrypted with a random data key, the data key is stored
+// wrapped by a key derived from the master password when the database is
+// unlocked, so the kdf only runs on unlock and on master password changes
 type Database struct {
-	data         map[string][]byte
-	passwordHash []byte
+	data       map[string]record
+	dataKey    []byte
+	header     header
+	wrappedKey []byte
+	backups    int
+	source     fileState
 }
 
-func New(masterPassword string) (*Database, error) {
+// kdf is checked with the limits a vault being read has to meet, see DefaultKDF
+func New(masterPassword string, kdf KDF) (*Database, error) {
 	var db Database
-	var err error
-	db.passwordHash, err = bcrypt.GenerateFromPassword([]byte(masterPassword), minorCost)
+
+	db.dataKey = make([]byte, encrypt.KeyLength)
+	_, err := rand.Read(db.dataKey)
 	if err != nil {
 		return nil, err
 	}
 
-	db.data = make(map[string][]byte)
+	err = db.wrapDataKey(masterPassword, kdf)
+	if err != nil {
+		return nil, err
+	}
+
+	db.data = make(map[string]record)
+	db.backups = defaultBackups
 
 	return &db, nil
 }
 
 func Decrypt(masterPassword string, cipherBuffer []byte) (*Database, error) {
-	buffer, err := encrypt.DecryptScrypt([]byte(masterPassword), cipherBuffer, majorCost)
+	h, err := parseHeader(cipherBuffer)
+	if errors.Is(err, errNoHeader) || (err == nil && h.Version < keyWrapVersion) {
+		return decryptLegacy(masterPassword, cipherBuffer)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	var db Database
-	db.passwordHash, err = bcrypt.GenerateFromPassword([]byte(masterPassword), minorCost)
+	dataKey, wrappedKey, err := unwrapDataKey(h, masterPassword, cipherBuffer)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := decryptRecords(h, dataKey, wrappedKey, cipherBuffer)
 	if err != nil {
 		return nil, err
 	}
 
-	db.data, err = serialize.DeserializeMap(buffer)
+	// the data key is wrapped again so the next save writes the current format
+	if h.Version < envelopeVersion {
+		err = db.wrapDataKey(masterPassword, h.kdf())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return db, nil
+}
+
+// the body after the header and wrapped key, opened with the data key
+func decryptRecords(h header, dataKey []byte, wrappedKey []byte, cipherBuffer []byte) (*Database, error) {
+	db := Database{
+		dataKey:    dataKey,
+		header:     h,
+		wrappedKey: wrappedKey,
+		backups:    defaultBackups,
+	}
+
+	prefixLength := headerLength + len(wrappedKey)
+	buffer, err := encrypt.Open(dataKey, cipherBuffer[prefixLength:], cipherBuffer[:prefixLength])
+	if errors.Is(err, encrypt.ErrTooShort) {
+		return nil, errVaultTruncated
+	}
+	if err != nil {
+		return nil, ErrWrongPassword
+	}
+
+	db.data, err = decodeRecords(buffer, h.Version)
 	if err != nil {
 		return nil, err
 	}
@@ -52,97 +116,409 @@ func Decrypt(masterPassword string, cipherBuffer []byte) (*Database, error) {
 	return &db, nil
 }
 
-func (db *Database) Encrypt(masterPassword string) ([]byte, error) {
-	err := bcrypt.CompareHashAndPassword(db.passwordHash, []byte(masterPassword))
+func (db *Database) Encrypt() ([]byte, error) {
+	if db.wrappedKey == nil {
+		return nil, ErrNoMasterPassword
+	}
+
+	data, err := encodeRecords(db.data)
 	if err != nil {
 		return nil, err
 	}
 
-	data, err := serialize.SerializeMap(&db.data)
+	headerBytes, err := db.header.encode()
 	if err != nil {
 		return nil, err
 	}
 
-	cipherBuffer, err := encrypt.EncryptScrypt([]byte(masterPassword), data, majorCost)
+	prefix := append(headerBytes, db.wrappedKey...)
+	cipherBuffer, err := encrypt.Seal(db.dataKey, data, prefix)
+	if err != nil {
+		return nil, err
+	}
 
-	return cipherBuffer, nil
+	return append(prefix, cipherBuffer...), nil
 }
 
 func FromFile(masterPassword string, fileName string) (*Database, error) {
-	content, err := os.ReadFile(fileName)
+	content, state, err := readFileLocked(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := Decrypt(masterPassword, content)
 	if err != nil {
 		return nil, err
 	}
+	db.source = state
+
+	return db, nil
+}
+
+// returns ErrModified instead of overwriting a file that another process
+// changed since this database read or wrote it, see Merge, and ErrExists
+// instead of overwriting one it never read or wrote
+func (db *Database) ToFile(fileName string) error {
+	contents, err := db.Encrypt()
+	if err != nil {
+		return err
+	}
+
+	return writeFileChecked(fileName, contents, db.backups, &db.source)
+}
+
+// brings in the entries of other, normally the vault as it is now on disk
+// entries only in other are added and entries other modified more recently are
+// replaced, entries removed from only one of the two are kept
+// afterwards ToFile may overwrite the file other was loaded from
+func (db *Database) Merge(other *Database) error {
+	data := make(map[string]record, len(db.data))
+	for id, r := range db.data {
+		data[id] = r
+	}
+
+	for id, r := range other.data {
+		if mine, ok := db.data[id]; ok && !r.entry.Modified.After(mine.entry.Modified) {
+			continue
+		}
+
+		resealed, err := reseal(other, db, id, r)
+		if err != nil {
+			return err
+		}
+		data[id] = resealed
+	}
+
+	db.data = data
+	db.source = other.source
+
+	return nil
+}
+
+// the record with its password, history and otp uri moved from the data key of from to that of to
+func reseal(from *Database, to *Database, id string, r record) (record, error) {
+	resealPassword := func(cipherText []byte) ([]byte, error) {
+		plaintext, err := from.open(id, cipherText)
+		if err != nil {
+			return nil, err
+		}
+		return to.seal(id, plaintext)
+	}
+
+	var err error
+	r.password, err = resealPassword(r.password)
+	if err != nil {
+		return r, err
+	}
 
-	return Decrypt(masterPassword, content)
+	history := make([]historyRecord, len(r.history))
+	for i, h := range r.history {
+		h.password, err = resealPassword(h.password)
+		if err != nil {
+			return r, err
+		}
+		history[i] = h
+	}
+	r.history = history
+
+	uri, err := from.openOTP(id, r.otp)
+	if err != nil {
+		return r, err
+	}
+	r.otp, err = to.sealOTP(id, uri)
+	return r, err
 }
 
-func (db *Database) ToFile(masterPassword string, fileName string) error {
-	contents, err := db.Encrypt(masterPassword)
+// the entries are moved to a new data key, so the old master password and a
+// copy of the vault from before, such as a backup, can't open what is saved
+// afterwards, this costs two kdf runs and no more however large the database is
+// recovery shares of the old data key no longer open the vault
+// the data key is wrapped with kdf from then on, db.KDF() keeps the current one
+func (db *Database) ChangeMasterPassword(oldPassword string, newPassword string, kdf KDF) error {
+	headerBytes, err := db.header.encode()
+	if err != nil {
+		return err
+	}
+
+	_, _, err = unwrapDataKey(db.header, oldPassword, append(headerBytes, db.wrappedKey...))
 	if err != nil {
 		return err
 	}
 
-	return os.WriteFile(fileName, contents, 0644)
+	return db.rotateDataKey(newPassword, kdf)
+}
+
+// the kdf the data key is wrapped with
+func (db *Database) KDF() KDF {
+	return db.header.kdf()
+}
+
+// the ciphertext is bound to the entry id so it can't be moved to another entry
+func (db *Database) seal(id string, plaintext string) ([]byte, error) {
+	return encrypt.Seal(db.dataKey, []byte(plaintext), []byte(id))
+}
+
+func (db *Database) open(id string, ciphertext []byte) (string, error) {
+	plaintext, err := encrypt.Open(db.dataKey, ciphertext, []byte(id))
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// the otp uri is bound to the entry too, but can't be swapped with its password
+func otpData(id string) string {
+	return id + "/otp"
+}
+
+// an empty uri is stored as no otp at all
+func (db *Database) sealOTP(id string, uri string) ([]byte, error) {
+	if len(uri) == 0 {
+		return nil, nil
+	}
+
+	_, err := otp.ParseURI(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	return db.seal(otpData(id), uri)
+}
+
+func (db *Database) openOTP(id string, ciphertext []byte) (string, error) {
+	if len(ciphertext) == 0 {
+		return "", nil
+	}
+	return db.open(otpData(id), ciphertext)
+}
+
+// returns the id of the new entry, usernames don't need to be unique
+func (db *Database) AddEntry(entry Entry) (string, error) {
+	id, err := newID()
+	if err != nil {
+		return "", err
+	}
+
+	cipherText, err := db.seal(id, entry.Password)
+	if err != nil {
+		return "", err
+	}
+
+	otpCipherText, err := db.sealOTP(id, entry.OTP)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	entry.ID = id
+	entry.Created = now
+	entry.Modified = now
+
+	r := newRecord(entry, cipherText)
+	r.otp = otpCipherText
+	db.data[id] = r
+
+	return id, nil
 }
 
-func (db *Database) AddAccount(masterPassword string, username string, password string) error {
-	if _, ok := db.data[username]; ok {
-		return errors.New("cannot overwrite passwords")
+// replaces every field of the entry, including the password, the id and creation time are kept
+// if the password changed the old one is moved to the password history
+func (db *Database) UpdateEntry(id string, entry Entry) error {
+	old, ok := db.data[id]
+	if !ok {
+		return ErrNotFound
 	}
 
-	err := bcrypt.CompareHashAndPassword(db.passwordHash, []byte(masterPassword))
+	oldPassword, err := db.open(id, old.password)
 	if err != nil {
 		return err
 	}
 
-	cipherText, err := encrypt.EncryptArgon2([]byte(masterPassword), []byte(password), 14)
+	now := time.Now()
+	entry.ID = id
+	entry.Created = old.entry.Created
+	entry.Modified = now
+
+	r := newRecord(entry, old.password)
+	r.history = old.history
+	r.otp, err = db.sealOTP(id, entry.OTP)
 	if err != nil {
 		return err
 	}
+	if oldPassword != entry.Password {
+		r.password, err = db.seal(id, entry.Password)
+		if err != nil {
+			return err
+		}
+		r.pushHistory(old.password, now)
+	}
 
-	db.data[username] = cipherText
+	db.data[id] = r
 
 	return nil
 }
 
-func (db *Database) RemoveAccount(masterPassword string, username string) error {
-	if _, ok := db.data[username]; !ok {
-		return errors.New("username not found")
+// the previous password is kept in the history so a failed rotation can be undone
+// setting the password the entry already has changes nothing, as UpdateEntry does
+func (db *Database) UpdatePassword(id string, newPassword string) error {
+	r, ok := db.data[id]
+	if !ok {
+		return ErrNotFound
 	}
 
-	err := bcrypt.CompareHashAndPassword(db.passwordHash, []byte(masterPassword))
+	oldPassword, err := db.open(id, r.password)
 	if err != nil {
 		return err
 	}
+	if oldPassword == newPassword {
+		return nil
+	}
+
+	cipherText, err := db.seal(id, newPassword)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	r.pushHistory(r.password, now)
+	r.password = cipherText
+	r.entry.Modified = now
+
+	db.data[id] = r
+
+	return nil
+}
+
+// returns the previous passwords of the entry, newest first
+func (db *Database) GetPasswordHistory(id string) ([]PasswordHistory, error) {
+	r, ok := db.data[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	history := make([]PasswordHistory, 0, len(r.history))
+	for _, h := range r.history {
+		plaintext, err := db.open(id, h.password)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, PasswordHistory{Password: plaintext, Changed: h.changed})
+	}
+
+	return history, nil
+}
+
+func (db *Database) GetEntry(id string) (Entry, error) {
+	r, ok := db.data[id]
+	if !ok {
+		return Entry{}, ErrNotFound
+	}
+
+	plaintext, err := db.open(id, r.password)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	uri, err := db.openOTP(id, r.otp)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	entry := r.entry.clone()
+	entry.Password = plaintext
+	entry.OTP = uri
+
+	return entry, nil
+}
+
+func (db *Database) RemoveEntry(id string) error {
+	if _, ok := db.data[id]; !ok {
+		return ErrNotFound
+	}
 
-	delete(db.data, username)
+	delete(db.data, id)
 
 	return nil
 }
 
-func (db *Database) GetPassword(masterPassword string, username string) (string, error) {
-	if _, ok := db.data[username]; !ok {
-		return string(""), errors.New("username not found")
+// entries are returned without their passwords, sorted by title then username
+func (db *Database) Entries() []Entry {
+	entries := make([]Entry, 0, len(db.data))
+	for _, r := range db.data {
+		entries = append(entries, r.entry.clone())
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if titleA, titleB := strings.ToLower(a.Title), strings.ToLower(b.Title); titleA != titleB {
+			return titleA < titleB
+		}
+		if a.Username != b.Username {
+			return a.Username < b.Username
+		}
+		return a.ID < b.ID
+	})
+
+	return entries
+}
+
+// returns the entries, without passwords, whose username matches exactly
+func (db *Database) FindByUsername(username string) []Entry {
+	entries := make([]Entry, 0)
+	for _, r := range db.data {
+		if r.entry.Username == username {
+			entries = append(entries, r.entry.clone())
+		}
 	}
+	return entries
+}
+
+// resolves a username to the id of its only entry
+func (db *Database) idForUsername(username string) (string, error) {
+	entries := db.FindByUsername(username)
+	switch len(entries) {
+	case 0:
+		return "", ErrNotFound
+	case 1:
+		return entries[0].ID, nil
+	}
+	return "", ErrAmbiguous
+}
 
-	err := bcrypt.CompareHashAndPassword(db.passwordHash, []byte(masterPassword))
+func (db *Database) AddAccount(username string, password string) error {
+	_, err := db.AddEntry(Entry{Title: username, Username: username, Password: password})
+	return err
+}
+
+func (db *Database) RemoveAccount(username string) error {
+	id, err := db.idForUsername(username)
+	if err != nil {
+		return err
+	}
+
+	return db.RemoveEntry(id)
+}
+
+func (db *Database) GetPassword(username string) (string, error) {
+	id, err := db.idForUsername(username)
 	if err != nil {
 		return "", err
 	}
 
-	plaintext, err := encrypt.DecryptArgon2([]byte(masterPassword), db.data[username], 14)
+	entry, err := db.GetEntry(id)
 	if err != nil {
 		return "", err
 	}
 
-	return string(plaintext), nil
+	return entry.Password, nil
 }
 
+// sorted usernames, a username appears once for every entry that has it
 func (db *Database) GetAccounts() []string {
-	keys := make([]string, 0, len(db.data))
-	for k := range db.data {
-		keys = append(keys, k)
+	usernames := make([]string, 0, len(db.data))
+	for _, r := range db.data {
+		usernames = append(usernames, r.entry.Username)
 	}
-	return keys
+	sort.Strings(usernames)
+	return usernames
 }
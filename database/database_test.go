@@ -1,12 +1,20 @@
 package database_test
 
 import (
+	"bytes"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
 	"pwm/database"
+	"pwm/encrypt"
+	"pwm/salt"
+	"pwm/serialize"
 )
 
 func searchArrayForName(array []string, name string) error {
@@ -20,18 +28,18 @@ func searchArrayForName(array []string, name string) error {
 }
 
 func TestDatabase(t *testing.T) {
-	db, err := database.New("password")
+	db, err := database.New("password", database.DefaultKDF())
 	if err != nil {
 		t.Error(err)
 	}
 
-	if err := db.AddAccount("password", "user1", "thisiscorrect!"); err != nil {
+	if err := db.AddAccount("user1", "thisiscorrect!"); err != nil {
 		t.Error(err)
 	}
-	if err := db.AddAccount("password", "user2", "thisiscorrect2!"); err != nil {
+	if err := db.AddAccount("user2", "thisiscorrect2!"); err != nil {
 		t.Error(err)
 	}
-	if err := db.AddAccount("password", "user3", "thisiscorrect3!"); err != nil {
+	if err := db.AddAccount("user3", "thisiscorrect3!"); err != nil {
 		t.Error(err)
 	}
 
@@ -49,7 +57,7 @@ func TestDatabase(t *testing.T) {
 		t.Error(err)
 	}
 
-	pw, err := db.GetPassword("password", "user1")
+	pw, err := db.GetPassword("user1")
 	if err != nil {
 		t.Error(err)
 	}
@@ -57,7 +65,7 @@ func TestDatabase(t *testing.T) {
 		t.Error("user1 password incorrect")
 	}
 
-	pw, err = db.GetPassword("password", "user2")
+	pw, err = db.GetPassword("user2")
 	if err != nil {
 		t.Error(err)
 	}
@@ -65,7 +73,7 @@ func TestDatabase(t *testing.T) {
 		t.Error("user2 password incorrect")
 	}
 
-	pw, err = db.GetPassword("password", "user3")
+	pw, err = db.GetPassword("user3")
 	if err != nil {
 		t.Error(err)
 	}
@@ -73,7 +81,7 @@ func TestDatabase(t *testing.T) {
 		t.Error("user3 password incorrect")
 	}
 
-	ciphertext, err := db.Encrypt("password")
+	ciphertext, err := db.Encrypt()
 	if err != nil {
 		t.Error(err)
 	}
@@ -83,7 +91,7 @@ func TestDatabase(t *testing.T) {
 		t.Error(err)
 	}
 
-	pw, err = db.GetPassword("password", "user1")
+	pw, err = db.GetPassword("user1")
 	if err != nil {
 		t.Error(err)
 	}
@@ -91,7 +99,7 @@ func TestDatabase(t *testing.T) {
 		t.Error("user1 password incorrect")
 	}
 
-	pw, err = db.GetPassword("password", "user2")
+	pw, err = db.GetPassword("user2")
 	if err != nil {
 		t.Error(err)
 	}
@@ -99,7 +107,7 @@ func TestDatabase(t *testing.T) {
 		t.Error("user2 password incorrect")
 	}
 
-	pw, err = db.GetPassword("password", "user3")
+	pw, err = db.GetPassword("user3")
 	if err != nil {
 		t.Error(err)
 	}
@@ -108,13 +116,786 @@ func TestDatabase(t *testing.T) {
 	}
 
 	// test remove
-	err = db.RemoveAccount("password", "user1")
+	err = db.RemoveAccount("user1")
 	if err != nil {
 		t.Error(err)
 	}
 
-	pw, err = db.GetPassword("password", "user1")
+	pw, err = db.GetPassword("user1")
 	if err == nil {
 		t.Error("expected to be unable to find account")
 	}
 }
+
+func TestVaultHeader(t *testing.T) {
+	db, err := database.New("password", database.DefaultKDF())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddAccount("user1", "thisiscorrect!"); err != nil {
+		t.Error(err)
+	}
+
+	ciphertext, err := db.Encrypt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(ciphertext, []byte("PWMV")) {
+		t.Error("expected vault to start with the header magic")
+	}
+
+	// the header is authenticated, changing the cipher byte must break decryption
+	tampered := bytes.Clone(ciphertext)
+	tampered[7] ^= 0xff
+	if _, err := database.Decrypt("password", tampered); err == nil {
+		t.Error("expected tampered header to fail")
+	}
+
+	if _, err := database.Decrypt("wrong", ciphertext); !errors.Is(err, database.ErrWrongPassword) {
+		t.Error("expected wrong password to fail")
+	}
+
+	// header, inside the wrapped key, just after it and inside the body
+	for _, length := range []int{20, 40, 20 + encrypt.EnvelopeOverhead(encrypt.AES256GCM) + encrypt.KeyLength, len(ciphertext) - 1} {
+		if _, err := database.Decrypt("password", ciphertext[:length]); err == nil {
+			t.Errorf("expected a vault truncated to %d bytes to fail", length)
+		}
+	}
+}
+
+// the kdf fields are each in range but would make the kdf allocate 64 GiB or more
+func TestHeaderMemoryCeiling(t *testing.T) {
+	db, err := database.New("password", database.DefaultKDF())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext, err := db.Encrypt()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, crafted := range []struct {
+		kdf                     uint8
+		time, memory, parallels uint32
+	}{
+		// scrypt with N = 2^24 and r = 32
+		{1, 24, 32, 1},
+		// argon2id with 8 GiB
+		{2, 1, 1 << 23, 1},
+	} {
+		tampered := bytes.Clone(ciphertext)
+		tampered[6] = crafted.kdf
+		binary.LittleEndian.PutUint32(tampered[8:], crafted.time)
+		binary.LittleEndian.PutUint32(tampered[12:], crafted.memory)
+		binary.LittleEndian.PutUint32(tampered[16:], crafted.parallels)
+
+		_, err := database.Decrypt("password", tampered)
+		if err == nil || !strings.Contains(err.Error(), "need more than") {
+			t.Errorf("expected %+v to be refused before the kdf runs, got %v", crafted, err)
+		}
+	}
+}
+
+func TestLegacyVault(t *testing.T) {
+	accountCipher, err := encrypt.EncryptArgon2([]byte("password"), []byte("thisiscorrect!"), 14)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := map[string][]byte{"user1": accountCipher}
+	buffer, err := serialize.SerializeMap(&data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// headerless files were a bare scrypt ciphertext at cost 18
+	legacy, err := encrypt.EncryptScrypt([]byte("password"), buffer, 18)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := database.Decrypt("password", legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pw, err := db.GetPassword("user1")
+	if err != nil {
+		t.Error(err)
+	}
+	if strings.Compare(pw, "thisiscorrect!") != 0 {
+		t.Error("user1 password incorrect")
+	}
+
+	entries := db.FindByUsername("user1")
+	if len(entries) != 1 {
+		t.Fatal("expected one entry for user1")
+	}
+	entry := entries[0]
+	if len(entry.ID) != 36 || entry.Title != "user1" || entry.Username != "user1" {
+		t.Error("legacy account was not migrated to an entry")
+	}
+}
+
+func TestEntries(t *testing.T) {
+	db, err := database.New("password", database.DefaultKDF())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry := database.Entry{
+		Title:    "GitHub",
+		Username: "alice",
+		Password: "hunter2",
+		URLs:     []string{"https://github.com"},
+		Notes:    "work account",
+		Tags:     []string{"work", "dev"},
+		Fields:   map[string]string{"recovery": "abcd-efgh"},
+		OTP:      "otpauth://totp/GitHub:alice?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ&issuer=GitHub",
+	}
+	id, err := db.AddEntry(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.AddEntry(database.Entry{Username: "bob", OTP: "not a uri"}); err == nil {
+		t.Error("expected an invalid otp uri to fail")
+	}
+	for _, listed := range db.Entries() {
+		if len(listed.OTP) != 0 {
+			t.Error("expected listed entries to leave out the otp uri")
+		}
+	}
+
+	ciphertext, err := db.Encrypt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err = database.Decrypt("password", ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := db.GetEntry(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != id || got.Title != "GitHub" || got.Password != "hunter2" || got.Notes != "work account" {
+		t.Error("entry fields incorrect")
+	}
+	if len(got.URLs) != 1 || got.URLs[0] != "https://github.com" {
+		t.Error("entry urls incorrect")
+	}
+	if len(got.Tags) != 2 || got.Tags[1] != "dev" {
+		t.Error("entry tags incorrect")
+	}
+	if got.Fields["recovery"] != "abcd-efgh" {
+		t.Error("entry custom fields incorrect")
+	}
+	if got.OTP != entry.OTP {
+		t.Error("entry otp incorrect")
+	}
+	if got.Created.IsZero() || !got.Created.Equal(got.Modified) {
+		t.Error("entry timestamps incorrect")
+	}
+
+	got.Password = "hunter3"
+	got.Notes = ""
+	if err := db.UpdateEntry(id, got); err != nil {
+		t.Fatal(err)
+	}
+
+	updated, err := db.GetEntry(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Password != "hunter3" || updated.Notes != "" {
+		t.Error("entry was not updated")
+	}
+	if !updated.Created.Equal(got.Created) || !updated.Modified.After(got.Modified) {
+		t.Error("update timestamps incorrect")
+	}
+}
+
+func TestDuplicateUsernames(t *testing.T) {
+	db, err := database.New("password", database.DefaultKDF())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	github, err := db.AddEntry(database.Entry{Title: "GitHub", Username: "alice", Password: "github"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	aws, err := db.AddEntry(database.Entry{Title: "AWS", Username: "alice", Password: "aws"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if github == aws {
+		t.Error("expected different ids")
+	}
+
+	if len(db.FindByUsername("alice")) != 2 {
+		t.Error("expected two entries for alice")
+	}
+
+	_, err = db.GetPassword("alice")
+	if !errors.Is(err, database.ErrAmbiguous) {
+		t.Error("expected ambiguous username lookup to fail")
+	}
+
+	entry, err := db.GetEntry(aws)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Password != "aws" {
+		t.Error("aws password incorrect")
+	}
+
+	if err := db.RemoveEntry(github); err != nil {
+		t.Error(err)
+	}
+
+	pw, err := db.GetPassword("alice")
+	if err != nil {
+		t.Error(err)
+	}
+	if pw != "aws" {
+		t.Error("aws password incorrect")
+	}
+}
+
+func TestPasswordHistory(t *testing.T) {
+	db, err := database.New("password", database.DefaultKDF())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := db.AddEntry(database.Entry{Title: "AWS", Username: "alice", Password: "first"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpdatePassword(id, "second"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpdatePassword(id, "third"); err != nil {
+		t.Fatal(err)
+	}
+
+	ciphertext, err := db.Encrypt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err = database.Decrypt("password", ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry, err := db.GetEntry(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Password != "third" {
+		t.Error("password was not updated")
+	}
+
+	history, err := db.GetPasswordHistory(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || history[0].Password != "second" || history[1].Password != "first" {
+		t.Fatal("password history incorrect")
+	}
+	if history[0].Changed.Before(history[1].Changed) {
+		t.Error("password history is not newest first")
+	}
+
+	// updating other fields leaves the history alone
+	entry.Notes = "rotated"
+	if err := db.UpdateEntry(id, entry); err != nil {
+		t.Fatal(err)
+	}
+	history, err = db.GetPasswordHistory(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 {
+		t.Error("expected history to be unchanged")
+	}
+
+	// and so does setting the password the entry already has
+	entry, err = db.GetEntry(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpdatePassword(id, "third"); err != nil {
+		t.Fatal(err)
+	}
+	history, err = db.GetPasswordHistory(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 {
+		t.Error("expected the unchanged password not to be added to the history")
+	}
+	unchanged, err := db.GetEntry(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !unchanged.Modified.Equal(entry.Modified) {
+		t.Error("expected the entry not to be modified")
+	}
+}
+
+func TestChangeMasterPassword(t *testing.T) {
+	db, err := database.New("password", database.DefaultKDF())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := db.AddEntry(database.Entry{Title: "AWS", Username: "alice", Password: "first", OTP: "otpauth://totp/AWS?secret=JBSWY3DPEHPK3PXP"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpdatePassword(id, "second"); err != nil {
+		t.Fatal(err)
+	}
+	oldDataKey := db.DataKey()
+
+	if err := db.ChangeMasterPassword("wrong", "newpassword", database.DefaultKDF()); err == nil {
+		t.Error("expected the wrong old password to fail")
+	}
+	if err := db.ChangeMasterPassword("password", "newpassword", database.DefaultKDF()); err != nil {
+		t.Fatal(err)
+	}
+
+	ciphertext, err := db.Encrypt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.Decrypt("password", ciphertext); err == nil {
+		t.Error("expected the old password to stop unlocking the vault")
+	}
+	db, err = database.Decrypt("newpassword", ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// someone with the old password and an old copy of the vault has the old data key
+	if _, err := database.DecryptWithDataKey(oldDataKey, ciphertext); !errors.Is(err, database.ErrWrongPassword) {
+		t.Errorf("expected the old data key to stop opening the vault, got %v", err)
+	}
+
+	entry, err := db.GetEntry(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Password != "second" || entry.OTP != "otpauth://totp/AWS?secret=JBSWY3DPEHPK3PXP" {
+		t.Error("password or otp incorrect after rekey")
+	}
+
+	history, err := db.GetPasswordHistory(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 || history[0].Password != "first" {
+		t.Error("password history incorrect after rekey")
+	}
+}
+
+// the entry path before the data key existed, a full argon2 run per encryption and decryption
+func BenchmarkEntryArgon2(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		ciphertext, err := encrypt.EncryptArgon2([]byte("password"), []byte("thisiscorrect!"), 14)
+		if err != nil {
+			b.Fatal(err)
+		}
+		_, err = encrypt.DecryptArgon2([]byte("password"), ciphertext, 14)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEntryDataKey(b *testing.B) {
+	db, err := database.New("password", database.DefaultKDF())
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		id, err := db.AddEntry(database.Entry{Username: "user1", Password: "thisiscorrect!"})
+		if err != nil {
+			b.Fatal(err)
+		}
+		_, err = db.GetEntry(id)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestToFile(t *testing.T) {
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "vault")
+
+	db, err := database.New("password", database.DefaultKDF())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetBackups(2)
+
+	for _, password := range []string{"first", "second", "third"} {
+		if err := db.AddAccount(password, password); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.ToFile(fileName); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0600 {
+			t.Errorf("expected vault to be created with 0600, got %o", info.Mode().Perm())
+		}
+		// as older versions created vaults, the backups of it mustn't be readable by others
+		if err := os.Chmod(fileName, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, name := range []string{fileName + ".1", fileName + ".2"} {
+		backupInfo, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if backupInfo.Mode().Perm() != 0600 {
+			t.Errorf("expected %s to be created with 0600, got %o", name, backupInfo.Mode().Perm())
+		}
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		names = append(names, file.Name())
+	}
+	if strings.Join(names, " ") != "vault vault.1 vault.2 vault.lock" {
+		t.Errorf("unexpected files after saving: %v", names)
+	}
+
+	// vault.1 is the save before the latest one
+	backup, err := database.FromFile("password", fileName+".1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backup.GetAccounts()) != 2 {
+		t.Error("expected the first backup to hold the previous save")
+	}
+}
+
+func TestModifiedOnDisk(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "vault")
+
+	db, err := database.New("password", database.DefaultKDF())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddAccount("user1", "thisiscorrect!"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ToFile(fileName); err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := database.FromFile("password", fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := database.FromFile("password", fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := first.AddAccount("user2", "thisiscorrect2!"); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.ToFile(fileName); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := second.AddAccount("user3", "thisiscorrect3!"); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.ToFile(fileName); !errors.Is(err, database.ErrModified) {
+		t.Fatalf("expected ErrModified, got %v", err)
+	}
+
+	// nor may a database replace a vault it was not loaded from or saved to
+	if err := db.ToFile(filepath.Join(filepath.Dir(fileName), "vault.1")); !errors.Is(err, database.ErrExists) {
+		t.Errorf("expected ErrExists, got %v", err)
+	}
+	fresh, err := database.New("password", database.DefaultKDF())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fresh.ToFile(fileName); !errors.Is(err, database.ErrExists) {
+		t.Errorf("expected ErrExists for a new database, got %v", err)
+	}
+
+	onDisk, err := database.FromFile("password", fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Merge(onDisk); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.ToFile(fileName); err != nil {
+		t.Fatal(err)
+	}
+
+	merged, err := database.FromFile("password", fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, username := range []string{"user1", "user2", "user3"} {
+		if err := searchArrayForName(merged.GetAccounts(), username); err != nil {
+			t.Error(err)
+		}
+	}
+	if len(merged.GetAccounts()) != 3 {
+		t.Error("expected merged entries to not be duplicated")
+	}
+}
+
+func TestReadOnlyDir(t *testing.T) {
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "vault")
+
+	db, err := database.New("password", database.DefaultKDF())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ToFile(fileName); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(fileName + ".lock"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chmod(dir, 0500); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(dir, 0700)
+
+	if _, err := database.FromFile("password", fileName); err != nil {
+		t.Fatalf("expected a vault in a read only directory to open, got %v", err)
+	}
+	if _, err := os.Stat(fileName + ".lock"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected reading to leave no lock file, got %v", err)
+	}
+}
+
+func TestFile(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "shared.kdbx")
+	if err := os.WriteFile(fileName, []byte("first"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	f, contents, err := database.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(contents) != "first" {
+		t.Fatalf("unexpected contents %q", contents)
+	}
+	if err := f.Write(fileName, []byte("second")); err != nil {
+		t.Fatal(err)
+	}
+	if backup, err := os.ReadFile(fileName + ".1"); err != nil || string(backup) != "first" {
+		t.Errorf("expected the previous contents in a backup, got %q %v", backup, err)
+	}
+
+	if err := os.WriteFile(fileName, []byte("changed by another program"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Write(fileName, []byte("third")); !errors.Is(err, database.ErrModified) {
+		t.Errorf("expected ErrModified, got %v", err)
+	}
+	if err := f.Write(fileName+".1", []byte("third")); !errors.Is(err, database.ErrExists) {
+		t.Errorf("expected ErrExists, got %v", err)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	db, err := database.New("password", database.DefaultKDF())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries := []database.Entry{
+		{Title: "GitHub", Username: "alice", URLs: []string{"https://github.com"}, Tags: []string{"dev"}},
+		{Title: "GitLab", Username: "alice", URLs: []string{"https://gitlab.com"}, Tags: []string{"dev"}},
+		{Title: "AWS", Username: "ops", URLs: []string{"https://console.aws.amazon.com"}, Tags: []string{"cloud"}},
+		{Title: "Bank", Username: "alice.smith", URLs: []string{"https://bank.example"}},
+	}
+	for _, entry := range entries {
+		if _, err := db.AddEntry(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results := db.Search("github")
+	if len(results) == 0 || results[0].Entry.Title != "GitHub" {
+		t.Fatal("expected GitHub to be the best match for github")
+	}
+
+	// a fuzzy match still finds the entry
+	results = db.Search("gthb")
+	if len(results) != 1 || results[0].Entry.Title != "GitHub" {
+		t.Error("expected gthb to only match GitHub")
+	}
+
+	results = db.Search("cloud")
+	if len(results) != 1 || results[0].Entry.Title != "AWS" {
+		t.Error("expected tags to be searched")
+	}
+
+	results = db.Search("amazon")
+	if len(results) != 1 || results[0].Entry.Title != "AWS" {
+		t.Error("expected urls to be searched")
+	}
+
+	if len(db.Search("zzz")) != 0 {
+		t.Error("expected no results for zzz")
+	}
+
+	results = db.Search("alice")
+	if len(results) != 3 || results[2].Entry.Title != "Bank" {
+		t.Error("expected the exact username matches to rank above alice.smith")
+	}
+
+	titles := make([]string, 0)
+	for _, entry := range db.Entries() {
+		titles = append(titles, entry.Title)
+	}
+	if strings.Join(titles, " ") != "AWS Bank GitHub GitLab" {
+		t.Errorf("expected entries sorted by title, got %v", titles)
+	}
+}
+
+func TestRecoverWithDataKey(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "vault")
+	db, err := database.New("lost password", database.DefaultKDF())
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := db.AddEntry(database.Entry{Title: "AWS", Username: "alice", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ToFile(fileName); err != nil {
+		t.Fatal(err)
+	}
+	dataKey := db.DataKey()
+
+	wrongKey := bytes.Clone(dataKey)
+	wrongKey[0] ^= 1
+	if _, err := database.FromFileWithDataKey(wrongKey, fileName); !errors.Is(err, database.ErrWrongPassword) {
+		t.Errorf("expected ErrWrongPassword for a wrong data key, got %v", err)
+	}
+
+	recovered, err := database.FromFileWithDataKey(dataKey, fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := recovered.GetEntry(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Password != "secret" {
+		t.Error("password incorrect after recovery")
+	}
+
+	if err := recovered.ToFile(fileName); !errors.Is(err, database.ErrNoMasterPassword) {
+		t.Errorf("expected ErrNoMasterPassword before a new password is set, got %v", err)
+	}
+	if err := recovered.ResetMasterPassword("new password"); err != nil {
+		t.Fatal(err)
+	}
+	if err := recovered.ToFile(fileName); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := database.FromFile("new password", fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(reopened.DataKey(), dataKey) {
+		t.Error("expected a new data key, so the shares used to recover the vault stop working")
+	}
+	if entry, err := reopened.GetEntry(id); err != nil || entry.Password != "secret" {
+		t.Errorf("entry lost after the new master password, %v", err)
+	}
+}
+
+func TestKDF(t *testing.T) {
+	argon2 := database.Argon2KDF(salt.Argon2Params{Time: 1, Memory: salt.MinArgon2Memory, Threads: 2, KeyLength: salt.KeyLength})
+	db, err := database.New("password", argon2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := db.AddEntry(database.Entry{Title: "AWS", Username: "alice", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ciphertext, err := db.Encrypt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err = database.Decrypt("password", ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.KDF() != argon2 {
+		t.Errorf("expected the argon2id parameters to be read back, got %+v", db.KDF())
+	}
+
+	scrypt := database.ScryptKDF(salt.ScryptParams{LogN: salt.MinScryptLogN, R: 8, P: 2, KeyLength: salt.KeyLength})
+	if err := db.ChangeMasterPassword("password", "new password", scrypt); err != nil {
+		t.Fatal(err)
+	}
+	ciphertext, err = db.Encrypt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err = database.Decrypt("new password", ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.KDF() != scrypt {
+		t.Errorf("expected the scrypt parameters to be read back, got %+v", db.KDF())
+	}
+	if entry, err := db.GetEntry(id); err != nil || entry.Password != "secret" {
+		t.Errorf("entry lost after changing the kdf, %v", err)
+	}
+
+	// the limits of a vault being read apply to new ones as well
+	for _, weak := range []database.KDF{
+		database.Argon2KDF(salt.Argon2Params{Time: 1, Memory: 64, Threads: 1, KeyLength: salt.KeyLength}),
+		database.ScryptKDF(salt.ScryptParams{LogN: 30, R: 8, P: 1, KeyLength: salt.KeyLength}),
+		{Algorithm: 9},
+	} {
+		if _, err := database.New("password", weak); err == nil {
+			t.Errorf("expected %+v to be refused", weak)
+		}
+		if err := db.ChangeMasterPassword("new password", "other", weak); err == nil {
+			t.Errorf("expected %+v to be refused on a password change", weak)
+		}
+	}
+}
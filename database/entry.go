@@ -0,0 +1,278 @@
+package database
+
+import (
+	"errors"
+	"time"
+
+	"pwm/serialize"
+)
+
+// ID is assigned by the database when the entry is added and is ignored on input
+type Entry struct {
+	ID       string
+	Title    string
+	Username string
+	Password string
+	URLs     []string
+	Notes    string
+	Tags     []string
+	Fields   map[string]string
+	// otpauth:// uri, kept encrypted like the password
+	OTP      string
+	Created  time.Time
+	Modified time.Time
+}
+
+// Changed is when the password stopped being the current one
+type PasswordHistory struct {
+	Password string
+	Changed  time.Time
+}
+
+// record is how an entry is held in memory and on disk, the password, its history
+// and the otp uri are kept sealed with the data key, Entry.Password and Entry.OTP are always empty
+type record struct {
+	entry    Entry
+	password []byte
+	history  []historyRecord
+	otp      []byte
+}
+
+type historyRecord struct {
+	password []byte
+	changed  time.Time
+}
+
+// the newest historyLength passwords are kept, newest first
+func (r *record) pushHistory(password []byte, changed time.Time) {
+	history := make([]historyRecord, 0, historyLength)
+	history = append(history, historyRecord{password: password, changed: changed})
+	for _, h := range r.history {
+		if len(history) == historyLength {
+			break
+		}
+		history = append(history, h)
+	}
+	r.history = history
+}
+
+func newRecord(entry Entry, password []byte) record {
+	entry.Password = ""
+	entry.OTP = ""
+	if entry.Fields == nil {
+		entry.Fields = make(map[string]string)
+	}
+
+	return record{entry: entry.clone(), password: password}
+}
+
+// copies the slices and map so callers can't modify stored entries
+func (entry Entry) clone() Entry {
+	entry.URLs = append([]string(nil), entry.URLs...)
+	entry.Tags = append([]string(nil), entry.Tags...)
+
+	fields := make(map[string]string, len(entry.Fields))
+	for name, value := range entry.Fields {
+		fields[name] = value
+	}
+	entry.Fields = fields
+
+	return entry
+}
+
+func encodeRecord(r *record) ([]byte, error) {
+	urls, err := serialize.SerializeStrings(r.entry.URLs)
+	if err != nil {
+		return nil, err
+	}
+
+	tags, err := serialize.SerializeStrings(r.entry.Tags)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string][]byte, len(r.entry.Fields))
+	for name, value := range r.entry.Fields {
+		fields[name] = []byte(value)
+	}
+	encodedFields, err := serialize.SerializeMap(&fields)
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := r.entry.Created.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	modified, err := r.entry.Modified.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	history := make([]string, 0, len(r.history))
+	for _, h := range r.history {
+		changed, err := h.changed.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+
+		values := map[string][]byte{"password": h.password, "changed": changed}
+		buffer, err := serialize.SerializeMap(&values)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, string(buffer))
+	}
+	encodedHistory, err := serialize.SerializeStrings(history)
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string][]byte{
+		"title":    []byte(r.entry.Title),
+		"username": []byte(r.entry.Username),
+		"password": r.password,
+		"otp":      r.otp,
+		"urls":     urls,
+		"notes":    []byte(r.entry.Notes),
+		"tags":     tags,
+		"fields":   encodedFields,
+		"created":  created,
+		"modified": modified,
+		"history":  encodedHistory,
+	}
+
+	return serialize.SerializeMap(&values)
+}
+
+func decodeRecord(buffer []byte) (record, error) {
+	var r record
+
+	values, err := serialize.DeserializeMap(buffer)
+	if err != nil {
+		return r, err
+	}
+
+	password, ok := values["password"]
+	if !ok {
+		return r, errors.New("entry has no password")
+	}
+	r.password = password
+	if otp := values["otp"]; len(otp) != 0 {
+		r.otp = otp
+	}
+
+	r.entry.Title = string(values["title"])
+	r.entry.Username = string(values["username"])
+	r.entry.Notes = string(values["notes"])
+
+	r.entry.URLs, err = serialize.DeserializeStrings(values["urls"])
+	if err != nil {
+		return r, err
+	}
+
+	r.entry.Tags, err = serialize.DeserializeStrings(values["tags"])
+	if err != nil {
+		return r, err
+	}
+
+	fields, err := serialize.DeserializeMap(values["fields"])
+	if err != nil {
+		return r, err
+	}
+	r.entry.Fields = make(map[string]string, len(fields))
+	for name, value := range fields {
+		r.entry.Fields[name] = string(value)
+	}
+
+	err = r.entry.Created.UnmarshalBinary(values["created"])
+	if err != nil {
+		return r, err
+	}
+
+	err = r.entry.Modified.UnmarshalBinary(values["modified"])
+	if err != nil {
+		return r, err
+	}
+
+	history, err := serialize.DeserializeStrings(values["history"])
+	if err != nil {
+		return r, err
+	}
+	for _, buffer := range history {
+		values, err := serialize.DeserializeMap([]byte(buffer))
+		if err != nil {
+			return r, err
+		}
+
+		var h historyRecord
+		h.password = values["password"]
+		err = h.changed.UnmarshalBinary(values["changed"])
+		if err != nil {
+			return r, err
+		}
+		r.history = append(r.history, h)
+	}
+
+	return r, nil
+}
+
+func encodeRecords(data map[string]record) ([]byte, error) {
+	encoded := make(map[string][]byte, len(data))
+	for key, r := range data {
+		buffer, err := encodeRecord(&r)
+		if err != nil {
+			return nil, err
+		}
+		encoded[key] = buffer
+	}
+
+	return serialize.SerializeMap(&encoded)
+}
+
+// version 1 vaults, and headerless files before them, map a username
+// directly to its encrypted password, those are migrated to entries on load
+// version 2 vaults keyed entries by username, every entry from before version 3
+// is given a new random id
+func decodeRecords(buffer []byte, version uint16) (map[string]record, error) {
+	encoded, err := serialize.DeserializeMap(buffer)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make(map[string]record, len(encoded))
+	now := time.Now()
+	for key, value := range encoded {
+		var r record
+		if version < 2 {
+			r = record{
+				entry: Entry{
+					Title:    key,
+					Username: key,
+					Fields:   make(map[string]string),
+					Created:  now,
+					Modified: now,
+				},
+				password: value,
+			}
+		} else {
+			r, err = decodeRecord(value)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if version < 3 {
+			key, err = newID()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		r.entry.ID = key
+		data[key] = r
+	}
+
+	return data, nil
+}
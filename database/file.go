@@ -0,0 +1,340 @@
+package database
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const defaultBackups = 2
+
+var (
+	ErrModified = errors.New("vault was changed on disk since it was loaded")
+	ErrExists   = errors.New("a different file already exists at that path")
+)
+
+// what the vault file looked like when it was last read or written by this database
+type fileState struct {
+	name    string
+	modTime time.Time
+	size    int64
+	hash    [sha256.Size]byte
+}
+
+func newFileState(fileName string, info fs.FileInfo, contents []byte) (fileState, error) {
+	name, err := filepath.Abs(fileName)
+	if err != nil {
+		return fileState{}, err
+	}
+
+	return fileState{
+		name:    name,
+		modTime: info.ModTime(),
+		size:    info.Size(),
+		hash:    sha256.Sum256(contents),
+	}, nil
+}
+
+// the shared lock is taken on the vault itself, so reading needs no write access
+// to its directory and leaves nothing behind
+func readFileLocked(fileName string) ([]byte, fileState, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, fileState{}, err
+	}
+	defer file.Close()
+
+	err = lockFile(file, false)
+	if err != nil {
+		return nil, fileState{}, err
+	}
+	defer unlockFile(file)
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fileState{}, err
+	}
+
+	contents, err := io.ReadAll(file)
+	if err != nil {
+		return nil, fileState{}, err
+	}
+
+	state, err := newFileState(fileName, info, contents)
+	return contents, state, err
+}
+
+// held by ToFile while it compares and replaces the vault
+type writeLock struct {
+	sidecar *os.File
+	vault   *os.File
+}
+
+// writers take name.lock first, it survives the rename in writeFileAtomic where a
+// lock on the vault wouldn't, so only one of them compares and replaces it at a time
+// the vault is then locked as well to wait for readers still holding it
+func lockForWrite(fileName string) (*writeLock, error) {
+	lock := &writeLock{}
+	if !advisoryLocks {
+		return lock, nil
+	}
+
+	sidecar, err := os.OpenFile(fileName+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	err = lockFile(sidecar, true)
+	if err != nil {
+		sidecar.Close()
+		return nil, err
+	}
+	lock.sidecar = sidecar
+
+	vault, err := os.Open(fileName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return lock, nil
+	}
+	if err == nil {
+		err = lockFile(vault, true)
+		if err != nil {
+			vault.Close()
+		}
+	}
+	if err != nil {
+		lock.unlock()
+		return nil, err
+	}
+	lock.vault = vault
+
+	return lock, nil
+}
+
+func (lock *writeLock) unlock() {
+	for _, file := range []*os.File{lock.vault, lock.sidecar} {
+		if file != nil {
+			unlockFile(file)
+			file.Close()
+		}
+	}
+}
+
+// an existing file may only be replaced if it is the one this database was
+// loaded from or last saved to, and it hasn't changed since
+// the modification time is checked first and the contents only when it differs
+func checkUnmodified(fileName string, source fileState) error {
+	info, err := os.Stat(fileName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	name, err := filepath.Abs(fileName)
+	if err != nil {
+		return err
+	}
+	if name != source.name {
+		return ErrExists
+	}
+
+	if info.ModTime().Equal(source.modTime) && info.Size() == source.size {
+		return nil
+	}
+
+	contents, err := os.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+	hash := sha256.Sum256(contents)
+	if !bytes.Equal(hash[:], source.hash[:]) {
+		return ErrModified
+	}
+
+	return nil
+}
+
+// the number of previous versions ToFile keeps next to the vault as name.1, name.2 ...
+// with name.1 the most recent, 0 keeps no backups
+func (db *Database) SetBackups(backups int) {
+	db.backups = max(backups, 0)
+}
+
+// takes the write lock, checks the file against source and replaces it, source
+// is updated to the new contents afterwards
+func writeFileChecked(fileName string, contents []byte, backups int, source *fileState) error {
+	lock, err := lockForWrite(fileName)
+	if err != nil {
+		return err
+	}
+	defer lock.unlock()
+
+	err = checkUnmodified(fileName, *source)
+	if err != nil {
+		return err
+	}
+
+	err = writeFileAtomic(fileName, contents, backups)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(fileName)
+	if err != nil {
+		return err
+	}
+	*source, err = newFileState(fileName, info, contents)
+	return err
+}
+
+// a file in another format, such as a keepass file, read and written with the
+// same locking, modification detection and backups as a vault
+type File struct {
+	backups int
+	source  fileState
+}
+
+func ReadFile(fileName string) (*File, []byte, error) {
+	contents, state, err := readFileLocked(fileName)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &File{backups: defaultBackups, source: state}, contents, nil
+}
+
+// see Database.SetBackups
+func (f *File) SetBackups(backups int) {
+	f.backups = max(backups, 0)
+}
+
+// returns ErrModified or ErrExists as Database.ToFile does
+func (f *File) Write(fileName string, contents []byte) error {
+	return writeFileChecked(fileName, contents, f.backups, &f.source)
+}
+
+// contents are written to a temporary file in the same directory and renamed over
+// fileName, so a crash part way through leaves either the old or the new vault
+func writeFileAtomic(fileName string, contents []byte, backups int) error {
+	dir := filepath.Dir(fileName)
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(fileName)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+
+	err = writeAndSync(tmp, contents)
+	if err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+
+	err = rotateBackups(fileName, backups)
+	if err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+
+	err = os.Rename(tmpName, fileName)
+	if err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+
+	return syncDir(dir)
+}
+
+func writeAndSync(file *os.File, contents []byte) error {
+	err := file.Chmod(0600)
+	if err == nil {
+		_, err = file.Write(contents)
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+
+	closeErr := file.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// shifts name.1 ... name.(backups-1) up by one, dropping the oldest, and
+// makes name.1 a copy of the current vault which stays in place until the rename
+// the copy is only readable by its owner whatever the mode of the vault, which
+// may be 0644 when it was written by an older version
+func rotateBackups(fileName string, backups int) error {
+	if backups == 0 {
+		return nil
+	}
+
+	_, err := os.Stat(fileName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for i := backups - 1; i >= 1; i-- {
+		err = os.Rename(backupName(fileName, i), backupName(fileName, i+1))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	first := backupName(fileName, 1)
+	err = os.Remove(first)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	return copyFile(fileName, first)
+}
+
+func backupName(fileName string, n int) string {
+	return fmt.Sprintf("%s.%d", fileName, n)
+}
+
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Sync()
+	}
+
+	closeErr := out.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// makes the rename durable, not every platform can sync a directory so failures are ignored
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return nil
+	}
+	defer d.Close()
+
+	d.Sync()
+	return nil
+}
@@ -0,0 +1,195 @@
+package database
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"pwm/salt"
+)
+
+// every vault written by ToFile starts with this header, it is authenticated
+// as additional data so none of the fields can be changed without detection
+// the kdf parameters are used to derive the key that wraps the data key
+// ========== // ============== // =========== // ============ //
+//   header   //  wrapped key   //    nonce    //  ciphertext  //
+
+const (
+	formatVersion = 5
+	headerLength  = 20
+
+	// vaults before this version encrypted the whole file under the master password
+	keyWrapVersion = 4
+	// vaults before this version wrapped the data key without an encrypt.Envelope header
+	envelopeVersion = 5
+)
+
+const (
+	KDFScrypt   uint8 = 1
+	KDFArgon2id uint8 = 2
+)
+
+const (
+	CipherAES256GCM uint8 = 1
+)
+
+var headerMagic = [4]byte{'P', 'W', 'M', 'V'}
+
+var (
+	errNoHeader       = errors.New("file does not start with a vault header")
+	errVaultTruncated = errors.New("vault is truncated")
+)
+
+// for scrypt Time is log2(N), Memory is r and Parallelism is p
+// for argon2id Time is the number of passes and Memory is in KiB
+type header struct {
+	Magic       [4]byte
+	Version     uint16
+	KDF         uint8
+	Cipher      uint8
+	Time        uint32
+	Memory      uint32
+	Parallelism uint32
+}
+
+func defaultHeader() header {
+	return header{
+		Magic:       headerMagic,
+		Version:     formatVersion,
+		KDF:         KDFScrypt,
+		Cipher:      CipherAES256GCM,
+		Time:        majorCost,
+		Memory:      8,
+		Parallelism: 4,
+	}
+}
+
+// the kdf the data key is wrapped with, Scrypt is used when Algorithm is
+// KDFScrypt and Argon2 when it is KDFArgon2id, the key length is always salt.KeyLength
+type KDF struct {
+	Algorithm uint8
+	Scrypt    salt.ScryptParams
+	Argon2    salt.Argon2Params
+}
+
+// scrypt with N = 2^18, r = 8 and p = 4, what vaults have always used
+func DefaultKDF() KDF {
+	h := defaultHeader()
+	return h.kdf()
+}
+
+func ScryptKDF(params salt.ScryptParams) KDF {
+	return KDF{Algorithm: KDFScrypt, Scrypt: params}
+}
+
+func Argon2KDF(params salt.Argon2Params) KDF {
+	return KDF{Algorithm: KDFArgon2id, Argon2: params}
+}
+
+// a header for kdf, which is checked against the same limits as a header being read
+func (kdf KDF) header() (header, error) {
+	h := defaultHeader()
+	h.KDF = kdf.Algorithm
+	switch kdf.Algorithm {
+	case KDFScrypt:
+		// negative values wrap around and fail the upper bounds
+		h.Time, h.Memory, h.Parallelism = uint32(kdf.Scrypt.LogN), uint32(kdf.Scrypt.R), uint32(kdf.Scrypt.P)
+	case KDFArgon2id:
+		h.Time, h.Memory, h.Parallelism = kdf.Argon2.Time, kdf.Argon2.Memory, uint32(kdf.Argon2.Threads)
+	}
+	return h, h.validate()
+}
+
+func (h *header) kdf() KDF {
+	switch h.KDF {
+	case KDFArgon2id:
+		return Argon2KDF(h.argon2Params())
+	}
+	return ScryptKDF(h.scryptParams())
+}
+
+func (h *header) encode() ([]byte, error) {
+	var buffer bytes.Buffer
+	err := binary.Write(&buffer, binary.LittleEndian, h)
+	if err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+func parseHeader(content []byte) (header, error) {
+	var h header
+	if len(content) < headerLength || !bytes.Equal(content[:len(headerMagic)], headerMagic[:]) {
+		return h, errNoHeader
+	}
+
+	err := binary.Read(bytes.NewReader(content[:headerLength]), binary.LittleEndian, &h)
+	if err != nil {
+		return h, err
+	}
+
+	return h, h.validate()
+}
+
+func (h *header) validate() error {
+	if h.Version < 1 || h.Version > formatVersion {
+		return errors.New(fmt.Sprintf("unsupported vault format version %d", h.Version))
+	}
+
+	if h.Cipher != CipherAES256GCM {
+		return errors.New(fmt.Sprintf("unsupported vault cipher %d", h.Cipher))
+	}
+
+	// a crafted header mustn't make the kdf allocate more than salt.MaxMemory, the
+	// other bounds keep the fields from wrapping when converted and the time spent sane
+	switch h.KDF {
+	case KDFScrypt:
+		if h.Time > 31 || h.Memory > salt.MaxMemory/128 || h.Parallelism > 16 {
+			return errors.New("invalid scrypt parameters in vault header")
+		}
+		if h.scryptParams().MemoryUsage() > salt.MaxMemory {
+			return errors.New(fmt.Sprintf("scrypt parameters in vault header need more than %d MiB", salt.MaxMemory>>20))
+		}
+		err := h.scryptParams().Validate()
+		if err != nil {
+			return errors.New(fmt.Sprintf("invalid scrypt parameters in vault header, %s", err))
+		}
+	case KDFArgon2id:
+		if h.Time > 64 || h.Parallelism > 255 {
+			return errors.New("invalid argon2id parameters in vault header")
+		}
+		if h.argon2Params().MemoryUsage() > salt.MaxMemory {
+			return errors.New(fmt.Sprintf("argon2id parameters in vault header need more than %d MiB", salt.MaxMemory>>20))
+		}
+		err := h.argon2Params().Validate()
+		if err != nil {
+			return errors.New(fmt.Sprintf("invalid argon2id parameters in vault header, %s", err))
+		}
+	default:
+		return errors.New(fmt.Sprintf("unsupported vault kdf %d", h.KDF))
+	}
+
+	return nil
+}
+
+// saltBytes can be nil if a random salt is to be generated
+func (h *header) deriveKey(masterPassword string, saltBytes []byte) (salt.SaltResult, error) {
+	switch h.KDF {
+	case KDFScrypt:
+		return salt.ScryptWithParams([]byte(masterPassword), saltBytes, h.scryptParams())
+	case KDFArgon2id:
+		return salt.Argon2WithParams([]byte(masterPassword), saltBytes, h.argon2Params())
+	}
+
+	return salt.SaltResult{}, errors.New(fmt.Sprintf("unsupported vault kdf %d", h.KDF))
+}
+
+func (h *header) scryptParams() salt.ScryptParams {
+	return salt.ScryptParams{LogN: uint8(h.Time), R: int(h.Memory), P: int(h.Parallelism), KeyLength: salt.KeyLength}
+}
+
+func (h *header) argon2Params() salt.Argon2Params {
+	return salt.Argon2Params{Time: h.Time, Memory: h.Memory, Threads: uint8(h.Parallelism), KeyLength: salt.KeyLength}
+}
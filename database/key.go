@@ -0,0 +1,168 @@
+package database
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+
+	"pwm/encrypt"
+)
+
+// the data key in an encrypt.Envelope at the start of wrapped, vaults before
+// envelopeVersion used the legacy layout which has nothing to tell it apart
+func wrappedKeyLength(version uint16, wrapped []byte) (int, error) {
+	if version < envelopeVersion {
+		return encrypt.LegacyOverhead + encrypt.KeyLength, nil
+	}
+	return encrypt.EnvelopeLength(wrapped, encrypt.KeyLength)
+}
+
+// wraps the data key under a key derived from masterPassword with kdf
+func (db *Database) wrapDataKey(masterPassword string, kdf KDF) error {
+	h, err := kdf.header()
+	if err != nil {
+		return err
+	}
+	headerBytes, err := h.encode()
+	if err != nil {
+		return err
+	}
+
+	saltResult, err := h.deriveKey(masterPassword, nil)
+	if err != nil {
+		return err
+	}
+
+	wrappedKey, err := encrypt.EncryptWithData(saltResult, db.dataKey, headerBytes)
+	if err != nil {
+		return err
+	}
+
+	db.header = h
+	db.wrappedKey = wrappedKey
+
+	return nil
+}
+
+// cipherBuffer starts with the header followed by the wrapped key
+func readWrappedKey(h header, cipherBuffer []byte) ([]byte, error) {
+	keyLength, err := wrappedKeyLength(h.Version, cipherBuffer[min(headerLength, len(cipherBuffer)):])
+	if errors.Is(err, encrypt.ErrTooShort) {
+		return nil, errVaultTruncated
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(cipherBuffer) < headerLength+keyLength {
+		return nil, errVaultTruncated
+	}
+	return bytes.Clone(cipherBuffer[headerLength : headerLength+keyLength]), nil
+}
+
+func unwrapDataKey(h header, masterPassword string, cipherBuffer []byte) ([]byte, []byte, error) {
+	wrappedKey, err := readWrappedKey(h, cipherBuffer)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	env, err := encrypt.ParseEnvelope(wrappedKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	saltResult, err := h.deriveKey(masterPassword, env.Salt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dataKey, err := env.Open(saltResult.Key, cipherBuffer[:headerLength])
+	if err != nil {
+		return nil, nil, ErrWrongPassword
+	}
+
+	return dataKey, wrappedKey, nil
+}
+
+// the key the entries are encrypted with, for splitting into recovery shares
+// changing the master password replaces it, a vault from before
+// keyWrapVersion only has it in its file once it has been saved
+func (db *Database) DataKey() []byte {
+	return bytes.Clone(db.dataKey)
+}
+
+// opens a vault whose master password is lost with its data key, the vault
+// has to be given a new master password with ResetMasterPassword before it can be saved
+// a wrong data key gives ErrWrongPassword
+func DecryptWithDataKey(dataKey []byte, cipherBuffer []byte) (*Database, error) {
+	h, err := parseHeader(cipherBuffer)
+	if errors.Is(err, errNoHeader) || (err == nil && h.Version < keyWrapVersion) {
+		return nil, errors.New("vault was written before data keys were used, it has none to recover")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	wrappedKey, err := readWrappedKey(h, cipherBuffer)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := decryptRecords(h, bytes.Clone(dataKey), wrappedKey, cipherBuffer)
+	if err != nil {
+		return nil, err
+	}
+	db.wrappedKey = nil
+
+	return db, nil
+}
+
+func FromFileWithDataKey(dataKey []byte, fileName string) (*Database, error) {
+	content, state, err := readFileLocked(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := DecryptWithDataKey(dataKey, content)
+	if err != nil {
+		return nil, err
+	}
+	db.source = state
+
+	return db, nil
+}
+
+// gives a vault opened with DecryptWithDataKey a new master password, the
+// entries are moved to a new data key so the shares it was recovered with stop working
+// the kdf stays the one the vault was written with
+func (db *Database) ResetMasterPassword(newPassword string) error {
+	return db.rotateDataKey(newPassword, db.header.kdf())
+}
+
+// seals every entry under a new data key and wraps that under masterPassword,
+// the database is left as it was if anything fails
+func (db *Database) rotateDataKey(masterPassword string, kdf KDF) error {
+	rotated := Database{dataKey: make([]byte, encrypt.KeyLength)}
+	_, err := rand.Read(rotated.dataKey)
+	if err != nil {
+		return err
+	}
+
+	rotated.data = make(map[string]record, len(db.data))
+	for id, r := range db.data {
+		rotated.data[id], err = reseal(db, &rotated, id, r)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = rotated.wrapDataKey(masterPassword, kdf)
+	if err != nil {
+		return err
+	}
+
+	db.dataKey = rotated.dataKey
+	db.data = rotated.data
+	db.header = rotated.header
+	db.wrappedKey = rotated.wrappedKey
+	return nil
+}
@@ -0,0 +1,87 @@
+package database
+
+import (
+	"errors"
+
+	"pwm/encrypt"
+)
+
+// vaults before keyWrapVersion encrypted every password with argon2 under the
+// master password, they are moved to a new data key when they are opened
+func decryptLegacy(masterPassword string, cipherBuffer []byte) (*Database, error) {
+	buffer, version, err := decryptVault(masterPassword, cipherBuffer)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := decodeRecords(buffer, version)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := New(masterPassword, DefaultKDF())
+	if err != nil {
+		return nil, err
+	}
+
+	reseal := func(id string, cipherText []byte) ([]byte, error) {
+		plaintext, err := encrypt.DecryptArgon2([]byte(masterPassword), cipherText, entryCost)
+		if err != nil {
+			return nil, err
+		}
+		return db.seal(id, string(plaintext))
+	}
+
+	for id, r := range data {
+		r.password, err = reseal(id, r.password)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range r.history {
+			r.history[i].password, err = reseal(id, r.history[i].password)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		db.data[id] = r
+	}
+
+	return db, nil
+}
+
+// files written before the vault header existed are a bare scrypt ciphertext
+// at majorCost, they are reported as format version 0
+func decryptVault(masterPassword string, cipherBuffer []byte) ([]byte, uint16, error) {
+	h, err := parseHeader(cipherBuffer)
+	if errors.Is(err, errNoHeader) {
+		buffer, err := encrypt.DecryptScrypt([]byte(masterPassword), cipherBuffer, majorCost)
+		if err != nil {
+			return nil, 0, ErrWrongPassword
+		}
+		return buffer, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	env, err := encrypt.ParseEnvelope(cipherBuffer[headerLength:])
+	if errors.Is(err, encrypt.ErrTooShort) {
+		return nil, 0, errVaultTruncated
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	saltResult, err := h.deriveKey(masterPassword, env.Salt)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	buffer, err := env.Open(saltResult.Key, cipherBuffer[:headerLength])
+	if err != nil {
+		return nil, 0, ErrWrongPassword
+	}
+	return buffer, h.Version, nil
+}
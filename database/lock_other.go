@@ -0,0 +1,16 @@
+//go:build !(linux || darwin || freebsd || openbsd || netbsd || dragonfly)
+
+package database
+
+import "os"
+
+// advisory locks are not available here, modification detection in ToFile still applies
+const advisoryLocks = false
+
+func lockFile(file *os.File, exclusive bool) error {
+	return nil
+}
+
+func unlockFile(file *os.File) error {
+	return nil
+}
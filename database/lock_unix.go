@@ -0,0 +1,29 @@
+//go:build linux || darwin || freebsd || openbsd || netbsd || dragonfly
+
+package database
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+const advisoryLocks = true
+
+func lockFile(file *os.File, exclusive bool) error {
+	how := unix.LOCK_SH
+	if exclusive {
+		how = unix.LOCK_EX
+	}
+
+	for {
+		err := unix.Flock(int(file.Fd()), how)
+		if err != unix.EINTR {
+			return err
+		}
+	}
+}
+
+func unlockFile(file *os.File) error {
+	return unix.Flock(int(file.Fd()), unix.LOCK_UN)
+}
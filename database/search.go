@@ -0,0 +1,111 @@
+package database
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+type SearchResult struct {
+	Entry Entry
+	Score int
+}
+
+// how much a match in each field counts towards an entry's score
+const (
+	titleWeight    = 4
+	usernameWeight = 3
+	tagWeight      = 2
+	urlWeight      = 1
+)
+
+// ranks entries, without their passwords, by a fuzzy match of query against their
+// title, username, urls and tags, entries that don't match at all are left out
+// an empty query returns every entry in the order of Entries
+func (db *Database) Search(query string) []SearchResult {
+	results := make([]SearchResult, 0)
+	for _, entry := range db.Entries() {
+		score := 0
+		if len(query) != 0 {
+			score = entryScore(entry, query)
+			if score == 0 {
+				continue
+			}
+		}
+		results = append(results, SearchResult{Entry: entry, Score: score})
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+
+	return results
+}
+
+func entryScore(entry Entry, query string) int {
+	best := 0
+	consider := func(text string, weight int) {
+		score := fuzzyScore(text, query) * weight
+		if score > best {
+			best = score
+		}
+	}
+
+	consider(entry.Title, titleWeight)
+	consider(entry.Username, usernameWeight)
+	for _, tag := range entry.Tags {
+		consider(tag, tagWeight)
+	}
+	for _, url := range entry.URLs {
+		consider(url, urlWeight)
+	}
+
+	return best
+}
+
+// 0 when the characters of query don't appear in order in text, otherwise higher
+// for exact and prefix matches, runs of consecutive characters and matches at word starts
+func fuzzyScore(text string, query string) int {
+	haystack := []rune(strings.ToLower(text))
+	needle := []rune(strings.ToLower(query))
+	if len(needle) == 0 || len(needle) > len(haystack) {
+		return 0
+	}
+
+	lowerText := string(haystack)
+	lowerQuery := string(needle)
+	switch {
+	case lowerText == lowerQuery:
+		return 100 + len(needle)*10
+	case strings.HasPrefix(lowerText, lowerQuery):
+		return 80 + len(needle)*10
+	case strings.Contains(lowerText, lowerQuery):
+		return 60 + len(needle)*10
+	}
+
+	score := 0
+	run := 0
+	n := 0
+	for i, r := range haystack {
+		if n == len(needle) {
+			break
+		}
+		if r != needle[n] {
+			run = 0
+			continue
+		}
+
+		score += 1 + run*2
+		if i == 0 || !unicode.IsLetter(haystack[i-1]) && !unicode.IsDigit(haystack[i-1]) {
+			score += 3
+		}
+		run++
+		n++
+	}
+
+	if n < len(needle) {
+		return 0
+	}
+	// scattered matches never outrank a substring match
+	return min(score, 50)
+}
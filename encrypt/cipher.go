@@ -0,0 +1,85 @@
+package encrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// an aead that takes a KeyLength key, the id is stored in every envelope so
+// an id must never be given to another cipher
+type Cipher interface {
+	ID() uint8
+	Name() string
+	NonceSize() int
+	// the length of the authentication tag added to every ciphertext
+	Overhead() int
+	New(key []byte) (cipher.AEAD, error)
+}
+
+var (
+	// the default, fast wherever the cpu has aes instructions
+	AES256GCM Cipher = aesGCM{}
+	// constant time in software, and its 192 bit nonces can be chosen at random without limit
+	XChaCha20Poly1305 Cipher = xChaCha20Poly1305{}
+)
+
+var Ciphers = []Cipher{AES256GCM, XChaCha20Poly1305}
+
+var ErrUnknownCipher = errors.New("unknown cipher")
+
+func CipherByID(id uint8) (Cipher, error) {
+	for _, c := range Ciphers {
+		if c.ID() == id {
+			return c, nil
+		}
+	}
+	return nil, ErrUnknownCipher
+}
+
+func CipherByName(name string) (Cipher, error) {
+	for _, c := range Ciphers {
+		if c.Name() == name {
+			return c, nil
+		}
+	}
+	return nil, errors.New(fmt.Sprintf("unknown cipher %s", name))
+}
+
+type aesGCM struct{}
+
+func (aesGCM) ID() uint8      { return 1 }
+func (aesGCM) Name() string   { return "aes-256-gcm" }
+func (aesGCM) NonceSize() int { return 12 }
+func (aesGCM) Overhead() int  { return 16 }
+
+func (aesGCM) New(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeyLength {
+		return nil, errors.New("key needs to be 32 bytes")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+type xChaCha20Poly1305 struct{}
+
+func (xChaCha20Poly1305) ID() uint8      { return 2 }
+func (xChaCha20Poly1305) Name() string   { return "xchacha20-poly1305" }
+func (xChaCha20Poly1305) NonceSize() int { return chacha20poly1305.NonceSizeX }
+func (xChaCha20Poly1305) Overhead() int  { return chacha20poly1305.Overhead }
+
+func (xChaCha20Poly1305) New(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeyLength {
+		return nil, errors.New("key needs to be 32 bytes")
+	}
+
+	return chacha20poly1305.NewX(key)
+}
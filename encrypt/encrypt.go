@@ -1,10 +1,8 @@
 package encrypt
 
 import (
-	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
-	"errors"
 
 	"pwm/salt"
 )
@@ -25,25 +23,29 @@ func EncryptArgon2(password []byte, plaintext []byte, cost int) ([]byte, error)
 }
 
 func DecryptArgon2(password []byte, ciphertext []byte, cost int) ([]byte, error) {
-	saltResult, err := salt.Argon2([]byte(password), ciphertext[:salt.SaltLength], cost)
+	env, err := ParseEnvelope(ciphertext)
 	if err != nil {
 		return nil, err
 	}
 
-	decryptedtext, err := Decrypt(saltResult.Key, ciphertext)
+	saltResult, err := salt.Argon2([]byte(password), env.Salt, cost)
 	if err != nil {
 		return nil, err
 	}
 
-	return decryptedtext, nil
+	return env.Open(saltResult.Key, nil)
 }
 
 func EncryptScrypt(password []byte, plaintext []byte, cost int) ([]byte, error) {
+	return EncryptScryptWithCipher(AES256GCM, password, plaintext, cost)
+}
+
+func EncryptScryptWithCipher(c Cipher, password []byte, plaintext []byte, cost int) ([]byte, error) {
 	saltResult, err := salt.Scrypt([]byte(password), nil, cost)
 	if err != nil {
 		return nil, err
 	}
-	ciphertext, err := Encrypt(saltResult, []byte(plaintext))
+	ciphertext, err := EncryptWithCipher(c, saltResult, []byte(plaintext), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -52,75 +54,83 @@ func EncryptScrypt(password []byte, plaintext []byte, cost int) ([]byte, error)
 }
 
 func DecryptScrypt(password []byte, ciphertext []byte, cost int) ([]byte, error) {
-	saltResult, err := salt.Scrypt([]byte(password), ciphertext[:salt.SaltLength], cost)
+	env, err := ParseEnvelope(ciphertext)
 	if err != nil {
 		return nil, err
 	}
 
-	decryptedtext, err := Decrypt(saltResult.Key, ciphertext)
+	saltResult, err := salt.Scrypt([]byte(password), env.Salt, cost)
 	if err != nil {
 		return nil, err
 	}
 
-	return decryptedtext, nil
+	return env.Open(saltResult.Key, nil)
 }
 
 func Encrypt(saltResult salt.SaltResult, plaintext []byte) ([]byte, error) {
-	if len(saltResult.Key) != KeyLength {
-		return nil, errors.New("saltedKey needs to be 32 bytes")
-	}
+	return EncryptWithData(saltResult, plaintext, nil)
+}
 
-	block, err := aes.NewCipher(saltResult.Key)
-	if err != nil {
-		return nil, err
-	}
+// additionalData is authenticated but not encrypted, the same bytes must be passed to DecryptWithData
+// the result is an Envelope holding the salt of saltResult
+func EncryptWithData(saltResult salt.SaltResult, plaintext []byte, additionalData []byte) ([]byte, error) {
+	return EncryptWithCipher(AES256GCM, saltResult, plaintext, additionalData)
+}
 
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return nil, err
-	}
+// the cipher is recorded in the envelope, decrypting doesn't need to be told it
+func EncryptWithCipher(c Cipher, saltResult salt.SaltResult, plaintext []byte, additionalData []byte) ([]byte, error) {
+	return sealEnvelope(c, saltResult.Key, saltResult.Salt[:], plaintext, additionalData)
+}
 
-	// putting the salt at the start of the nonce heap, this will be included at the start of the ciphertext
-	// ========== // =========== // ============ //
-	//   salt     //    nonce    //  ciphertext  //
-	nonce := make([]byte, salt.SaltLength+gcm.NonceSize())
-	copy(nonce[:salt.SaltLength], saltResult.Salt[:])
+func Decrypt(saltedKey []byte, ciphertext []byte) ([]byte, error) {
+	return DecryptWithData(saltedKey, ciphertext, nil)
+}
 
-	_, err = rand.Read(nonce[salt.SaltLength:])
+// fails with ErrTooShort, ErrBadVersion or ErrAuthFailed, see ParseEnvelope
+func DecryptWithData(saltedKey []byte, ciphertext []byte, additionalData []byte) ([]byte, error) {
+	env, err := ParseEnvelope(ciphertext)
 	if err != nil {
 		return nil, err
 	}
-	ciphertext := gcm.Seal(nonce, nonce[salt.SaltLength:salt.SaltLength+gcm.NonceSize()], plaintext, nil)
 
-	return ciphertext, nil
+	return env.Open(saltedKey, additionalData)
 }
 
-func Decrypt(saltedKey []byte, ciphertext []byte) ([]byte, error) {
-	if len(saltedKey) != KeyLength {
-		return nil, errors.New("saltedKey needs to be 32 bytes")
+// for keys that were not derived from a password, so no salt is stored
+func Seal(key []byte, plaintext []byte, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
 	}
 
-	block, err := aes.NewCipher(saltedKey)
+	// ========== // ============ //
+	//   nonce    //  ciphertext  //
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
 	if err != nil {
 		return nil, err
 	}
 
-	gcm, err := cipher.NewGCM(block)
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func Open(key []byte, ciphertext []byte, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
 	if err != nil {
 		return nil, err
 	}
 
-	// putting the salt at the start of the nonce heap, this will be included at the start of the ciphertext
-	// ========== // =========== // ============ //
-	//   salt     //    nonce    //  ciphertext  //
-	nonce := ciphertext[salt.SaltLength : salt.SaltLength+gcm.NonceSize()]
-	if len(nonce) > len(ciphertext) {
-		return nil, errors.New("Cannot decrypt file")
+	if len(ciphertext) < gcm.NonceSize()+gcm.Overhead() {
+		return nil, ErrTooShort
 	}
-	decryptedtext, err := gcm.Open(nil, nonce, ciphertext[salt.SaltLength+gcm.NonceSize():], nil)
+
+	plaintext, err := gcm.Open(nil, ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():], additionalData)
 	if err != nil {
-		return nil, err
+		return nil, ErrAuthFailed
 	}
+	return plaintext, nil
+}
 
-	return decryptedtext, nil
+func newGCM(key []byte) (cipher.AEAD, error) {
+	return AES256GCM.New(key)
 }
@@ -136,3 +136,79 @@ func TestScrypt_3(t *testing.T) {
 		t.Error(errors.New("saltResult gives a salt of zeros"))
 	}
 }
+
+func TestAdditionalData(t *testing.T) {
+	plaintext := "asdkadkal028032;kdHI HELLO!2345"
+	password := "password123"
+
+	saltResult, err := salt.Scrypt([]byte(password), nil, 14)
+	if err != nil {
+		t.Error(err)
+	}
+	ciphertext, err := encrypt.EncryptWithData(saltResult, []byte(plaintext), []byte("header"))
+	if err != nil {
+		t.Error(err)
+	}
+
+	decryptedtext, err := encrypt.DecryptWithData(saltResult.Key, ciphertext, []byte("header"))
+	if err != nil {
+		t.Error(err)
+	}
+	if strings.Compare(plaintext, string(decryptedtext)) != 0 {
+		t.Error("original string and decrypted string are not the same")
+	}
+
+	_, err = encrypt.DecryptWithData(saltResult.Key, ciphertext, []byte("headeR"))
+	if err == nil {
+		t.Error("expected mismatched additional data to fail")
+	}
+}
+
+func TestShortCiphertext(t *testing.T) {
+	key := make([]byte, encrypt.KeyLength)
+	for i := 0; i < 40; i++ {
+		_, err := encrypt.Decrypt(key, make([]byte, i))
+		if err == nil {
+			t.Errorf("expected %d bytes to fail", i)
+		}
+		_, err = encrypt.Open(key, make([]byte, i), nil)
+		if err == nil {
+			t.Errorf("expected %d bytes to fail to open", i)
+		}
+	}
+
+	_, err := encrypt.DecryptScrypt([]byte("password"), make([]byte, 4), 10)
+	if err == nil {
+		t.Error("expected a ciphertext shorter than the salt to fail")
+	}
+}
+
+// go test -fuzz FuzzDecrypt ./encrypt
+// only the seeds may open, and nothing may panic
+// the seeds are sealed again in every fuzzing process, so they are compared by contents
+func FuzzDecrypt(f *testing.F) {
+	key := make([]byte, encrypt.KeyLength)
+	sealed, err := encrypt.Seal(key, []byte("plaintext"), []byte("data"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	salted, err := encrypt.EncryptWithData(salt.SaltResult{Key: key}, []byte("plaintext"), []byte("data"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(sealed, []byte("data"))
+	f.Add(salted, []byte("data"))
+	f.Add([]byte{}, []byte{})
+
+	f.Fuzz(func(t *testing.T, ciphertext []byte, additionalData []byte) {
+		plaintext, err := encrypt.Open(key, ciphertext, additionalData)
+		if err == nil && (string(additionalData) != "data" || string(plaintext) != "plaintext") {
+			t.Fatal("forged ciphertext opened")
+		}
+
+		plaintext, err = encrypt.DecryptWithData(key, ciphertext, additionalData)
+		if err == nil && (string(additionalData) != "data" || string(plaintext) != "plaintext") {
+			t.Fatal("forged ciphertext decrypted")
+		}
+	})
+}
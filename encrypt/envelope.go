@@ -0,0 +1,165 @@
+package encrypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+
+	"pwm/salt"
+)
+
+// ciphertexts written by EncryptWithCipher are wrapped in a versioned envelope
+// ======== // ========= // ========= // ========== // ========= // ============ //
+//   salt   //   magic   //  version  //   cipher   //   nonce   //  ciphertext  //
+// the magic, version and cipher id are authenticated along with the caller's additional data
+//
+// version 1 envelopes have no cipher byte and are always aes-256-gcm
+// ciphertexts from before the envelope are just salt, nonce and ciphertext and
+// are parsed as version 0, a legacy nonce starts with the magic once in 2^32
+// the salt stays first in all of them so it can be read before anything is parsed
+
+var envelopeMagic = [4]byte{'P', 'W', 'M', 'E'}
+
+const (
+	legacyEnvelopeVersion = 0
+	aesEnvelopeVersion    = 1
+	EnvelopeVersion       = 2
+
+	// magic, version and cipher id
+	envelopeHeaderLength = len(envelopeMagic) + 2
+
+	// the bytes added to a plaintext before the envelope existed
+	LegacyOverhead = salt.SaltLength + 12 + 16
+)
+
+var (
+	ErrTooShort   = errors.New("ciphertext is too short")
+	ErrBadVersion = errors.New("unsupported ciphertext version")
+	// the key or additional data is wrong, or the ciphertext was changed
+	ErrAuthFailed = errors.New("ciphertext failed authentication")
+)
+
+// the parts of a ciphertext, which slice the buffer it was parsed from
+type Envelope struct {
+	Version    uint8
+	Cipher     Cipher
+	Salt       []byte
+	Nonce      []byte
+	Ciphertext []byte
+	header     []byte
+}
+
+// the bytes EncryptWithCipher adds to a plaintext
+func EnvelopeOverhead(c Cipher) int {
+	return salt.SaltLength + envelopeHeaderLength + c.NonceSize() + c.Overhead()
+}
+
+// checks the layout of data without decrypting it
+func ParseEnvelope(data []byte) (Envelope, error) {
+	env, rest, err := parseEnvelopeHeader(data)
+	if err != nil {
+		return env, err
+	}
+
+	if len(rest) < env.Cipher.NonceSize()+env.Cipher.Overhead() {
+		return env, ErrTooShort
+	}
+	env.Nonce = rest[:env.Cipher.NonceSize()]
+	env.Ciphertext = rest[env.Cipher.NonceSize():]
+
+	return env, nil
+}
+
+// the length of the envelope data starts with once it holds plaintextLength
+// bytes, for envelopes stored in front of other data
+func EnvelopeLength(data []byte, plaintextLength int) (int, error) {
+	env, rest, err := parseEnvelopeHeader(data)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(data) - len(rest) + env.Cipher.NonceSize() + plaintextLength + env.Cipher.Overhead(), nil
+}
+
+// everything before the nonce
+func parseEnvelopeHeader(data []byte) (Envelope, []byte, error) {
+	var env Envelope
+	if len(data) < salt.SaltLength {
+		return env, nil, ErrTooShort
+	}
+	env.Salt = data[:salt.SaltLength]
+	data = data[salt.SaltLength:]
+
+	if len(data) < len(envelopeMagic)+1 || !bytes.Equal(data[:len(envelopeMagic)], envelopeMagic[:]) {
+		env.Version = legacyEnvelopeVersion
+		env.Cipher = AES256GCM
+		return env, data, nil
+	}
+
+	env.Version = data[len(envelopeMagic)]
+	switch env.Version {
+	case aesEnvelopeVersion:
+		env.Cipher = AES256GCM
+		env.header = data[:len(envelopeMagic)+1]
+	case EnvelopeVersion:
+		if len(data) < envelopeHeaderLength {
+			return env, nil, ErrTooShort
+		}
+		c, err := CipherByID(data[len(envelopeMagic)+1])
+		if err != nil {
+			return env, nil, err
+		}
+		env.Cipher = c
+		env.header = data[:envelopeHeaderLength]
+	default:
+		return env, nil, ErrBadVersion
+	}
+
+	return env, data[len(env.header):], nil
+}
+
+// key is the key derived from Salt, additionalData must match what the envelope was sealed with
+func (env *Envelope) Open(key []byte, additionalData []byte) ([]byte, error) {
+	aead, err := env.Cipher.New(key)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := aead.Open(nil, env.Nonce, env.Ciphertext, env.additionalData(additionalData))
+	if err != nil {
+		return nil, ErrAuthFailed
+	}
+	return plaintext, nil
+}
+
+func (env *Envelope) additionalData(additionalData []byte) []byte {
+	if env.Version == legacyEnvelopeVersion {
+		return additionalData
+	}
+	return append(bytes.Clone(env.header), additionalData...)
+}
+
+func sealEnvelope(c Cipher, key []byte, saltBytes []byte, plaintext []byte, additionalData []byte) ([]byte, error) {
+	aead, err := c.New(key)
+	if err != nil {
+		return nil, err
+	}
+
+	env := Envelope{
+		Version: EnvelopeVersion,
+		Cipher:  c,
+		header:  append(bytes.Clone(envelopeMagic[:]), EnvelopeVersion, c.ID()),
+	}
+
+	nonce := make([]byte, c.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, EnvelopeOverhead(c)+len(plaintext))
+	out = append(out, saltBytes...)
+	out = append(out, env.header...)
+	out = append(out, nonce...)
+	return aead.Seal(out, nonce, plaintext, env.additionalData(additionalData)), nil
+}
@@ -0,0 +1,202 @@
+package encrypt_test
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"testing"
+
+	"pwm/encrypt"
+	"pwm/salt"
+)
+
+func TestEnvelope(t *testing.T) {
+	key := salt.SaltResult{Key: bytes.Repeat([]byte{7}, encrypt.KeyLength)}
+	copy(key.Salt[:], "0123456789abcdef")
+
+	ciphertext, err := encrypt.EncryptWithData(key, []byte("plaintext"), []byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ciphertext) != encrypt.EnvelopeOverhead(encrypt.AES256GCM)+len("plaintext") {
+		t.Errorf("unexpected envelope length %d", len(ciphertext))
+	}
+
+	env, err := encrypt.ParseEnvelope(ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.Version != encrypt.EnvelopeVersion || !bytes.Equal(env.Salt, key.Salt[:]) {
+		t.Errorf("unexpected envelope %+v", env)
+	}
+
+	plaintext, err := env.Open(key.Key, []byte("data"))
+	if err != nil || string(plaintext) != "plaintext" {
+		t.Fatalf("failed to open envelope: %v", err)
+	}
+
+	_, err = env.Open(key.Key, []byte("other"))
+	if !errors.Is(err, encrypt.ErrAuthFailed) {
+		t.Errorf("expected ErrAuthFailed for the wrong additional data, got %v", err)
+	}
+
+	// the version is authenticated, a changed one is rejected before decrypting
+	badVersion := bytes.Clone(ciphertext)
+	badVersion[salt.SaltLength+4] = 9
+	_, err = encrypt.ParseEnvelope(badVersion)
+	if !errors.Is(err, encrypt.ErrBadVersion) {
+		t.Errorf("expected ErrBadVersion, got %v", err)
+	}
+
+	// without the magic the rest is read as a legacy envelope, which fails to authenticate
+	noMagic := bytes.Clone(ciphertext)
+	noMagic[salt.SaltLength] = 'X'
+	_, err = encrypt.DecryptWithData(key.Key, noMagic, []byte("data"))
+	if !errors.Is(err, encrypt.ErrAuthFailed) {
+		t.Errorf("expected ErrAuthFailed without the magic, got %v", err)
+	}
+}
+
+func TestEnvelopeTruncation(t *testing.T) {
+	key := salt.SaltResult{Key: bytes.Repeat([]byte{7}, encrypt.KeyLength)}
+	ciphertext, err := encrypt.EncryptWithData(key, []byte("plaintext"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for length := 0; length < len(ciphertext); length++ {
+		_, err := encrypt.DecryptWithData(key.Key, ciphertext[:length], nil)
+		switch {
+		case length < encrypt.EnvelopeOverhead(encrypt.AES256GCM):
+			if !errors.Is(err, encrypt.ErrTooShort) {
+				t.Errorf("expected ErrTooShort for %d bytes, got %v", length, err)
+			}
+		default:
+			if !errors.Is(err, encrypt.ErrAuthFailed) {
+				t.Errorf("expected ErrAuthFailed for %d bytes, got %v", length, err)
+			}
+		}
+	}
+
+	sealed, err := encrypt.Seal(key.Key, []byte("plaintext"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for length := 0; length < len(sealed); length++ {
+		_, err := encrypt.Open(key.Key, sealed[:length], nil)
+		if !errors.Is(err, encrypt.ErrTooShort) && !errors.Is(err, encrypt.ErrAuthFailed) {
+			t.Errorf("expected a typed error for %d sealed bytes, got %v", length, err)
+		}
+	}
+}
+
+func TestPasswordEnvelopeTruncation(t *testing.T) {
+	ciphertext, err := encrypt.EncryptScrypt([]byte("password"), []byte("plaintext"), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for length := 0; length < len(ciphertext); length++ {
+		_, err := encrypt.DecryptScrypt([]byte("password"), ciphertext[:length], 10)
+		if !errors.Is(err, encrypt.ErrTooShort) && !errors.Is(err, encrypt.ErrAuthFailed) {
+			t.Errorf("expected a typed error for %d bytes, got %v", length, err)
+		}
+	}
+
+	ciphertext, err = encrypt.EncryptArgon2([]byte("password"), []byte("plaintext"), 13)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, length := range []int{0, salt.SaltLength - 1, salt.SaltLength + 3, encrypt.EnvelopeOverhead(encrypt.AES256GCM) - 1, len(ciphertext) - 1} {
+		_, err := encrypt.DecryptArgon2([]byte("password"), ciphertext[:length], 13)
+		if !errors.Is(err, encrypt.ErrTooShort) && !errors.Is(err, encrypt.ErrAuthFailed) {
+			t.Errorf("expected a typed error for %d bytes, got %v", length, err)
+		}
+	}
+}
+
+func TestCiphers(t *testing.T) {
+	key := salt.SaltResult{Key: bytes.Repeat([]byte{7}, encrypt.KeyLength)}
+
+	for _, c := range encrypt.Ciphers {
+		byName, err := encrypt.CipherByName(c.Name())
+		if err != nil || byName.ID() != c.ID() {
+			t.Errorf("%s not found by name", c.Name())
+		}
+
+		ciphertext, err := encrypt.EncryptWithCipher(c, key, []byte("plaintext"), []byte("data"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ciphertext) != encrypt.EnvelopeOverhead(c)+len("plaintext") {
+			t.Errorf("%s: unexpected envelope length %d", c.Name(), len(ciphertext))
+		}
+
+		length, err := encrypt.EnvelopeLength(append(bytes.Clone(ciphertext[:30]), "more data"...), len("plaintext"))
+		if err != nil || length != len(ciphertext) {
+			t.Errorf("%s: expected an envelope length of %d, got %d", c.Name(), len(ciphertext), length)
+		}
+
+		env, err := encrypt.ParseEnvelope(ciphertext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if env.Cipher.ID() != c.ID() {
+			t.Errorf("%s: envelope records cipher %s", c.Name(), env.Cipher.Name())
+		}
+
+		// decrypting reads the cipher from the envelope
+		plaintext, err := encrypt.DecryptWithData(key.Key, ciphertext, []byte("data"))
+		if err != nil || string(plaintext) != "plaintext" {
+			t.Errorf("%s: failed to decrypt: %v", c.Name(), err)
+		}
+
+		// the cipher id is authenticated
+		for _, other := range encrypt.Ciphers {
+			if other.ID() == c.ID() {
+				continue
+			}
+			swapped := bytes.Clone(ciphertext)
+			swapped[salt.SaltLength+5] = other.ID()
+			_, err := encrypt.DecryptWithData(key.Key, swapped, []byte("data"))
+			if err == nil {
+				t.Errorf("%s: decrypted after the cipher was changed to %s", c.Name(), other.Name())
+			}
+		}
+	}
+
+	unknown, err := encrypt.EncryptWithData(key, []byte("plaintext"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unknown[salt.SaltLength+5] = 200
+	_, err = encrypt.ParseEnvelope(unknown)
+	if !errors.Is(err, encrypt.ErrUnknownCipher) {
+		t.Errorf("expected ErrUnknownCipher, got %v", err)
+	}
+}
+
+// version 1 envelopes have no cipher byte and are always aes-256-gcm
+func TestVersion1Envelope(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, encrypt.KeyLength)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header := []byte{'P', 'W', 'M', 'E', 1}
+	nonce := make([]byte, gcm.NonceSize())
+	ciphertext := append(make([]byte, salt.SaltLength), header...)
+	ciphertext = append(ciphertext, nonce...)
+	ciphertext = gcm.Seal(ciphertext, nonce, []byte("plaintext"), append(bytes.Clone(header), "data"...))
+
+	plaintext, err := encrypt.DecryptWithData(key, ciphertext, []byte("data"))
+	if err != nil || string(plaintext) != "plaintext" {
+		t.Errorf("failed to decrypt a version 1 envelope: %v", err)
+	}
+}
@@ -0,0 +1,269 @@
+package encrypt
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"pwm/salt"
+)
+
+// large files are encrypted in chunks with the STREAM construction, each chunk is
+// sealed on its own under a nonce made of a random prefix, the chunk counter and
+// a flag set only on the last chunk, so chunks can't be reordered, dropped or
+// cut off at a chunk boundary without failing authentication
+// ======== // ========= // ========= // ========== // ========= // ========== //
+//   salt   //   magic   //  version  //   cipher   //  prefix   //  chunks    //
+// every chunk but the last holds StreamChunkSize bytes of plaintext, the last
+// holds up to that many and may be empty, the header is the additional data of every chunk
+
+var streamMagic = [4]byte{'P', 'W', 'M', 'S'}
+
+const (
+	streamVersion = 1
+
+	StreamChunkSize = 64 * 1024
+
+	// the counter and the last chunk flag take the end of the nonce
+	streamCounterSize = 4
+	streamFlagSize    = 1
+
+	// the salt and magic, enough for IsStream
+	StreamPrefixLength = salt.SaltLength + len(streamMagic)
+)
+
+var ErrStreamTooLong = errors.New("stream has more chunks than its counter can number")
+
+func streamHeader(c Cipher) []byte {
+	return append(bytes.Clone(streamMagic[:]), streamVersion, c.ID())
+}
+
+func streamNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, len(prefix), len(prefix)+streamCounterSize+streamFlagSize)
+	copy(nonce, prefix)
+	nonce = binary.BigEndian.AppendUint32(nonce, counter)
+	if last {
+		return append(nonce, 1)
+	}
+	return append(nonce, 0)
+}
+
+type encryptWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	header  []byte
+	prefix  []byte
+	counter uint32
+	buffer  []byte
+	closed  bool
+}
+
+// plaintext written to the returned writer is encrypted to w a chunk at a time
+// Close must be called to write the last chunk, without it the stream is truncated
+// and fails to decrypt
+func NewEncryptWriter(w io.Writer, c Cipher, saltResult salt.SaltResult) (io.WriteCloser, error) {
+	aead, err := c.New(saltResult.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := make([]byte, c.NonceSize()-streamCounterSize-streamFlagSize)
+	_, err = rand.Read(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	header := streamHeader(c)
+	for _, part := range [][]byte{saltResult.Salt[:], header, prefix} {
+		_, err = w.Write(part)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &encryptWriter{
+		w:      w,
+		aead:   aead,
+		header: header,
+		prefix: prefix,
+		buffer: make([]byte, 0, StreamChunkSize),
+	}, nil
+}
+
+func (ew *encryptWriter) Write(p []byte) (int, error) {
+	if ew.closed {
+		return 0, errors.New("write to a closed encrypt writer")
+	}
+
+	written := 0
+	for len(p) > 0 {
+		// a full chunk is only sealed once more data arrives, as it may be the last
+		if len(ew.buffer) == StreamChunkSize {
+			err := ew.sealChunk(false)
+			if err != nil {
+				return written, err
+			}
+		}
+
+		n := copy(ew.buffer[len(ew.buffer):StreamChunkSize], p)
+		ew.buffer = ew.buffer[:len(ew.buffer)+n]
+		p = p[n:]
+		written += n
+	}
+
+	return written, nil
+}
+
+// writes the last chunk, it doesn't close the underlying writer
+func (ew *encryptWriter) Close() error {
+	if ew.closed {
+		return nil
+	}
+	ew.closed = true
+	return ew.sealChunk(true)
+}
+
+func (ew *encryptWriter) sealChunk(last bool) error {
+	if ew.counter == ^uint32(0) && !last {
+		return ErrStreamTooLong
+	}
+
+	chunk := ew.aead.Seal(nil, streamNonce(ew.prefix, ew.counter, last), ew.buffer, ew.header)
+	_, err := ew.w.Write(chunk)
+	if err != nil {
+		return err
+	}
+
+	ew.counter++
+	ew.buffer = ew.buffer[:0]
+	return nil
+}
+
+type decryptReader struct {
+	r       io.Reader
+	aead    cipher.AEAD
+	header  []byte
+	prefix  []byte
+	counter uint32
+	// the sealed chunk being read, one byte longer than a full chunk so the
+	// reader can tell whether more follows
+	sealed    []byte
+	plaintext []byte
+	done      bool
+}
+
+// reports whether a ciphertext starting with prefix was written by NewEncryptWriter
+// rather than EncryptWithCipher, prefix needs the first StreamPrefixLength bytes
+func IsStream(prefix []byte) bool {
+	return len(prefix) >= StreamPrefixLength && bytes.Equal(prefix[salt.SaltLength:StreamPrefixLength], streamMagic[:])
+}
+
+// reads the salt of a stream written by NewEncryptWriter, so the key can be derived
+// before NewDecryptReader is called with the rest of r
+func ReadStreamSalt(r io.Reader) ([]byte, error) {
+	saltBytes := make([]byte, salt.SaltLength)
+	_, err := io.ReadFull(r, saltBytes)
+	if err != nil {
+		return nil, ErrTooShort
+	}
+	return saltBytes, nil
+}
+
+// reads a stream written by NewEncryptWriter from r, positioned just after its salt
+// the plaintext of a chunk is only returned once it has been authenticated, but
+// a stream that fails part way will have returned the chunks before the failure,
+// callers must not trust the output unless reading reached io.EOF
+func NewDecryptReader(r io.Reader, key []byte) (io.Reader, error) {
+	header := make([]byte, len(streamMagic)+2)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return nil, ErrTooShort
+	}
+	if !bytes.Equal(header[:len(streamMagic)], streamMagic[:]) || header[len(streamMagic)] != streamVersion {
+		return nil, ErrBadVersion
+	}
+
+	c, err := CipherByID(header[len(streamMagic)+1])
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := c.New(key)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := make([]byte, c.NonceSize()-streamCounterSize-streamFlagSize)
+	_, err = io.ReadFull(r, prefix)
+	if err != nil {
+		return nil, ErrTooShort
+	}
+
+	return &decryptReader{
+		r:      r,
+		aead:   aead,
+		header: header,
+		prefix: prefix,
+		sealed: make([]byte, 0, StreamChunkSize+aead.Overhead()+1),
+	}, nil
+}
+
+func (dr *decryptReader) Read(p []byte) (int, error) {
+	for len(dr.plaintext) == 0 {
+		if dr.done {
+			return 0, io.EOF
+		}
+		err := dr.openChunk()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, dr.plaintext)
+	dr.plaintext = dr.plaintext[n:]
+	return n, nil
+}
+
+func (dr *decryptReader) openChunk() error {
+	fullChunk := StreamChunkSize + dr.aead.Overhead()
+
+	// fill up to a full chunk and one byte more, the extra byte is the start of the next chunk
+	n, err := io.ReadFull(dr.r, dr.sealed[len(dr.sealed):fullChunk+1])
+	dr.sealed = dr.sealed[:len(dr.sealed)+n]
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+
+	last := len(dr.sealed) <= fullChunk
+	if last && len(dr.sealed) < dr.aead.Overhead() {
+		return ErrTooShort
+	}
+
+	chunk := dr.sealed
+	if !last {
+		chunk = dr.sealed[:fullChunk]
+	}
+	if !last && dr.counter == ^uint32(0) {
+		return ErrStreamTooLong
+	}
+
+	plaintext, err := dr.aead.Open(nil, streamNonce(dr.prefix, dr.counter, last), chunk, dr.header)
+	if err != nil {
+		return ErrAuthFailed
+	}
+
+	if last {
+		dr.sealed = dr.sealed[:0]
+		dr.done = true
+	} else {
+		// keep the byte read past the chunk
+		dr.sealed = append(dr.sealed[:0], dr.sealed[fullChunk])
+	}
+
+	dr.counter++
+	dr.plaintext = plaintext
+	return nil
+}
@@ -0,0 +1,172 @@
+package encrypt_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+
+	"pwm/encrypt"
+	"pwm/salt"
+)
+
+func encryptStream(t *testing.T, c encrypt.Cipher, key salt.SaltResult, plaintext []byte) []byte {
+	t.Helper()
+	var out bytes.Buffer
+	w, err := encrypt.NewEncryptWriter(&out, c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// odd sized writes so chunks don't line up with them
+	for len(plaintext) > 0 {
+		n := min(len(plaintext), 10007)
+		_, err = w.Write(plaintext[:n])
+		if err != nil {
+			t.Fatal(err)
+		}
+		plaintext = plaintext[n:]
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+func decryptStream(key []byte, ciphertext []byte) ([]byte, error) {
+	r := bytes.NewReader(ciphertext)
+	_, err := encrypt.ReadStreamSalt(r)
+	if err != nil {
+		return nil, err
+	}
+	dr, err := encrypt.NewDecryptReader(r, key)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(dr)
+}
+
+func TestStream(t *testing.T) {
+	key := salt.SaltResult{Key: bytes.Repeat([]byte{7}, encrypt.KeyLength)}
+
+	for _, c := range encrypt.Ciphers {
+		for _, length := range []int{0, 1, encrypt.StreamChunkSize - 1, encrypt.StreamChunkSize, encrypt.StreamChunkSize + 1, 3*encrypt.StreamChunkSize + 5} {
+			plaintext := make([]byte, length)
+			rand.Read(plaintext)
+
+			ciphertext := encryptStream(t, c, key, plaintext)
+			if !encrypt.IsStream(ciphertext) {
+				t.Fatal("stream not recognised")
+			}
+
+			decrypted, err := decryptStream(key.Key, ciphertext)
+			if err != nil {
+				t.Fatalf("%s, %d bytes: %v", c.Name(), length, err)
+			}
+			if !bytes.Equal(plaintext, decrypted) {
+				t.Errorf("%s, %d bytes: plaintext changed", c.Name(), length)
+			}
+		}
+	}
+
+	whole, err := encrypt.EncryptWithData(key, []byte("plaintext"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encrypt.IsStream(whole) {
+		t.Error("an envelope is not a stream")
+	}
+
+	_, err = decryptStream(bytes.Repeat([]byte{8}, encrypt.KeyLength), encryptStream(t, encrypt.AES256GCM, key, []byte("plaintext")))
+	if !errors.Is(err, encrypt.ErrAuthFailed) {
+		t.Errorf("expected the wrong key to fail, got %v", err)
+	}
+}
+
+func TestStreamTruncation(t *testing.T) {
+	key := salt.SaltResult{Key: bytes.Repeat([]byte{7}, encrypt.KeyLength)}
+	plaintext := make([]byte, 2*encrypt.StreamChunkSize+100)
+	ciphertext := encryptStream(t, encrypt.AES256GCM, key, plaintext)
+
+	chunk := encrypt.StreamChunkSize + 16
+	start := len(ciphertext) - (2*chunk + 100 + 16)
+
+	// every length in the header, around each chunk boundary and in the last chunk
+	lengths := make([]int, 0)
+	for i := 0; i <= start+20; i++ {
+		lengths = append(lengths, i)
+	}
+	for _, boundary := range []int{start + chunk, start + 2*chunk, len(ciphertext)} {
+		for i := boundary - 20; i < boundary; i++ {
+			lengths = append(lengths, i)
+		}
+		lengths = append(lengths, boundary+1)
+	}
+
+	for _, length := range lengths {
+		if length >= len(ciphertext) {
+			continue
+		}
+		_, err := decryptStream(key.Key, ciphertext[:length])
+		if !errors.Is(err, encrypt.ErrTooShort) && !errors.Is(err, encrypt.ErrAuthFailed) {
+			t.Errorf("expected a truncation to %d bytes to fail, got %v", length, err)
+		}
+	}
+
+	// a stream cut exactly after a full chunk looks complete, the last chunk flag catches it
+	_, err := decryptStream(key.Key, ciphertext[:start+chunk])
+	if !errors.Is(err, encrypt.ErrAuthFailed) {
+		t.Errorf("expected a stream cut at a chunk boundary to fail, got %v", err)
+	}
+
+	_, err = decryptStream(key.Key, append(bytes.Clone(ciphertext), 0))
+	if !errors.Is(err, encrypt.ErrAuthFailed) {
+		t.Errorf("expected appended data to fail, got %v", err)
+	}
+}
+
+func TestStreamReordering(t *testing.T) {
+	key := salt.SaltResult{Key: bytes.Repeat([]byte{7}, encrypt.KeyLength)}
+	plaintext := make([]byte, 3*encrypt.StreamChunkSize)
+	rand.Read(plaintext)
+	ciphertext := encryptStream(t, encrypt.XChaCha20Poly1305, key, plaintext)
+
+	chunk := encrypt.StreamChunkSize + 16
+	start := len(ciphertext) - 3*chunk - 16
+	chunks := make([][]byte, 0)
+	for i := start; i < len(ciphertext); i += chunk {
+		chunks = append(chunks, ciphertext[i:min(i+chunk, len(ciphertext))])
+	}
+	if len(chunks) != 4 {
+		t.Fatalf("expected 3 full chunks and an empty last one, got %d chunks", len(chunks))
+	}
+
+	join := func(order ...int) []byte {
+		out := bytes.Clone(ciphertext[:start])
+		for _, i := range order {
+			out = append(out, chunks[i]...)
+		}
+		return out
+	}
+
+	for _, order := range [][]int{{1, 0, 2, 3}, {0, 2, 1, 3}, {0, 1, 3}, {0, 0, 1, 2, 3}, {0, 1, 2, 2, 3}} {
+		_, err := decryptStream(key.Key, join(order...))
+		if !errors.Is(err, encrypt.ErrAuthFailed) {
+			t.Errorf("expected chunk order %v to fail, got %v", order, err)
+		}
+	}
+
+	decrypted, err := decryptStream(key.Key, join(0, 1, 2, 3))
+	if err != nil || !bytes.Equal(decrypted, plaintext) {
+		t.Error("chunks in their own order should decrypt")
+	}
+
+	// the header is authenticated with every chunk
+	swapped := bytes.Clone(ciphertext)
+	swapped[salt.SaltLength+4] = 2
+	_, err = decryptStream(key.Key, swapped)
+	if err == nil {
+		t.Error("expected a changed stream version to fail")
+	}
+}
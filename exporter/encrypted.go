@@ -0,0 +1,122 @@
+package exporter
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"pwm/database"
+	"pwm/encrypt"
+	"pwm/salt"
+)
+
+// names the layout of an encrypted export so later versions can change it
+const encryptedFormat = "pwm-encrypted-export-1"
+
+const (
+	exportKDF = "argon2id"
+	// the export passphrase is typed once on each side, so this can be slower than an entry
+	exportTime    = 3
+	exportMemory  = 1 << 16 // KiB
+	exportThreads = 4
+
+	// limits on the parameters of a file being read, so it can't ask for unbounded work
+	maxExportTime   = 64
+	maxExportMemory = 1 << 22
+)
+
+var ErrWrongPassphrase = errors.New("wrong export passphrase or corrupted export")
+
+// the kdf parameters are stored next to the ciphertext and authenticated with it
+type encryptedExport struct {
+	Encrypted bool   `json:"encrypted"`
+	Format    string `json:"format"`
+	KDF       string `json:"kdf"`
+	Time      uint32 `json:"time"`
+	Memory    uint32 `json:"memory"`
+	Threads   uint8  `json:"threads"`
+	Salt      []byte `json:"salt"`
+	// nonce || ciphertext of the bitwarden json
+	Data []byte `json:"data"`
+}
+
+func (export *encryptedExport) additionalData() []byte {
+	return []byte(fmt.Sprintf("%s|%s|%d|%d|%d", export.Format, export.KDF, export.Time, export.Memory, export.Threads))
+}
+
+func (export *encryptedExport) params() salt.Argon2Params {
+	return salt.Argon2Params{Time: export.Time, Memory: export.Memory, Threads: export.Threads, KeyLength: salt.KeyLength}
+}
+
+// the entries as bitwarden json, encrypted with a key derived from passphrase,
+// which should not be the master password of the vault
+func WriteEncrypted(w io.Writer, entries []database.Entry, passphrase string) error {
+	if len(passphrase) == 0 {
+		return errors.New("an encrypted export needs a passphrase")
+	}
+
+	var plaintext bytes.Buffer
+	err := WriteBitwarden(&plaintext, entries)
+	if err != nil {
+		return err
+	}
+
+	export := encryptedExport{
+		Encrypted: true,
+		Format:    encryptedFormat,
+		KDF:       exportKDF,
+		Time:      exportTime,
+		Memory:    exportMemory,
+		Threads:   exportThreads,
+	}
+
+	key, err := salt.Argon2WithParams([]byte(passphrase), nil, export.params())
+	if err != nil {
+		return err
+	}
+	export.Salt = key.Salt[:]
+
+	export.Data, err = encrypt.Seal(key.Key, plaintext.Bytes(), export.additionalData())
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(export)
+}
+
+// reports whether content looks like the output of WriteEncrypted
+func IsEncrypted(content []byte) bool {
+	var export encryptedExport
+	return json.Unmarshal(content, &export) == nil && export.Format == encryptedFormat
+}
+
+// returns the bitwarden json inside an encrypted export, which the importer package can read
+func ReadEncrypted(r io.Reader, passphrase string) ([]byte, error) {
+	var export encryptedExport
+	err := json.NewDecoder(r).Decode(&export)
+	if err != nil {
+		return nil, err
+	}
+
+	if export.Format != encryptedFormat || export.KDF != exportKDF {
+		return nil, errors.New("not an encrypted pwm export")
+	}
+	if export.Time > maxExportTime || export.Memory > maxExportMemory || export.params().Validate() != nil {
+		return nil, errors.New("encrypted export has invalid kdf parameters")
+	}
+
+	key, err := salt.Argon2WithParams([]byte(passphrase), export.Salt, export.params())
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := encrypt.Open(key.Key, export.Data, export.additionalData())
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,202 @@
+// writers for formats other password managers can read, the counterpart of
+// the importer package
+package exporter
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"pwm/database"
+)
+
+type Format string
+
+const (
+	CSV Format = "csv"
+	// the unencrypted json export of bitwarden
+	Bitwarden Format = "json"
+	// bitwarden json sealed with a passphrase, see WriteEncrypted
+	Encrypted Format = "encrypted"
+	// a keepass database, see WriteKDBX
+	KDBX Format = "kdbx"
+)
+
+var Formats = []Format{CSV, Bitwarden, Encrypted, KDBX}
+
+// every format except Encrypted and KDBX leaves the passwords readable by anyone with the file
+func (format Format) Plaintext() bool {
+	return format != Encrypted && format != KDBX
+}
+
+// entries need their passwords, as returned by GetEntry, passphrase is only used by Encrypted and KDBX
+func Write(format Format, w io.Writer, entries []database.Entry, passphrase string) error {
+	switch format {
+	case CSV:
+		return WriteCSV(w, entries)
+	case Bitwarden:
+		return WriteBitwarden(w, entries)
+	case Encrypted:
+		return WriteEncrypted(w, entries, passphrase)
+	case KDBX:
+		return WriteKDBX(w, entries, passphrase)
+	}
+	return errors.New(fmt.Sprintf("unknown export format %s", format))
+}
+
+var csvColumns = []string{"title", "username", "password", "url", "notes", "tags", "otp"}
+
+// one row per entry, urls are separated by spaces and tags by semicolons
+// custom fields get a column each, named after the field
+// password histories aren't exported
+func WriteCSV(w io.Writer, entries []database.Entry) error {
+	fieldNames := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		for name := range entry.Fields {
+			if !seen[name] {
+				seen[name] = true
+				fieldNames = append(fieldNames, name)
+			}
+		}
+	}
+	sort.Strings(fieldNames)
+
+	writer := csv.NewWriter(w)
+	err := writer.Write(append(csvColumns[:len(csvColumns):len(csvColumns)], fieldNames...))
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		row := []string{
+			entry.Title,
+			entry.Username,
+			entry.Password,
+			strings.Join(entry.URLs, " "),
+			entry.Notes,
+			strings.Join(entry.Tags, ";"),
+			entry.OTP,
+		}
+		for _, name := range fieldNames {
+			row = append(row, entry.Fields[name])
+		}
+
+		err = writer.Write(row)
+		if err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+type bitwardenExport struct {
+	Encrypted bool              `json:"encrypted"`
+	Folders   []bitwardenFolder `json:"folders"`
+	Items     []bitwardenItem   `json:"items"`
+}
+
+type bitwardenFolder struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type bitwardenItem struct {
+	ID           string           `json:"id"`
+	FolderID     *string          `json:"folderId"`
+	Type         int              `json:"type"`
+	Reprompt     int              `json:"reprompt"`
+	Name         string           `json:"name"`
+	Notes        *string          `json:"notes"`
+	Favorite     bool             `json:"favorite"`
+	Fields       []bitwardenField `json:"fields"`
+	Login        bitwardenLogin   `json:"login"`
+	RevisionDate time.Time        `json:"revisionDate"`
+	CreationDate time.Time        `json:"creationDate"`
+}
+
+type bitwardenField struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+	// 0 is text
+	Type int `json:"type"`
+}
+
+type bitwardenLogin struct {
+	URIs     []bitwardenURI `json:"uris"`
+	Username string         `json:"username"`
+	Password string         `json:"password"`
+	TOTP     *string        `json:"totp"`
+}
+
+type bitwardenURI struct {
+	Match *int   `json:"match"`
+	URI   string `json:"uri"`
+}
+
+// bitwarden has folders instead of tags, an entry goes in the folder named
+// after its first tag and its other tags are left out
+func WriteBitwarden(w io.Writer, entries []database.Entry) error {
+	export := bitwardenExport{
+		Folders: make([]bitwardenFolder, 0),
+		Items:   make([]bitwardenItem, 0, len(entries)),
+	}
+
+	folders := make(map[string]string)
+	for _, entry := range entries {
+		item := bitwardenItem{
+			ID:           entry.ID,
+			Type:         1,
+			Name:         entry.Title,
+			Fields:       make([]bitwardenField, 0, len(entry.Fields)),
+			RevisionDate: entry.Modified.UTC(),
+			CreationDate: entry.Created.UTC(),
+			Login: bitwardenLogin{
+				URIs:     make([]bitwardenURI, 0, len(entry.URLs)),
+				Username: entry.Username,
+				Password: entry.Password,
+			},
+		}
+
+		if len(entry.Tags) != 0 {
+			folderID, ok := folders[entry.Tags[0]]
+			if !ok {
+				folderID = fmt.Sprintf("folder-%d", len(folders)+1)
+				folders[entry.Tags[0]] = folderID
+				export.Folders = append(export.Folders, bitwardenFolder{ID: folderID, Name: entry.Tags[0]})
+			}
+			item.FolderID = &folderID
+		}
+		if len(entry.Notes) != 0 {
+			item.Notes = &entry.Notes
+		}
+		if len(entry.OTP) != 0 {
+			item.Login.TOTP = &entry.OTP
+		}
+		for _, u := range entry.URLs {
+			item.Login.URIs = append(item.Login.URIs, bitwardenURI{URI: u})
+		}
+
+		names := make([]string, 0, len(entry.Fields))
+		for name := range entry.Fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			item.Fields = append(item.Fields, bitwardenField{Name: name, Value: entry.Fields[name]})
+		}
+
+		export.Items = append(export.Items, item)
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(export)
+}
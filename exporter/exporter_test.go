@@ -0,0 +1,176 @@
+package exporter_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"pwm/database"
+	"pwm/exporter"
+	"pwm/importer"
+	"pwm/kdbx"
+)
+
+func testEntries() []database.Entry {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	return []database.Entry{
+		{
+			ID:       "3f1c2a8e-0000-4000-8000-000000000001",
+			Title:    "Example",
+			Username: "alice",
+			Password: "hunter2, \"quoted\"",
+			URLs:     []string{"https://example.com", "https://login.example.com"},
+			Notes:    "line one\nline two",
+			Tags:     []string{"work"},
+			Fields:   map[string]string{"pin": "1234"},
+			OTP:      "otpauth://totp/Example:alice?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ",
+			Created:  now,
+			Modified: now,
+		},
+		{
+			ID:       "3f1c2a8e-0000-4000-8000-000000000002",
+			Title:    "Mail",
+			Username: "bob",
+			Password: "secret",
+			Fields:   map[string]string{},
+			Created:  now,
+			Modified: now,
+		},
+	}
+}
+
+// compares the parts of an entry every format keeps
+func checkEntries(t *testing.T, got []database.Entry, want []database.Entry) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("expected %d entries, got %d", len(want), len(got))
+	}
+	for i := range want {
+		g, w := got[i], want[i]
+		if g.Title != w.Title || g.Username != w.Username || g.Password != w.Password || g.Notes != w.Notes {
+			t.Errorf("entry %d differs, got %+v", i, g)
+		}
+		if strings.Join(g.URLs, " ") != strings.Join(w.URLs, " ") || strings.Join(g.Tags, ";") != strings.Join(w.Tags, ";") {
+			t.Errorf("entry %d has urls %v and tags %v", i, g.URLs, g.Tags)
+		}
+		if g.Fields["pin"] != w.Fields["pin"] {
+			t.Errorf("entry %d lost its custom field", i)
+		}
+		if len(g.OTP) == 0 != (len(w.OTP) == 0) {
+			t.Errorf("entry %d has otp %q", i, g.OTP)
+		}
+	}
+}
+
+func TestCSV(t *testing.T) {
+	var buffer bytes.Buffer
+	err := exporter.Write(exporter.CSV, &buffer, testEntries(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header, _, _ := strings.Cut(buffer.String(), "\n")
+	if header != "title,username,password,url,notes,tags,otp,pin" {
+		t.Errorf("unexpected header %s", header)
+	}
+
+	mapping, err := importer.ParseMapping("title=title,username=username,password=password,url=url,notes=notes,tags=tags,otp=otp,pin=pin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := importer.ParseCSV(&buffer, mapping)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEntries(t, entries, testEntries())
+}
+
+func TestBitwarden(t *testing.T) {
+	var buffer bytes.Buffer
+	err := exporter.Write(exporter.Bitwarden, &buffer, testEntries(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := importer.ParseBitwarden(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEntries(t, entries, testEntries())
+}
+
+func TestEncrypted(t *testing.T) {
+	var buffer bytes.Buffer
+	err := exporter.Write(exporter.Encrypted, &buffer, testEntries(), "export passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := buffer.Bytes()
+
+	if bytes.Contains(content, []byte("hunter2")) || bytes.Contains(content, []byte("alice")) {
+		t.Error("encrypted export contains plaintext")
+	}
+	if !exporter.IsEncrypted(content) {
+		t.Error("export not recognised as encrypted")
+	}
+
+	_, err = exporter.ReadEncrypted(bytes.NewReader(content), "wrong passphrase")
+	if !errors.Is(err, exporter.ErrWrongPassphrase) {
+		t.Errorf("expected ErrWrongPassphrase, got %v", err)
+	}
+
+	// the kdf parameters are authenticated
+	tampered := bytes.Replace(content, []byte(`"time": 3`), []byte(`"time": 4`), 1)
+	if bytes.Equal(tampered, content) {
+		t.Fatal("test expects the time parameter to be 3")
+	}
+	_, err = exporter.ReadEncrypted(bytes.NewReader(tampered), "export passphrase")
+	if !errors.Is(err, exporter.ErrWrongPassphrase) {
+		t.Errorf("expected changed kdf parameters to fail, got %v", err)
+	}
+
+	plaintext, err := exporter.ReadEncrypted(bytes.NewReader(content), "export passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := importer.ParseBitwarden(bytes.NewReader(plaintext))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEntries(t, entries, testEntries())
+
+	err = exporter.Write(exporter.Encrypted, &buffer, testEntries(), "")
+	if err == nil {
+		t.Error("expected an empty passphrase to fail")
+	}
+}
+
+func TestKDBX(t *testing.T) {
+	var buffer bytes.Buffer
+	err := exporter.Write(exporter.KDBX, &buffer, testEntries(), "export passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(buffer.Bytes(), []byte("hunter2")) {
+		t.Error("kdbx export contains plaintext")
+	}
+
+	f, err := kdbx.Read(&buffer, "export passphrase", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := f.Entries()
+	checkEntries(t, entries, testEntries())
+	for _, entry := range entries {
+		if !entry.Created.Equal(testEntries()[0].Created) {
+			t.Errorf("entry %s was created %v", entry.Title, entry.Created)
+		}
+	}
+
+	err = exporter.Write(exporter.KDBX, &buffer, testEntries(), "")
+	if err == nil {
+		t.Error("expected an empty passphrase to fail")
+	}
+}
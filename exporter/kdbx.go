@@ -0,0 +1,28 @@
+package exporter
+
+import (
+	"errors"
+	"io"
+
+	"pwm/database"
+	"pwm/kdbx"
+)
+
+// a new kdbx 4 database keepass and keepassxc open with passphrase as its
+// master password, entries go in its top group with their tags
+func WriteKDBX(w io.Writer, entries []database.Entry, passphrase string) error {
+	if len(passphrase) == 0 {
+		return errors.New("a kdbx export needs a passphrase")
+	}
+
+	// the ids are pwm's, none of them is an entry of the new file
+	added := make([]database.Entry, len(entries))
+	for i, entry := range entries {
+		entry.ID = ""
+		added[i] = entry
+	}
+
+	f := kdbx.New("pwm export")
+	f.Update(added)
+	return f.Write(w, passphrase, nil)
+}
@@ -0,0 +1,207 @@
+package generate
+
+import (
+	"crypto/rand"
+	_ "embed"
+	"errors"
+	"math"
+	"math/big"
+	"strings"
+)
+
+const (
+	Lowercase = "abcdefghijklmnopqrstuvwxyz"
+	Uppercase = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	Digits    = "0123456789"
+	Symbols   = "!@#$%^&*()-_=+[]{};:,.<>/?~"
+
+	// characters that are easily confused with each other when read or typed
+	Ambiguous = "Il1O0o|"
+)
+
+// the bip-0039 english wordlist, 2048 words so every word adds 11 bits
+//
+//go:embed wordlist.txt
+var wordlistFile string
+
+var wordlist = strings.Fields(wordlistFile)
+
+type Options struct {
+	Length           int
+	Lowercase        bool
+	Uppercase        bool
+	Digits           bool
+	Symbols          bool
+	ExcludeAmbiguous bool
+	// at least one character of every enabled class is guaranteed
+	RequireEach bool
+}
+
+func DefaultOptions() Options {
+	return Options{
+		Length:           20,
+		Lowercase:        true,
+		Uppercase:        true,
+		Digits:           true,
+		Symbols:          true,
+		ExcludeAmbiguous: true,
+		RequireEach:      true,
+	}
+}
+
+func (opts *Options) classes() []string {
+	classes := make([]string, 0, 4)
+	add := func(enabled bool, class string) {
+		if !enabled {
+			return
+		}
+		if opts.ExcludeAmbiguous {
+			class = strings.Map(func(r rune) rune {
+				if strings.ContainsRune(Ambiguous, r) {
+					return -1
+				}
+				return r
+			}, class)
+		}
+		classes = append(classes, class)
+	}
+
+	add(opts.Lowercase, Lowercase)
+	add(opts.Uppercase, Uppercase)
+	add(opts.Digits, Digits)
+	add(opts.Symbols, Symbols)
+
+	return classes
+}
+
+func (opts *Options) validate() error {
+	classes := opts.classes()
+	if len(classes) == 0 {
+		return errors.New("at least one character class is needed")
+	}
+	if opts.Length < 1 {
+		return errors.New("password length must be positive")
+	}
+	if opts.RequireEach && opts.Length < len(classes) {
+		return errors.New("password is too short to contain every character class")
+	}
+	return nil
+}
+
+func Password(opts Options) (string, error) {
+	err := opts.validate()
+	if err != nil {
+		return "", err
+	}
+
+	classes := opts.classes()
+	alphabet := strings.Join(classes, "")
+
+	// rejecting candidates that miss a class keeps the result uniform over every valid password
+	password := make([]byte, opts.Length)
+	for {
+		for i := range password {
+			n, err := randomIndex(len(alphabet))
+			if err != nil {
+				return "", err
+			}
+			password[i] = alphabet[n]
+		}
+
+		if !opts.RequireEach || containsEach(string(password), classes) {
+			return string(password), nil
+		}
+	}
+}
+
+// the exact entropy in bits of a password generated with opts
+func PasswordEntropy(opts Options) (float64, error) {
+	err := opts.validate()
+	if err != nil {
+		return 0, err
+	}
+
+	classes := opts.classes()
+	alphabetSize := len(strings.Join(classes, ""))
+	if !opts.RequireEach {
+		return float64(opts.Length) * math.Log2(float64(alphabetSize)), nil
+	}
+
+	// inclusion-exclusion over the classes that could be missing
+	length := big.NewInt(int64(opts.Length))
+	count := new(big.Int)
+	for subset := 0; subset < 1<<len(classes); subset++ {
+		remaining := alphabetSize
+		excluded := 0
+		for i, class := range classes {
+			if subset&(1<<i) != 0 {
+				remaining -= len(class)
+				excluded++
+			}
+		}
+
+		term := new(big.Int).Exp(big.NewInt(int64(remaining)), length, nil)
+		if excluded%2 == 0 {
+			count.Add(count, term)
+		} else {
+			count.Sub(count, term)
+		}
+	}
+
+	return log2(count), nil
+}
+
+// diceware style passphrase made of words from the embedded wordlist
+func Passphrase(words int, separator string) (string, error) {
+	if words < 1 {
+		return "", errors.New("passphrase needs at least one word")
+	}
+
+	chosen := make([]string, words)
+	for i := range chosen {
+		n, err := randomIndex(len(wordlist))
+		if err != nil {
+			return "", err
+		}
+		chosen[i] = wordlist[n]
+	}
+
+	return strings.Join(chosen, separator), nil
+}
+
+func PassphraseEntropy(words int) float64 {
+	return float64(words) * math.Log2(float64(len(wordlist)))
+}
+
+func randomIndex(n int) (int, error) {
+	index, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, err
+	}
+	return int(index.Int64()), nil
+}
+
+func containsEach(password string, classes []string) bool {
+	for _, class := range classes {
+		if !strings.ContainsAny(password, class) {
+			return false
+		}
+	}
+	return true
+}
+
+func log2(n *big.Int) float64 {
+	if n.Sign() <= 0 {
+		return 0
+	}
+
+	f, _ := new(big.Float).SetInt(n).Float64()
+	if !math.IsInf(f, 0) {
+		return math.Log2(f)
+	}
+
+	// too large for a float64, drop the low bits that can't affect the result
+	shift := n.BitLen() - 64
+	f, _ = new(big.Float).SetInt(new(big.Int).Rsh(n, uint(shift))).Float64()
+	return math.Log2(f) + float64(shift)
+}
@@ -0,0 +1,101 @@
+package generate_test
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"pwm/generate"
+)
+
+func TestPassword(t *testing.T) {
+	opts := generate.DefaultOptions()
+	for i := 0; i < 100; i++ {
+		password, err := generate.Password(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(password) != opts.Length {
+			t.Error("password has the wrong length")
+		}
+		if strings.ContainsAny(password, generate.Ambiguous) {
+			t.Error("password contains ambiguous characters")
+		}
+		for _, class := range []string{generate.Lowercase, generate.Uppercase, generate.Digits, generate.Symbols} {
+			if !strings.ContainsAny(password, class) {
+				t.Errorf("password %s is missing a character class", password)
+			}
+		}
+	}
+}
+
+func TestPasswordOptions(t *testing.T) {
+	password, err := generate.Password(generate.Options{Length: 12, Digits: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Trim(password, generate.Digits) != "" {
+		t.Error("password contains characters outside of the digits class")
+	}
+
+	_, err = generate.Password(generate.Options{Length: 12})
+	if err == nil {
+		t.Error("expected no character classes to fail")
+	}
+
+	_, err = generate.Password(generate.Options{Length: 2, Lowercase: true, Uppercase: true, Digits: true, RequireEach: true})
+	if err == nil {
+		t.Error("expected a password shorter than the required classes to fail")
+	}
+}
+
+func TestPasswordEntropy(t *testing.T) {
+	entropy, err := generate.PasswordEntropy(generate.Options{Length: 4, Digits: true, RequireEach: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(entropy-math.Log2(10000)) > 1e-9 {
+		t.Errorf("expected %f bits, got %f", math.Log2(10000), entropy)
+	}
+
+	// of the 52^2 two character passwords only 2*26*26 contain both cases
+	entropy, err = generate.PasswordEntropy(generate.Options{Length: 2, Lowercase: true, Uppercase: true, RequireEach: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(entropy-math.Log2(2*26*26)) > 1e-9 {
+		t.Errorf("expected %f bits, got %f", math.Log2(2*26*26), entropy)
+	}
+
+	opts := generate.DefaultOptions()
+	opts.Length = 200
+	entropy, err = generate.PasswordEntropy(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entropy < 1000 || math.IsInf(entropy, 0) {
+		t.Errorf("unexpected entropy %f for a long password", entropy)
+	}
+}
+
+func TestPassphrase(t *testing.T) {
+	passphrase, err := generate.Passphrase(6, "-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	words := strings.Split(passphrase, "-")
+	if len(words) != 6 {
+		t.Errorf("expected 6 words in %s", passphrase)
+	}
+	for _, word := range words {
+		if len(word) == 0 {
+			t.Error("passphrase contains an empty word")
+		}
+	}
+
+	if generate.PassphraseEntropy(6) != 66 {
+		t.Error("expected 11 bits per word")
+	}
+}
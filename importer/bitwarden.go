@@ -0,0 +1,120 @@
+package importer
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+
+	"pwm/database"
+)
+
+// bitwarden item types
+const (
+	bitwardenLogin      = 1
+	bitwardenSecureNote = 2
+	bitwardenCard       = 3
+	bitwardenIdentity   = 4
+)
+
+type bitwardenExport struct {
+	Encrypted bool `json:"encrypted"`
+	Folders   []struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"folders"`
+	Items []bitwardenItem `json:"items"`
+}
+
+type bitwardenItem struct {
+	Type     int     `json:"type"`
+	Name     string  `json:"name"`
+	Notes    *string `json:"notes"`
+	FolderID *string `json:"folderId"`
+	Fields   []struct {
+		Name  string  `json:"name"`
+		Value *string `json:"value"`
+	} `json:"fields"`
+	Login *struct {
+		URIs []struct {
+			URI *string `json:"uri"`
+		} `json:"uris"`
+		Username *string `json:"username"`
+		Password *string `json:"password"`
+		TOTP     *string `json:"totp"`
+	} `json:"login"`
+	Card     map[string]*string `json:"card"`
+	Identity map[string]*string `json:"identity"`
+}
+
+// the unencrypted json export, folders become tags and cards and identities
+// are kept as custom fields
+func ParseBitwarden(r io.Reader) ([]database.Entry, error) {
+	var export bitwardenExport
+	err := json.NewDecoder(r).Decode(&export)
+	if err != nil {
+		return nil, err
+	}
+	if export.Encrypted {
+		return nil, errors.New("encrypted bitwarden exports can't be imported, export unencrypted json instead")
+	}
+
+	folders := make(map[string]string, len(export.Folders))
+	for _, folder := range export.Folders {
+		folders[folder.ID] = folder.Name
+	}
+
+	entries := make([]database.Entry, 0, len(export.Items))
+	for _, item := range export.Items {
+		entry := database.Entry{
+			Title:  item.Name,
+			Notes:  deref(item.Notes),
+			Fields: make(map[string]string),
+		}
+		if item.FolderID != nil {
+			if folder, ok := folders[*item.FolderID]; ok {
+				entry.Tags = append(entry.Tags, folder)
+			}
+		}
+
+		for _, field := range item.Fields {
+			entry.Fields[field.Name] = deref(field.Value)
+		}
+
+		switch {
+		case item.Type == bitwardenLogin && item.Login != nil:
+			entry.Username = deref(item.Login.Username)
+			entry.Password = deref(item.Login.Password)
+			entry.OTP = deref(item.Login.TOTP)
+			for _, uri := range item.Login.URIs {
+				entry.URLs = append(entry.URLs, deref(uri.URI))
+			}
+		case item.Type == bitwardenCard:
+			copyFields(entry.Fields, item.Card)
+		case item.Type == bitwardenIdentity:
+			copyFields(entry.Fields, item.Identity)
+			entry.Username = deref(item.Identity["username"])
+		}
+
+		if entry, ok := normalize(entry); ok {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, nil
+}
+
+func copyFields(fields map[string]string, values map[string]*string) {
+	for name, value := range values {
+		if value != nil && len(*value) != 0 {
+			fields[name] = *value
+		}
+	}
+}
+
+// bitwarden uses null for most missing values
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
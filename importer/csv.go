@@ -0,0 +1,154 @@
+package importer
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"pwm/database"
+)
+
+// names of the entry fields a csv column can be mapped to, a column mapped to
+// any other name becomes a custom field with that name
+// a url column can hold several urls separated by spaces, a tags column
+// several tags separated by commas or semicolons
+const (
+	FieldTitle    = "title"
+	FieldUsername = "username"
+	FieldPassword = "password"
+	FieldURL      = "url"
+	FieldNotes    = "notes"
+	FieldTags     = "tags"
+	FieldOTP      = "otp"
+	// the column is left out
+	FieldIgnore = "-"
+)
+
+// lowercase column name to entry field, columns that aren't mapped are left out
+type Mapping map[string]string
+
+var chromeMapping = Mapping{
+	"name":     FieldTitle,
+	"url":      FieldURL,
+	"username": FieldUsername,
+	"password": FieldPassword,
+	"note":     FieldNotes,
+}
+
+// firefox has no title column, titles come from the url
+var firefoxMapping = Mapping{
+	"url":      FieldURL,
+	"username": FieldUsername,
+	"password": FieldPassword,
+}
+
+// both the 1password 8 and the older 1password 7 column names
+var onePasswordMapping = Mapping{
+	"title":    FieldTitle,
+	"url":      FieldURL,
+	"website":  FieldURL,
+	"username": FieldUsername,
+	"password": FieldPassword,
+	"otpauth":  FieldOTP,
+	"tags":     FieldTags,
+	"notes":    FieldNotes,
+}
+
+// parses a "column=field,column=field" list, as given on the command line
+func ParseMapping(spec string) (Mapping, error) {
+	mapping := make(Mapping)
+	for _, pair := range strings.Split(spec, ",") {
+		column, field, ok := strings.Cut(pair, "=")
+		column = strings.ToLower(strings.TrimSpace(column))
+		field = strings.TrimSpace(field)
+		if !ok || len(column) == 0 || len(field) == 0 {
+			return nil, errors.New(fmt.Sprintf("invalid column mapping %q", pair))
+		}
+		mapping[column] = field
+	}
+	return mapping, nil
+}
+
+// the first row names the columns, mapping decides which entry field each one fills
+func ParseCSV(r io.Reader, mapping Mapping) ([]database.Entry, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("csv file is empty")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make([]string, len(header))
+	mapped := false
+	for i, column := range header {
+		// excel likes to start files with a byte order mark
+		column = strings.TrimPrefix(column, "\ufeff")
+		field, ok := mapping[strings.ToLower(strings.TrimSpace(column))]
+		if !ok {
+			field = FieldIgnore
+		}
+		fields[i] = field
+		mapped = mapped || field != FieldIgnore
+	}
+	if !mapped {
+		return nil, errors.New("none of the csv columns are mapped to an entry field")
+	}
+
+	entries := make([]database.Entry, 0)
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var entry database.Entry
+		for i, value := range row {
+			if i >= len(fields) || len(value) == 0 {
+				continue
+			}
+			setField(&entry, fields[i], value)
+		}
+
+		if entry, ok := normalize(entry); ok {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, nil
+}
+
+func setField(entry *database.Entry, field string, value string) {
+	switch field {
+	case FieldIgnore:
+	case FieldTitle:
+		entry.Title = value
+	case FieldUsername:
+		entry.Username = value
+	case FieldPassword:
+		entry.Password = value
+	case FieldURL:
+		entry.URLs = append(entry.URLs, strings.Fields(value)...)
+	case FieldNotes:
+		entry.Notes = value
+	case FieldTags:
+		entry.Tags = append(entry.Tags, strings.FieldsFunc(value, func(r rune) bool {
+			return r == ',' || r == ';'
+		})...)
+	case FieldOTP:
+		entry.OTP = value
+	default:
+		if entry.Fields == nil {
+			entry.Fields = make(map[string]string)
+		}
+		entry.Fields[field] = value
+	}
+}
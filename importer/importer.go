@@ -0,0 +1,159 @@
+// parsers for the exports of other password managers, the package can't be
+// called import as that is a keyword
+package importer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"slices"
+	"strings"
+
+	"pwm/database"
+	"pwm/otp"
+)
+
+type Format string
+
+const (
+	Bitwarden       Format = "bitwarden"
+	KeePassXML      Format = "keepass"
+	OnePassword1PUX Format = "1pux"
+	OnePasswordCSV  Format = "1password-csv"
+	ChromeCSV       Format = "chrome"
+	FirefoxCSV      Format = "firefox"
+	// a csv file whose columns are named with a Mapping
+	GenericCSV Format = "csv"
+)
+
+var Formats = []Format{Bitwarden, KeePassXML, OnePassword1PUX, OnePasswordCSV, ChromeCSV, FirefoxCSV, GenericCSV}
+
+// mapping is only used by GenericCSV
+func Parse(format Format, r io.Reader, mapping Mapping) ([]database.Entry, error) {
+	switch format {
+	case Bitwarden:
+		return ParseBitwarden(r)
+	case KeePassXML:
+		return ParseKeePassXML(r)
+	case OnePassword1PUX:
+		return Parse1PUX(r)
+	case OnePasswordCSV:
+		return ParseCSV(r, onePasswordMapping)
+	case ChromeCSV:
+		return ParseCSV(r, chromeMapping)
+	case FirefoxCSV:
+		return ParseCSV(r, firefoxMapping)
+	case GenericCSV:
+		if len(mapping) == 0 {
+			return nil, errors.New("a generic csv import needs a column mapping")
+		}
+		return ParseCSV(r, mapping)
+	}
+	return nil, errors.New(fmt.Sprintf("unknown import format %s", format))
+}
+
+// guesses the format from a file name, csv exports all look alike so they
+// have to be named explicitly
+func DetectFormat(fileName string) (Format, bool) {
+	lower := strings.ToLower(fileName)
+	switch {
+	case strings.HasSuffix(lower, ".json"):
+		return Bitwarden, true
+	case strings.HasSuffix(lower, ".xml"):
+		return KeePassXML, true
+	case strings.HasSuffix(lower, ".1pux"):
+		return OnePassword1PUX, true
+	}
+	return "", false
+}
+
+// cleans up entries read by other packages, such as kdbx, the way the parsers
+// here clean up theirs, entries with nothing worth importing are dropped
+func Normalize(entries []database.Entry) []database.Entry {
+	normalized := make([]database.Entry, 0, len(entries))
+	for _, entry := range entries {
+		if entry, ok := normalize(entry); ok {
+			normalized = append(normalized, entry)
+		}
+	}
+	return normalized
+}
+
+// cleans up an entry the way every parser needs, returns false for entries
+// with nothing worth importing
+func normalize(entry database.Entry) (database.Entry, bool) {
+	entry.Title = strings.TrimSpace(entry.Title)
+	entry.Username = strings.TrimSpace(entry.Username)
+
+	urls := make([]string, 0, len(entry.URLs))
+	for _, u := range entry.URLs {
+		u = strings.TrimSpace(u)
+		if len(u) != 0 && !slices.Contains(urls, u) {
+			urls = append(urls, u)
+		}
+	}
+	entry.URLs = urls
+
+	tags := make([]string, 0, len(entry.Tags))
+	for _, tag := range entry.Tags {
+		tag = strings.TrimSpace(tag)
+		if len(tag) != 0 && !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	entry.Tags = tags
+
+	if len(entry.Title) == 0 {
+		entry.Title = siteName(entry)
+	}
+	if len(entry.Title) == 0 {
+		entry.Title = entry.Username
+	}
+
+	// a secret the database would refuse is kept as a field rather than lost
+	entry.OTP = otpURI(entry.OTP, entry.Title, entry.Username)
+	if len(entry.OTP) != 0 {
+		if _, err := otp.ParseURI(entry.OTP); err != nil {
+			if entry.Fields == nil {
+				entry.Fields = make(map[string]string)
+			}
+			entry.Fields["otp"] = entry.OTP
+			entry.OTP = ""
+		}
+	}
+
+	empty := len(entry.Title) == 0 && len(entry.Username) == 0 && len(entry.Password) == 0 && len(entry.Notes) == 0
+	return entry, !empty
+}
+
+// some exports only have the base32 secret instead of an otpauth:// uri
+func otpURI(value string, issuer string, account string) string {
+	value = strings.TrimSpace(value)
+	if len(value) == 0 || strings.HasPrefix(strings.ToLower(value), "otpauth://") {
+		return value
+	}
+
+	key, err := otp.ParseURI("otpauth://totp/?secret=" + url.QueryEscape(value))
+	if err != nil {
+		return value
+	}
+	key.Issuer = issuer
+	key.Account = account
+	return key.URI()
+}
+
+// the host of the first url, lowercase and without www.
+func siteName(entry database.Entry) string {
+	for _, raw := range entry.URLs {
+		if !strings.Contains(raw, "://") {
+			raw = "https://" + raw
+		}
+		u, err := url.Parse(raw)
+		if err != nil || len(u.Hostname()) == 0 {
+			continue
+		}
+		return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	}
+	return ""
+}
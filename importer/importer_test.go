@@ -0,0 +1,325 @@
+package importer_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"strings"
+	"testing"
+
+	"pwm/database"
+	"pwm/importer"
+)
+
+const otpSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+const bitwardenJSON = `{
+  "encrypted": false,
+  "folders": [{"id": "f1", "name": "Work"}],
+  "items": [
+    {
+      "type": 1, "name": "Example", "notes": null, "folderId": "f1",
+      "fields": [{"name": "pin", "value": "1234", "type": 1}],
+      "login": {
+        "uris": [{"match": null, "uri": "https://example.com/login"}],
+        "username": "alice", "password": "hunter2", "totp": "` + otpSecret + `"
+      }
+    },
+    {"type": 2, "name": "Note", "notes": "just a note", "folderId": null, "secureNote": {"type": 0}},
+    {"type": 1, "name": "", "notes": null, "login": {"uris": [], "username": null, "password": null}}
+  ]
+}`
+
+func TestBitwarden(t *testing.T) {
+	entries, err := importer.ParseBitwarden(strings.NewReader(bitwardenJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, the empty item should be left out, got %d", len(entries))
+	}
+
+	login := entries[0]
+	if login.Title != "Example" || login.Username != "alice" || login.Password != "hunter2" {
+		t.Errorf("unexpected login %+v", login)
+	}
+	if len(login.URLs) != 1 || login.URLs[0] != "https://example.com/login" {
+		t.Errorf("unexpected urls %v", login.URLs)
+	}
+	if len(login.Tags) != 1 || login.Tags[0] != "Work" {
+		t.Errorf("expected the folder as a tag, got %v", login.Tags)
+	}
+	if login.Fields["pin"] != "1234" {
+		t.Error("custom field missing")
+	}
+	if !strings.HasPrefix(login.OTP, "otpauth://totp/") || !strings.Contains(login.OTP, "secret="+otpSecret) {
+		t.Errorf("bare totp secret wasn't turned into a uri: %s", login.OTP)
+	}
+
+	if entries[1].Notes != "just a note" {
+		t.Error("secure note lost its notes")
+	}
+
+	_, err = importer.ParseBitwarden(strings.NewReader(`{"encrypted": true, "items": []}`))
+	if err == nil {
+		t.Error("expected an encrypted export to fail")
+	}
+}
+
+const keePassXML = `<?xml version="1.0" encoding="utf-8" standalone="yes"?>
+<KeePassFile>
+  <Meta><RecycleBinUUID>YmluYmluYmluYmluYmluYg==</RecycleBinUUID></Meta>
+  <Root>
+    <Group>
+      <UUID>cm9vdHJvb3Ryb290cm9vdA==</UUID>
+      <Name>Database</Name>
+      <Entry>
+        <Tags>a;b</Tags>
+        <String><Key>Title</Key><Value>Mail</Value></String>
+        <String><Key>UserName</Key><Value>bob</Value></String>
+        <String><Key>Password</Key><Value ProtectedInMemory="True">secret</Value></String>
+        <String><Key>URL</Key><Value>https://mail.example.com</Value></String>
+        <String><Key>Recovery</Key><Value>codes</Value></String>
+        <History>
+          <Entry><String><Key>Title</Key><Value>Old mail</Value></String></Entry>
+        </History>
+      </Entry>
+      <Group>
+        <UUID>c29jaWFsc29jaWFsc29jaQ==</UUID>
+        <Name>Social</Name>
+        <Entry>
+          <String><Key>Title</Key><Value>Forum</Value></String>
+          <String><Key>UserName</Key><Value>bob</Value></String>
+          <String><Key>otp</Key><Value>otpauth://totp/forum:bob?secret=` + otpSecret + `</Value></String>
+        </Entry>
+      </Group>
+      <Group>
+        <UUID>YmluYmluYmluYmluYmluYg==</UUID>
+        <Name>Recycle Bin</Name>
+        <Entry><String><Key>Title</Key><Value>Deleted</Value></String></Entry>
+      </Group>
+    </Group>
+  </Root>
+</KeePassFile>`
+
+func TestKeePassXML(t *testing.T) {
+	entries, err := importer.ParseKeePassXML(strings.NewReader(keePassXML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries without history and recycle bin, got %d", len(entries))
+	}
+
+	mail := entries[0]
+	if mail.Title != "Mail" || mail.Username != "bob" || mail.Password != "secret" {
+		t.Errorf("unexpected entry %+v", mail)
+	}
+	if strings.Join(mail.Tags, ",") != "a,b" {
+		t.Errorf("unexpected tags %v", mail.Tags)
+	}
+	if mail.Fields["Recovery"] != "codes" {
+		t.Error("custom string missing")
+	}
+
+	forum := entries[1]
+	if strings.Join(forum.Tags, ",") != "Social" {
+		t.Errorf("expected the group as a tag, got %v", forum.Tags)
+	}
+	if len(forum.OTP) == 0 {
+		t.Error("otp string missing")
+	}
+}
+
+const onePasswordData = `{
+  "accounts": [{
+    "vaults": [{
+      "attrs": {"name": "Private"},
+      "items": [
+        {
+          "state": "active",
+          "details": {
+            "loginFields": [
+              {"value": "carol", "designation": "username"},
+              {"value": "pa55", "designation": "password"}
+            ],
+            "notesPlain": "notes",
+            "sections": [{"fields": [
+              {"title": "one-time password", "value": {"totp": "otpauth://totp/shop?secret=` + otpSecret + `"}},
+              {"title": "pin", "value": {"concealed": "0000"}},
+              {"title": "expiry", "value": {"monthYear": 202612}}
+            ]}]
+          },
+          "overview": {"title": "Shop", "url": "https://shop.example.com", "tags": ["shopping"]}
+        },
+        {"state": "trashed", "details": {}, "overview": {"title": "Gone"}}
+      ]
+    }]
+  }]
+}`
+
+func Test1PUX(t *testing.T) {
+	var archive bytes.Buffer
+	w := zip.NewWriter(&archive)
+	f, err := w.Create("export.data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte(onePasswordData))
+	w.Close()
+
+	entries, err := importer.Parse1PUX(&archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected the trashed item to be left out, got %d entries", len(entries))
+	}
+
+	shop := entries[0]
+	if shop.Title != "Shop" || shop.Username != "carol" || shop.Password != "pa55" || shop.Notes != "notes" {
+		t.Errorf("unexpected entry %+v", shop)
+	}
+	if strings.Join(shop.Tags, ",") != "shopping,Private" {
+		t.Errorf("unexpected tags %v", shop.Tags)
+	}
+	if len(shop.OTP) == 0 || shop.Fields["pin"] != "0000" {
+		t.Errorf("section fields missing %+v", shop)
+	}
+	if _, ok := shop.Fields["expiry"]; ok {
+		t.Error("non string value should be left out")
+	}
+}
+
+func TestCSV(t *testing.T) {
+	chrome := "name,url,username,password,note\n" +
+		"Example,https://example.com,alice,hunter2,\n" +
+		",https://www.other.com/x,bob,pw,\n"
+	entries, err := importer.Parse(importer.ChromeCSV, strings.NewReader(chrome), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Username != "alice" || entries[0].Password != "hunter2" {
+		t.Fatalf("unexpected entries %+v", entries)
+	}
+	if entries[1].Title != "other.com" {
+		t.Errorf("expected the title to come from the url, got %s", entries[1].Title)
+	}
+
+	firefox := "\"url\",\"username\",\"password\",\"httpRealm\",\"formActionOrigin\",\"guid\",\"timeCreated\",\"timeLastUsed\",\"timePasswordChanged\"\n" +
+		"\"https://example.com\",\"alice\",\"hunter2\",,\"\",\"{x}\",\"1\",\"1\",\"1\"\n"
+	entries, err = importer.Parse(importer.FirefoxCSV, strings.NewReader(firefox), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Title != "example.com" || entries[0].Password != "hunter2" {
+		t.Errorf("unexpected entries %+v", entries)
+	}
+
+	mapping, err := importer.ParseMapping("Site=title, Login=username,Secret=password,Group=tags,Question=security question")
+	if err != nil {
+		t.Fatal(err)
+	}
+	generic := "Site,Login,Secret,Group,Question,Unused\nBank,dave,p,money;important,pet,x\n"
+	entries, err = importer.Parse(importer.GenericCSV, strings.NewReader(generic), mapping)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry, got %d", len(entries))
+	}
+	bank := entries[0]
+	if bank.Title != "Bank" || bank.Username != "dave" || bank.Password != "p" || strings.Join(bank.Tags, ",") != "money,important" {
+		t.Errorf("unexpected entry %+v", bank)
+	}
+	if bank.Fields["security question"] != "pet" || len(bank.Fields) != 1 {
+		t.Errorf("unexpected fields %v", bank.Fields)
+	}
+
+	_, err = importer.Parse(importer.GenericCSV, strings.NewReader(generic), nil)
+	if err == nil {
+		t.Error("expected a generic csv without mapping to fail")
+	}
+	_, err = importer.ParseMapping("title")
+	if err == nil {
+		t.Error("expected a mapping without = to fail")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	db, err := database.New("master", database.DefaultKDF())
+	if err != nil {
+		t.Fatal(err)
+	}
+	existingID, err := db.AddEntry(database.Entry{Title: "Example", Username: "alice", Password: "old", URLs: []string{"https://example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.AddEntry(database.Entry{Title: "Mail", Username: "bob", Password: "same"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	imported := []database.Entry{
+		// same host, different title and password
+		{Title: "example.com", Username: "alice", Password: "new", URLs: []string{"https://www.example.com/login"}},
+		{Title: "mail", Username: "bob", Password: "same"},
+		{Title: "Shop", Username: "carol", Password: "x"},
+		{Title: "Shop", Username: "carol", Password: "x"},
+		{Title: "Shop", Username: "carol", Password: "y"},
+	}
+
+	report, err := importer.Check(db, imported)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.New) != 2 || len(report.Duplicates) != 2 || len(report.Conflicts) != 1 {
+		t.Fatalf("expected 2 new, 2 duplicates and 1 conflict, got %d, %d and %d", len(report.New), len(report.Duplicates), len(report.Conflicts))
+	}
+	if report.Conflicts[0].Existing.ID != existingID || report.Conflicts[0].Existing.Password != "old" {
+		t.Errorf("unexpected conflict %+v", report.Conflicts[0])
+	}
+
+	if len(db.Entries()) != 2 {
+		t.Fatal("check must not change the database")
+	}
+
+	count, err := report.Apply(db, importer.ReplaceConflicts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 || len(db.Entries()) != 4 {
+		t.Errorf("expected 3 imported entries and 4 in total, got %d and %d", count, len(db.Entries()))
+	}
+
+	entry, err := db.GetEntry(existingID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Password != "new" {
+		t.Error("conflict wasn't replaced")
+	}
+	history, err := db.GetPasswordHistory(existingID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 || history[0].Password != "old" {
+		t.Error("replaced password should be in the history")
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	entries := importer.Normalize([]database.Entry{
+		{Title: " Forum ", Username: "dave", Password: "pw", OTP: "key=JBSWY3DPEHPK3PXP&size=6&step=30"},
+		{Title: "Mail", Username: "erin", OTP: otpSecret},
+		{},
+	})
+	if len(entries) != 2 {
+		t.Fatalf("expected the empty entry to be dropped, got %d entries", len(entries))
+	}
+	if entries[0].Title != "Forum" || len(entries[0].OTP) != 0 || entries[0].Fields["otp"] != "key=JBSWY3DPEHPK3PXP&size=6&step=30" {
+		t.Errorf("expected a keeotp string to become a field, got %+v", entries[0])
+	}
+	if !strings.HasPrefix(entries[1].OTP, "otpauth://totp/") {
+		t.Errorf("expected a bare secret to become a uri, got %q", entries[1].OTP)
+	}
+}
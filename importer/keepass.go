@@ -0,0 +1,28 @@
+package importer
+
+import (
+	"io"
+
+	"pwm/database"
+	"pwm/kdbx"
+)
+
+// the unencrypted keepass 2 xml export, read by kdbx as it reads kdbx files so the
+// names of the groups an entry is in become its tags, the recycle bin and entry
+// histories are left out
+func ParseKeePassXML(r io.Reader) ([]database.Entry, error) {
+	entries, err := kdbx.ParseXML(r)
+	if err != nil {
+		return nil, err
+	}
+	return FromKeePass(entries), nil
+}
+
+// cleans up the entries of a kdbx file or keepass xml export for importing, their
+// ids are keepass uuids and the vault makes its own
+func FromKeePass(entries []database.Entry) []database.Entry {
+	for i := range entries {
+		entries[i].ID = ""
+	}
+	return Normalize(entries)
+}
@@ -0,0 +1,141 @@
+package importer
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+
+	"pwm/database"
+)
+
+// the item data of a 1pux archive
+const onePasswordData = "export.data"
+
+type onePasswordExport struct {
+	Accounts []struct {
+		Vaults []struct {
+			Attrs struct {
+				Name string `json:"name"`
+			} `json:"attrs"`
+			Items []onePasswordItem `json:"items"`
+		} `json:"vaults"`
+	} `json:"accounts"`
+}
+
+type onePasswordItem struct {
+	State   string `json:"state"`
+	Details struct {
+		LoginFields []struct {
+			Value       string `json:"value"`
+			Designation string `json:"designation"`
+		} `json:"loginFields"`
+		NotesPlain string `json:"notesPlain"`
+		Password   string `json:"password"`
+		Sections   []struct {
+			Fields []struct {
+				Title string `json:"title"`
+				// a single key naming the kind of value, like string, concealed or totp
+				Value map[string]json.RawMessage `json:"value"`
+			} `json:"fields"`
+		} `json:"sections"`
+	} `json:"details"`
+	Overview struct {
+		Title string `json:"title"`
+		URL   string `json:"url"`
+		URLs  []struct {
+			URL string `json:"url"`
+		} `json:"urls"`
+		Tags []string `json:"tags"`
+	} `json:"overview"`
+}
+
+// the 1pux archive exported by 1password 8, vault names become tags and
+// items in the trash are left out
+func Parse1PUX(r io.Reader) ([]database.Entry, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := archive.Open(onePasswordData)
+	if err != nil {
+		return nil, errors.New("1pux archive has no export.data")
+	}
+	defer data.Close()
+
+	var export onePasswordExport
+	err = json.NewDecoder(data).Decode(&export)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]database.Entry, 0)
+	for _, account := range export.Accounts {
+		for _, vault := range account.Vaults {
+			for _, item := range vault.Items {
+				if item.State == "trashed" {
+					continue
+				}
+
+				entry := onePasswordToEntry(item)
+				entry.Tags = append(entry.Tags, vault.Attrs.Name)
+				if entry, ok := normalize(entry); ok {
+					entries = append(entries, entry)
+				}
+			}
+		}
+	}
+
+	return entries, nil
+}
+
+func onePasswordToEntry(item onePasswordItem) database.Entry {
+	entry := database.Entry{
+		Title:    item.Overview.Title,
+		Notes:    item.Details.NotesPlain,
+		Password: item.Details.Password,
+		Tags:     item.Overview.Tags,
+		Fields:   make(map[string]string),
+	}
+
+	entry.URLs = append(entry.URLs, item.Overview.URL)
+	for _, u := range item.Overview.URLs {
+		entry.URLs = append(entry.URLs, u.URL)
+	}
+
+	for _, field := range item.Details.LoginFields {
+		switch field.Designation {
+		case "username":
+			entry.Username = field.Value
+		case "password":
+			entry.Password = field.Value
+		}
+	}
+
+	for _, section := range item.Details.Sections {
+		for _, field := range section.Fields {
+			for kind, raw := range field.Value {
+				// values that aren't plain strings, like dates and addresses, are left out
+				var value string
+				if json.Unmarshal(raw, &value) != nil || len(value) == 0 {
+					continue
+				}
+
+				if kind == "totp" && len(entry.OTP) == 0 {
+					entry.OTP = value
+				} else {
+					entry.Fields[field.Title] = value
+				}
+			}
+		}
+	}
+
+	return entry
+}
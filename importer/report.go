@@ -0,0 +1,138 @@
+package importer
+
+import (
+	"strings"
+
+	"pwm/database"
+)
+
+type Match struct {
+	Imported database.Entry
+	// the entry already in the database, or for duplicates within the import
+	// the earlier imported entry, which has no id
+	Existing database.Entry
+}
+
+// what an import would do to a database, nothing is written until Apply
+type Report struct {
+	New []database.Entry
+	// the same account with the same password, these are never imported
+	Duplicates []Match
+	// the same account with a different password
+	Conflicts []Match
+}
+
+type ConflictPolicy int
+
+const (
+	// the existing entry is kept and the imported one dropped
+	SkipConflicts ConflictPolicy = iota
+	// the existing entry is overwritten, its old password moves to the history
+	ReplaceConflicts
+	// the imported entry is added next to the existing one
+	KeepBoth
+)
+
+// compares the imported entries with the database and with each other, two
+// entries are the same account when their usernames match and so do their
+// titles or the hosts of their urls
+func Check(db *database.Database, entries []database.Entry) (Report, error) {
+	var report Report
+	existing := db.Entries()
+
+	for _, imported := range entries {
+		match, found, err := findAccount(db, existing, imported)
+		if err != nil {
+			return report, err
+		}
+		if found {
+			if match.Password == imported.Password {
+				report.Duplicates = append(report.Duplicates, Match{Imported: imported, Existing: match})
+			} else {
+				report.Conflicts = append(report.Conflicts, Match{Imported: imported, Existing: match})
+			}
+			continue
+		}
+
+		// an import can't conflict with itself, only exact copies are dropped
+		duplicate := false
+		for _, earlier := range report.New {
+			if sameAccount(earlier, imported) && earlier.Password == imported.Password {
+				report.Duplicates = append(report.Duplicates, Match{Imported: imported, Existing: earlier})
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			report.New = append(report.New, imported)
+		}
+	}
+
+	return report, nil
+}
+
+// returns the number of entries added or replaced
+func (report Report) Apply(db *database.Database, policy ConflictPolicy) (int, error) {
+	count := 0
+	for _, entry := range report.New {
+		_, err := db.AddEntry(entry)
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	for _, conflict := range report.Conflicts {
+		var err error
+		switch policy {
+		case SkipConflicts:
+			continue
+		case ReplaceConflicts:
+			err = db.UpdateEntry(conflict.Existing.ID, conflict.Imported)
+		case KeepBoth:
+			_, err = db.AddEntry(conflict.Imported)
+		}
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	return count, nil
+}
+
+// prefers an existing entry with the same password so a duplicate is never
+// reported as a conflict with another entry of the same account
+func findAccount(db *database.Database, existing []database.Entry, imported database.Entry) (database.Entry, bool, error) {
+	var match database.Entry
+	found := false
+	for _, candidate := range existing {
+		if !sameAccount(candidate, imported) {
+			continue
+		}
+
+		entry, err := db.GetEntry(candidate.ID)
+		if err != nil {
+			return match, false, err
+		}
+		if entry.Password == imported.Password {
+			return entry, true, nil
+		}
+		if !found {
+			match = entry
+			found = true
+		}
+	}
+	return match, found, nil
+}
+
+func sameAccount(a database.Entry, b database.Entry) bool {
+	if !strings.EqualFold(a.Username, b.Username) {
+		return false
+	}
+	if strings.EqualFold(a.Title, b.Title) {
+		return true
+	}
+	site := siteName(a)
+	return len(site) != 0 && site == siteName(b)
+}
@@ -0,0 +1,196 @@
+package kdbx
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"math"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20"
+)
+
+// the outer cipher, named by a uuid in the header
+type Cipher struct {
+	uuid [16]byte
+	name string
+}
+
+var (
+	// aes-256 in cbc mode, the keepass default
+	AES256   = Cipher{uuid: [16]byte{0x31, 0xc1, 0xf2, 0xe6, 0xbf, 0x71, 0x43, 0x50, 0xbe, 0x58, 0x05, 0x21, 0x6a, 0xfc, 0x5a, 0xff}, name: "aes-256"}
+	ChaCha20 = Cipher{uuid: [16]byte{0xd6, 0x03, 0x8a, 0x2b, 0x8b, 0x6f, 0x4c, 0xb5, 0xa5, 0x24, 0x33, 0x9a, 0x31, 0xdb, 0xb5, 0x9a}, name: "chacha20"}
+)
+
+var Ciphers = []Cipher{AES256, ChaCha20}
+
+func (c Cipher) Name() string {
+	return c.name
+}
+
+func cipherByUUID(uuid []byte) (Cipher, error) {
+	for _, c := range Ciphers {
+		if bytes.Equal(c.uuid[:], uuid) {
+			return c, nil
+		}
+	}
+	return Cipher{}, errors.New("unsupported kdbx cipher, only aes-256 and chacha20 are supported")
+}
+
+func (c Cipher) ivLength() int {
+	switch c {
+	case AES256:
+		return aes.BlockSize
+	case ChaCha20:
+		return chacha20.NonceSize
+	}
+	return 0
+}
+
+// aes is padded with pkcs #7, chacha20 starts its counter at 0
+func (c Cipher) encrypt(key []byte, iv []byte, plaintext []byte) ([]byte, error) {
+	if c == ChaCha20 {
+		return xorChaCha20(key, iv, plaintext)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	padding := aes.BlockSize - len(plaintext)%aes.BlockSize
+	ciphertext := append(bytes.Clone(plaintext), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, ciphertext)
+	return ciphertext, nil
+}
+
+func (c Cipher) decrypt(key []byte, iv []byte, ciphertext []byte) ([]byte, error) {
+	if c == ChaCha20 {
+		return xorChaCha20(key, iv, ciphertext)
+	}
+
+	if len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+		return nil, ErrCorrupt
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+
+	// the blocks were authenticated by their hmacs, bad padding is corruption rather than an oracle
+	padding := int(plaintext[len(plaintext)-1])
+	if padding < 1 || padding > aes.BlockSize || !bytes.Equal(plaintext[len(plaintext)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, ErrCorrupt
+	}
+	return plaintext[:len(plaintext)-padding], nil
+}
+
+func xorChaCha20(key []byte, nonce []byte, data []byte) ([]byte, error) {
+	stream, err := chacha20.NewUnauthenticatedCipher(key, nonce)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(data))
+	stream.XORKeyStream(out, data)
+	return out, nil
+}
+
+type keys struct {
+	cipherKey []byte
+	// 64 bytes, each block's hmac key is derived from it and the block's index
+	hmacKey []byte
+}
+
+func deriveKeys(composite []byte, h header) keys {
+	transformed := argon2.IDKey(composite, h.kdfSalt, h.kdf.Time, h.kdf.Memory, h.kdf.Threads, h.kdf.KeyLength)
+
+	cipherKey := sha256.Sum256(append(bytes.Clone(h.masterSeed), transformed...))
+
+	hmacKey := sha512.New()
+	hmacKey.Write(h.masterSeed)
+	hmacKey.Write(transformed)
+	hmacKey.Write([]byte{1})
+
+	return keys{cipherKey: cipherKey[:], hmacKey: hmacKey.Sum(nil)}
+}
+
+func (k keys) blockMAC(index uint64, data []byte) []byte {
+	blockKey := sha512.Sum512(append(binary.LittleEndian.AppendUint64(nil, index), k.hmacKey...))
+	mac := hmac.New(sha256.New, blockKey[:])
+	binary.Write(mac, binary.LittleEndian, index)
+	binary.Write(mac, binary.LittleEndian, uint32(len(data)))
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// the header is authenticated as the block with the last index
+func (k keys) headerMAC(headerBytes []byte) []byte {
+	blockKey := sha512.Sum512(append(binary.LittleEndian.AppendUint64(nil, math.MaxUint64), k.hmacKey...))
+	mac := hmac.New(sha256.New, blockKey[:])
+	mac.Write(headerBytes)
+	return mac.Sum(nil)
+}
+
+// blocks are hmac || length || data, the last block is empty so a file cut
+// at a block boundary is still caught
+const blockSize = 1 << 20
+
+func readBlocks(data []byte, k keys) ([]byte, error) {
+	var out bytes.Buffer
+	for index := uint64(0); ; index++ {
+		if len(data) < sha256.Size+4 {
+			return nil, ErrCorrupt
+		}
+		mac := data[:sha256.Size]
+		length := binary.LittleEndian.Uint32(data[sha256.Size:])
+		data = data[sha256.Size+4:]
+		if length > maxBlock || uint64(length) > uint64(len(data)) {
+			return nil, ErrCorrupt
+		}
+		block := data[:length]
+		data = data[length:]
+
+		if !hmac.Equal(mac, k.blockMAC(index, block)) {
+			return nil, ErrCorrupt
+		}
+		if length == 0 {
+			return out.Bytes(), nil
+		}
+		out.Write(block)
+	}
+}
+
+func writeBlocks(out *bytes.Buffer, data []byte, k keys) {
+	for index := uint64(0); ; index++ {
+		block := data[:min(len(data), blockSize)]
+		data = data[len(block):]
+
+		out.Write(k.blockMAC(index, block))
+		binary.Write(out, binary.LittleEndian, uint32(len(block)))
+		out.Write(block)
+
+		if len(block) == 0 {
+			return
+		}
+	}
+}
+
+// protected xml values are xored with this stream in document order
+const (
+	chaCha20StreamID     = 3
+	innerStreamKeyLength = 64
+)
+
+func newInnerStream(id uint32, key []byte) (cipher.Stream, error) {
+	if id != chaCha20StreamID {
+		return nil, errors.New("unsupported kdbx inner random stream, only chacha20 is supported")
+	}
+	hash := sha512.Sum512(key)
+	return chacha20.NewUnauthenticatedCipher(hash[:32], hash[32:32+chacha20.NonceSize])
+}
@@ -0,0 +1,310 @@
+package kdbx
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"pwm/database"
+	"pwm/otp"
+)
+
+// the standard strings of a keepass entry, any other string is a custom field
+const (
+	keyTitle    = "Title"
+	keyUserName = "UserName"
+	keyPassword = "Password"
+	keyURL      = "URL"
+	keyNotes    = "Notes"
+	// keepassxc stores its otp uri under this name
+	keyOTP = "otp"
+	// urls after the first, keepassxc reads these as keepass2android writes them
+	keyExtraURL = "KP2A_URL"
+)
+
+// keepass keeps this many old versions of an entry unless the file says otherwise
+const historyLimit = 10
+
+// the entries outside the recycle bin with their passwords, in the order of the file
+// the names of the groups an entry is in are added to its tags and the ID is the
+// entry's keepass uuid
+func (f *File) Entries() []database.Entry {
+	entries := make([]database.Entry, 0)
+	f.walk(func(g *group, path []string) {
+		for _, e := range g.Entries {
+			entries = append(entries, toDatabaseEntry(e, path))
+		}
+	})
+	return entries
+}
+
+// the entries of an unencrypted keepass 2 xml export, read the same way as Entries
+// reads those of a kdbx file
+func ParseXML(r io.Reader) ([]database.Entry, error) {
+	var doc document
+	err := xml.NewDecoder(r).Decode(&doc)
+	if err != nil {
+		return nil, err
+	}
+
+	f := File{document: doc}
+	return f.Entries(), nil
+}
+
+// makes the file hold entries, an entry whose ID is the uuid of one in the file
+// replaces it, keeping its group, attachments and the rest of what pwm doesn't
+// know of, and moving the old version into its history if anything changed
+// other entries are added to the top group and entries of the file missing from
+// entries are deleted, except those in the recycle bin
+// returns the uuid each entry has in the file
+func (f *File) Update(entries []database.Entry) []string {
+	now := time.Now()
+	index := make(map[string]int)
+	for i, e := range entries {
+		index[e.ID] = i
+	}
+
+	ids := make([]string, len(entries))
+	f.walk(func(g *group, path []string) {
+		kept := make([]entry, 0, len(g.Entries))
+		for _, e := range g.Entries {
+			id := uuidString(e.UUID)
+			i, ok := index[id]
+			if !ok || len(id) == 0 || len(ids[i]) != 0 {
+				f.document.Root.DeletedObjects.Objects = append(f.document.Root.DeletedObjects.Objects, deletedObject{UUID: e.UUID, DeletionTime: formatTime(now)})
+				continue
+			}
+
+			f.updateEntry(&e, entries[i], path, now)
+			ids[i] = id
+			kept = append(kept, e)
+		}
+		g.Entries = kept
+	})
+
+	top := &f.document.Root.Groups[0]
+	for i, e := range entries {
+		if len(ids[i]) != 0 {
+			continue
+		}
+		created := f.newEntry(e, now)
+		top.Entries = append(top.Entries, created)
+		ids[i] = uuidString(created.UUID)
+	}
+
+	return ids
+}
+
+// calls fn with every group outside the recycle bin and the names of the groups
+// below the top one leading to it
+func (f *File) walk(fn func(g *group, path []string)) {
+	recycleBin := f.document.Meta.RecycleBinUUID
+	var walk func(g *group, path []string)
+	walk = func(g *group, path []string) {
+		if len(recycleBin) != 0 && g.UUID == recycleBin {
+			return
+		}
+		fn(g, path)
+		for i := range g.Groups {
+			walk(&g.Groups[i], append(path[:len(path):len(path)], g.Groups[i].Name))
+		}
+	}
+
+	// the top group is the database itself, its name isn't a useful tag
+	for i := range f.document.Root.Groups {
+		walk(&f.document.Root.Groups[i], nil)
+	}
+}
+
+func toDatabaseEntry(e entry, path []string) database.Entry {
+	result := database.Entry{
+		ID:       uuidString(e.UUID),
+		Fields:   make(map[string]string),
+		Created:  parseTime(e.Times.CreationTime),
+		Modified: parseTime(e.Times.LastModificationTime),
+	}
+
+	extraURLs := make([]string, 0)
+	for _, s := range e.Strings {
+		switch {
+		case s.Key == keyTitle:
+			result.Title = s.Value.Text
+		case s.Key == keyUserName:
+			result.Username = s.Value.Text
+		case s.Key == keyPassword:
+			result.Password = s.Value.Text
+		case s.Key == keyURL:
+			if len(s.Value.Text) != 0 {
+				result.URLs = append([]string{s.Value.Text}, result.URLs...)
+			}
+		case s.Key == keyNotes:
+			result.Notes = s.Value.Text
+		case s.Key == keyOTP:
+			// keeotp and others store "key=...&step=30" rather than a uri, the
+			// database only takes uris so those stay a custom field of the same name
+			if _, err := otp.ParseURI(s.Value.Text); err != nil && len(s.Value.Text) != 0 {
+				result.Fields[keyOTP] = s.Value.Text
+			} else {
+				result.OTP = s.Value.Text
+			}
+		case strings.HasPrefix(s.Key, keyExtraURL):
+			if len(s.Value.Text) != 0 {
+				extraURLs = append(extraURLs, s.Value.Text)
+			}
+		default:
+			// kept when empty too, or saving the entry back would delete the string
+			result.Fields[s.Key] = s.Value.Text
+		}
+	}
+	result.URLs = append(result.URLs, extraURLs...)
+
+	result.Tags = strings.FieldsFunc(e.Tags, func(r rune) bool {
+		return r == ',' || r == ';'
+	})
+	for i := range result.Tags {
+		result.Tags[i] = strings.TrimSpace(result.Tags[i])
+	}
+	for _, name := range path {
+		if !slices.Contains(result.Tags, name) {
+			result.Tags = append(result.Tags, name)
+		}
+	}
+
+	return result
+}
+
+func (f *File) updateEntry(e *entry, updated database.Entry, path []string, now time.Time) {
+	if sameEntry(toDatabaseEntry(*e, path), updated) {
+		return
+	}
+
+	old := *e
+	old.History = nil
+	if e.History == nil {
+		e.History = &history{}
+	}
+	// oldest first, as keepass keeps them
+	e.History.Entries = append(e.History.Entries, old)
+	if len(e.History.Entries) > historyLimit {
+		e.History.Entries = e.History.Entries[len(e.History.Entries)-historyLimit:]
+	}
+
+	// the group path is implied by where the entry is
+	tags := make([]string, 0, len(updated.Tags))
+	for _, tag := range updated.Tags {
+		if !slices.Contains(path, tag) {
+			tags = append(tags, tag)
+		}
+	}
+
+	e.Tags = strings.Join(tags, ";")
+	e.Strings = f.entryStrings(updated, e.Strings)
+	e.Times.LastModificationTime = formatTime(now)
+}
+
+func (f *File) newEntry(e database.Entry, now time.Time) entry {
+	created, modified := e.Created, e.Modified
+	if created.IsZero() {
+		created = now
+	}
+	if modified.IsZero() {
+		modified = now
+	}
+
+	return entry{
+		UUID: newUUID(),
+		Tags: strings.Join(e.Tags, ";"),
+		Times: times{
+			CreationTime:         formatTime(created),
+			LastModificationTime: formatTime(modified),
+			LastAccessTime:       formatTime(now),
+			ExpiryTime:           formatTime(now),
+			Expires:              xmlFalse,
+			UsageCount:           "0",
+			LocationChanged:      formatTime(now),
+		},
+		Strings: f.entryStrings(e, nil),
+	}
+}
+
+// the standard strings then the custom fields by name, strings that were
+// protected stay protected
+func (f *File) entryStrings(e database.Entry, old []stringField) []stringField {
+	protected := make(map[string]bool)
+	for _, s := range old {
+		protected[s.Key] = s.Value.Protected == xmlTrue
+	}
+	protected[keyPassword] = true
+	protected[keyOTP] = true
+	if mp := f.document.Meta.MemoryProtection; mp != nil {
+		protected[keyTitle] = protected[keyTitle] || mp.ProtectTitle == xmlTrue
+		protected[keyUserName] = protected[keyUserName] || mp.ProtectUserName == xmlTrue
+		protected[keyURL] = protected[keyURL] || mp.ProtectURL == xmlTrue
+		protected[keyNotes] = protected[keyNotes] || mp.ProtectNotes == xmlTrue
+	}
+
+	fields := make([]stringField, 0)
+	seen := make(map[string]bool)
+	// a custom field can't take the name of a standard string
+	add := func(key string, text string) {
+		if seen[key] {
+			return
+		}
+		seen[key] = true
+		v := value{Text: text}
+		if protected[key] {
+			v.Protected = xmlTrue
+		}
+		fields = append(fields, stringField{Key: key, Value: v})
+	}
+
+	url := ""
+	if len(e.URLs) != 0 {
+		url = e.URLs[0]
+	}
+
+	add(keyTitle, e.Title)
+	add(keyUserName, e.Username)
+	add(keyPassword, e.Password)
+	add(keyURL, url)
+	add(keyNotes, e.Notes)
+	if len(e.OTP) != 0 {
+		add(keyOTP, e.OTP)
+	}
+	for i := 1; i < len(e.URLs); i++ {
+		add(fmt.Sprintf("%s_%d", keyExtraURL, i), e.URLs[i])
+	}
+
+	names := make([]string, 0, len(e.Fields))
+	for name := range e.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		add(name, e.Fields[name])
+	}
+
+	return fields
+}
+
+// compares everything the file stores, ids and times aside
+func sameEntry(a database.Entry, b database.Entry) bool {
+	if a.Title != b.Title || a.Username != b.Username || a.Password != b.Password ||
+		a.Notes != b.Notes || a.OTP != b.OTP || !slices.Equal(a.URLs, b.URLs) || len(a.Fields) != len(b.Fields) {
+		return false
+	}
+	for name, field := range a.Fields {
+		if other, ok := b.Fields[name]; !ok || other != field {
+			return false
+		}
+	}
+
+	tagsA, tagsB := slices.Clone(a.Tags), slices.Clone(b.Tags)
+	sort.Strings(tagsA)
+	sort.Strings(tagsB)
+	return slices.Equal(tagsA, tagsB)
+}
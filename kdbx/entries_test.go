@@ -0,0 +1,263 @@
+package kdbx_test
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"pwm/database"
+	"pwm/importer"
+	"pwm/kdbx"
+)
+
+// as keepassxc writes it, less what pwm has no use for
+const testXML = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<KeePassFile>
+	<Meta>
+		<Generator>KeePassXC</Generator>
+		<DatabaseName>Shared</DatabaseName>
+		<RecycleBinEnabled>True</RecycleBinEnabled>
+		<RecycleBinUUID>AAAAAAAAAAAAAAAAAAAAAw==</RecycleBinUUID>
+	</Meta>
+	<Root>
+		<Group>
+			<UUID>AAAAAAAAAAAAAAAAAAAAAA==</UUID>
+			<Name>Shared</Name>
+			<IsExpanded>True</IsExpanded>
+			<Entry>
+				<UUID>AAAAAAAAAAAAAAAAAAAAEA==</UUID>
+				<IconID>0</IconID>
+				<Tags>old</Tags>
+				<Times>
+					<CreationTime>wFii2w4AAAA=</CreationTime>
+					<LastModificationTime>wFii2w4AAAA=</LastModificationTime>
+				</Times>
+				<String><Key>Title</Key><Value>Router</Value></String>
+				<String><Key>UserName</Key><Value>admin</Value></String>
+				<String><Key>Password</Key><Value Protected="True">hunter2</Value></String>
+			</Entry>
+			<Group>
+				<UUID>AAAAAAAAAAAAAAAAAAAAAQ==</UUID>
+				<Name>Work</Name>
+				<Entry>
+					<UUID>AAAAAAAAAAAAAAAAAAAAEQ==</UUID>
+					<Binary><Key>key.pem</Key><Value Ref="0"/></Binary>
+					<Tags>vpn, it</Tags>
+					<Times>
+						<CreationTime>wFii2w4AAAA=</CreationTime>
+						<LastModificationTime>wFii2w4AAAA=</LastModificationTime>
+					</Times>
+					<String><Key>Title</Key><Value>VPN</Value></String>
+					<String><Key>UserName</Key><Value>alice</Value></String>
+					<String><Key>Password</Key><Value Protected="True">old password</Value></String>
+					<String><Key>URL</Key><Value>https://vpn.example.com</Value></String>
+					<String><Key>KP2A_URL_1</Key><Value>https://vpn2.example.com</Value></String>
+					<String><Key>Notes</Key><Value></Value></String>
+					<String><Key>PIN</Key><Value Protected="True">1234</Value></String>
+				</Entry>
+				<Group>
+					<UUID>AAAAAAAAAAAAAAAAAAAAAg==</UUID>
+					<Name>Servers</Name>
+					<Entry>
+						<UUID>AAAAAAAAAAAAAAAAAAAAEg==</UUID>
+						<Times>
+							<CreationTime>wFii2w4AAAA=</CreationTime>
+							<LastModificationTime>wFii2w4AAAA=</LastModificationTime>
+						</Times>
+						<String><Key>Title</Key><Value>db1</Value></String>
+						<String><Key>UserName</Key><Value>root</Value></String>
+						<String><Key>Password</Key><Value Protected="True">toor</Value></String>
+						<String><Key>otp</Key><Value Protected="True">otpauth://totp/db1?secret=JBSWY3DPEHPK3PXP</Value></String>
+					</Entry>
+				</Group>
+			</Group>
+			<Group>
+				<UUID>AAAAAAAAAAAAAAAAAAAAAw==</UUID>
+				<Name>Recycle Bin</Name>
+				<Entry>
+					<UUID>AAAAAAAAAAAAAAAAAAAAEw==</UUID>
+					<String><Key>Title</Key><Value>Deleted</Value></String>
+				</Entry>
+			</Group>
+		</Group>
+		<DeletedObjects/>
+	</Root>
+</KeePassFile>`
+
+func TestEntries(t *testing.T) {
+	f, err := kdbx.FromXML(testXML)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries := f.Entries()
+	if len(entries) != 3 {
+		t.Fatalf("expected the 3 entries outside the recycle bin, got %d", len(entries))
+	}
+
+	router, vpn, db1 := entries[0], entries[1], entries[2]
+	if router.ID != "00000000-0000-0000-0000-000000000010" || router.Password != "hunter2" || !slices.Equal(router.Tags, []string{"old"}) {
+		t.Errorf("unexpected top level entry %+v", router)
+	}
+	if !slices.Equal(vpn.URLs, []string{"https://vpn.example.com", "https://vpn2.example.com"}) {
+		t.Errorf("expected both urls, got %v", vpn.URLs)
+	}
+	if !slices.Equal(vpn.Tags, []string{"vpn", "it", "Work"}) || vpn.Fields["PIN"] != "1234" || len(vpn.Fields) != 1 {
+		t.Errorf("unexpected tags or fields %v %v", vpn.Tags, vpn.Fields)
+	}
+	if !slices.Equal(db1.Tags, []string{"Work", "Servers"}) || db1.OTP != "otpauth://totp/db1?secret=JBSWY3DPEHPK3PXP" {
+		t.Errorf("unexpected nested entry %+v", db1)
+	}
+	if !router.Created.Equal(time.Date(2023, time.March, 14, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected the creation time to be read, got %v", router.Created)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	f, err := kdbx.FromXML(testXML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.KDF = testKDF
+
+	entries := f.Entries()
+	_, vpn, db1 := entries[0], entries[1], entries[2]
+	vpn.Password = "new password"
+	vpn.Tags = []string{"vpn", "Work", "remote"}
+	added := vpn
+	added.ID = "not a uuid in the file"
+	added.Title = "Added"
+
+	// the router is left out and so deleted
+	ids := f.Update([]database.Entry{vpn, db1, added})
+	if ids[0] != vpn.ID || ids[1] != db1.ID || ids[2] == added.ID || len(ids[2]) == 0 {
+		t.Errorf("expected existing entries to keep their uuid and new ones to get one, got %v", ids)
+	}
+
+	content := writeFile(t, f, "password")
+	read, err := kdbx.Read(bytes.NewReader(content), "password", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := read.Entries()
+	if len(got) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(got))
+	}
+	// new entries go in the top group, which comes before its subgroups
+	if got[0].Title != "Added" || got[0].ID != ids[2] || !slices.Equal(got[0].Tags, vpn.Tags) {
+		t.Errorf("unexpected added entry %+v", got[0])
+	}
+	if got[1].Password != "new password" || !slices.Equal(got[1].Tags, []string{"vpn", "remote", "Work"}) {
+		t.Errorf("unexpected updated entry %+v", got[1])
+	}
+	if !got[1].Modified.After(vpn.Modified) || !got[1].Created.Equal(vpn.Created) {
+		t.Error("expected the updated entry to have a new modification time only")
+	}
+	if !got[2].Modified.Equal(db1.Modified) {
+		t.Error("an unchanged entry shouldn't be modified")
+	}
+
+	document := read.XML()
+	for _, kept := range []string{
+		// the attachment reference and the group's own elements
+		`<Binary><Key>key.pem</Key><Value Ref="0"/></Binary>`,
+		`<IsExpanded>True</IsExpanded>`,
+		// the old version of the vpn entry
+		`<History><Entry><UUID>AAAAAAAAAAAAAAAAAAAAEQ==</UUID>`,
+		`<Value Protected="True">old password</Value>`,
+		// the entry in the recycle bin and the deletion of the router
+		`<Value>Deleted</Value>`,
+		`<DeletedObject><UUID>AAAAAAAAAAAAAAAAAAAAEA==</UUID>`,
+		// the custom field stays protected
+		`<Key>PIN</Key><Value Protected="True">1234</Value>`,
+	} {
+		if !strings.Contains(document, kept) {
+			t.Errorf("expected the written file to contain %s", kept)
+		}
+	}
+	if strings.Contains(document, "hunter2") {
+		t.Error("the deleted entry is still in the file")
+	}
+}
+
+// keeotp stores the secret and settings instead of an otpauth uri, the
+// database refuses those so they are read as a custom field
+func TestKeeOTP(t *testing.T) {
+	const keeOTP = "key=JBSWY3DPEHPK3PXP&size=6&step=30"
+	f, err := kdbx.FromXML(strings.Replace(testXML, "otpauth://totp/db1?secret=JBSWY3DPEHPK3PXP", strings.ReplaceAll(keeOTP, "&", "&amp;"), 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.KDF = testKDF
+
+	entries := f.Entries()
+	db1 := entries[2]
+	if len(db1.OTP) != 0 || db1.Fields["otp"] != keeOTP {
+		t.Fatalf("expected the keeotp string as a field, got otp %q and fields %v", db1.OTP, db1.Fields)
+	}
+
+	// --file adds the entries as they are, import normalizes them first
+	db, err := database.New("password", database.DefaultKDF())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, list := range [][]database.Entry{entries, importer.Normalize(entries)} {
+		for _, entry := range list {
+			if _, err := db.AddEntry(entry); err != nil {
+				t.Errorf("entry %s wasn't added, %v", entry.Title, err)
+			}
+		}
+	}
+
+	// saving the entries back leaves the string where it was
+	f.Update(entries)
+	document := f.XML()
+	if strings.Contains(document, "<History>") {
+		t.Error("an unchanged entry was given a history")
+	}
+	if !strings.Contains(document, "<Key>otp</Key><Value Protected=\"True\">"+strings.ReplaceAll(keeOTP, "&", "&amp;")+"</Value>") {
+		t.Error("expected the keeotp string to be kept")
+	}
+}
+
+func TestEmptyField(t *testing.T) {
+	const pin = `<String><Key>PIN</Key><Value Protected="True">1234</Value></String>`
+	f, err := kdbx.FromXML(strings.Replace(testXML, pin, pin+`<String><Key>Recovery</Key><Value></Value></String>`, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := database.New("password", database.DefaultKDF())
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := f.Entries()
+	for i, entry := range entries {
+		id, err := db.AddEntry(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored, err := db.GetEntry(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored.ID = entry.ID
+		entries[i] = stored
+	}
+
+	if value, ok := entries[1].Fields["Recovery"]; !ok || len(value) != 0 {
+		t.Fatalf("expected the empty string as a field, got %v", entries[1].Fields)
+	}
+
+	f.Update(entries)
+	document := f.XML()
+	if !strings.Contains(document, "<Key>Recovery</Key>") {
+		t.Error("expected the empty string to be saved back")
+	}
+	if strings.Contains(document, "<History>") {
+		t.Error("an unchanged entry was given a history")
+	}
+}
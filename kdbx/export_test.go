@@ -0,0 +1,26 @@
+package kdbx
+
+import (
+	"encoding/xml"
+	"io"
+)
+
+// builds a file around a plaintext keepass xml document, for tests of what
+// pwm can't create itself such as groups and recycle bins
+func FromXML(data string) (*File, error) {
+	f := New("")
+	f.document = document{}
+	err := xml.Unmarshal([]byte(data), &f.document)
+	return f, err
+}
+
+// the plaintext xml, to check what pwm can't read back through Entries
+func (f *File) XML() string {
+	data, _ := xml.Marshal(f.document)
+	return string(data)
+}
+
+// writes without checking the kdf, as other programs may make files pwm wouldn't
+func (f *File) WriteUnchecked(w io.Writer, password string, keyFile []byte) error {
+	return f.write(w, password, keyFile)
+}
@@ -0,0 +1,347 @@
+package kdbx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"pwm/salt"
+)
+
+// header fields are an id byte, a little endian uint32 length and the data
+const (
+	fieldEnd         = 0
+	fieldCipherID    = 2
+	fieldCompression = 3
+	fieldMasterSeed  = 4
+	fieldIV          = 7
+	fieldKDF         = 11
+)
+
+// the inner header is laid out the same way inside the encrypted payload
+const (
+	innerFieldEnd       = 0
+	innerFieldStreamID  = 1
+	innerFieldStreamKey = 2
+	innerFieldBinary    = 3
+)
+
+var headerEnd = []byte("\r\n\r\n")
+
+var (
+	kdfArgon2d  = [16]byte{0xef, 0x63, 0x6d, 0xdf, 0x8c, 0x29, 0x44, 0x4b, 0x91, 0xf7, 0xa9, 0xa4, 0x03, 0xe3, 0x0a, 0x0c}
+	kdfArgon2id = [16]byte{0x9e, 0x29, 0x8b, 0x19, 0x56, 0xdb, 0x47, 0x73, 0xb2, 0x3d, 0xfc, 0x3e, 0xc6, 0xf0, 0xa1, 0xe6}
+)
+
+const argon2Version = 0x13
+
+type header struct {
+	minorVersion uint16
+	cipher       Cipher
+	compressed   bool
+	masterSeed   []byte
+	iv           []byte
+	kdf          salt.Argon2Params
+	kdfSalt      []byte
+}
+
+// returns the header and its length, including the signature and version
+func parseHeader(content []byte) (header, int, error) {
+	h := header{minorVersion: binary.LittleEndian.Uint16(content[len(signature):])}
+	seen := make(map[uint8]bool)
+
+	offset := len(signature) + 4
+	for {
+		if len(content)-offset < 5 {
+			return h, 0, ErrCorrupt
+		}
+		id := content[offset]
+		length := binary.LittleEndian.Uint32(content[offset+1:])
+		offset += 5
+		if length > maxHeaderField || uint64(length) > uint64(len(content)-offset) {
+			return h, 0, ErrCorrupt
+		}
+		data := content[offset : offset+int(length)]
+		offset += int(length)
+
+		if seen[id] {
+			return h, 0, errors.New(fmt.Sprintf("kdbx header repeats field %d", id))
+		}
+		seen[id] = true
+
+		switch id {
+		case fieldEnd:
+			for _, required := range []uint8{fieldCipherID, fieldMasterSeed, fieldIV, fieldKDF} {
+				if !seen[required] {
+					return h, 0, errors.New(fmt.Sprintf("kdbx header is missing field %d", required))
+				}
+			}
+			if len(h.iv) != h.cipher.ivLength() {
+				return h, 0, errors.New("kdbx iv doesn't match its cipher")
+			}
+			return h, offset, nil
+		case fieldCipherID:
+			c, err := cipherByUUID(data)
+			if err != nil {
+				return h, 0, err
+			}
+			h.cipher = c
+		case fieldCompression:
+			if len(data) != 4 || binary.LittleEndian.Uint32(data) > 1 {
+				return h, 0, errors.New("unsupported kdbx compression")
+			}
+			h.compressed = binary.LittleEndian.Uint32(data) == 1
+		case fieldMasterSeed:
+			if len(data) != masterSeedLength {
+				return h, 0, ErrCorrupt
+			}
+			h.masterSeed = data
+		case fieldIV:
+			h.iv = data
+		case fieldKDF:
+			err := h.parseKDF(data)
+			if err != nil {
+				return h, 0, err
+			}
+		}
+		// other fields, such as public custom data, aren't needed to read the file
+	}
+}
+
+func (h *header) parseKDF(data []byte) error {
+	params, err := parseVariantDictionary(data)
+	if err != nil {
+		return err
+	}
+
+	uuid, _ := params["$UUID"].([]byte)
+	switch {
+	case bytes.Equal(uuid, kdfArgon2id[:]):
+	case bytes.Equal(uuid, kdfArgon2d[:]):
+		return errors.New(fmt.Sprintf("%s, the file uses argon2d", ErrUnsupportedKDF))
+	default:
+		return ErrUnsupportedKDF
+	}
+
+	kdfSalt, _ := params["S"].([]byte)
+	version, _ := params["V"].(uint64)
+	iterations, _ := params["I"].(uint64)
+	memory, _ := params["M"].(uint64)
+	parallelism, _ := params["P"].(uint64)
+	if len(kdfSalt) == 0 || version != argon2Version || iterations < 1 || iterations > maxKDFTime ||
+		memory < 8<<10 || memory>>10 > maxKDFMemory || parallelism < 1 || parallelism > 255 {
+		return errors.New("invalid argon2 parameters in kdbx header")
+	}
+	if _, secret := params["K"]; secret {
+		return errors.New("argon2 secret keys in kdbx headers aren't supported")
+	}
+	if _, associated := params["A"]; associated {
+		return errors.New("argon2 associated data in kdbx headers isn't supported")
+	}
+
+	// files from other programs may use weaker parameters than pwm writes, they are read as they are
+	h.kdfSalt = kdfSalt
+	h.kdf = salt.Argon2Params{Time: uint32(iterations), Memory: uint32(memory >> 10), Threads: uint8(parallelism), KeyLength: salt.KeyLength}
+	return nil
+}
+
+func (h *header) encode() []byte {
+	var buffer bytes.Buffer
+	buffer.Write(signature[:])
+	binary.Write(&buffer, binary.LittleEndian, h.minorVersion)
+	binary.Write(&buffer, binary.LittleEndian, uint16(majorVersion))
+
+	compression := uint32(0)
+	if h.compressed {
+		compression = 1
+	}
+
+	kdf := variantDictionary{
+		{"$UUID", kdfArgon2id[:]},
+		{"S", h.kdfSalt},
+		{"P", uint32(h.kdf.Threads)},
+		{"M", uint64(h.kdf.Memory) << 10},
+		{"I", uint64(h.kdf.Time)},
+		{"V", uint32(argon2Version)},
+	}
+
+	writeField(&buffer, fieldCipherID, h.cipher.uuid[:])
+	writeField(&buffer, fieldCompression, binary.LittleEndian.AppendUint32(nil, compression))
+	writeField(&buffer, fieldMasterSeed, h.masterSeed)
+	writeField(&buffer, fieldIV, h.iv)
+	writeField(&buffer, fieldKDF, kdf.encode())
+	writeField(&buffer, fieldEnd, headerEnd)
+
+	return buffer.Bytes()
+}
+
+func writeField(buffer *bytes.Buffer, id uint8, data []byte) {
+	buffer.WriteByte(id)
+	binary.Write(buffer, binary.LittleEndian, uint32(len(data)))
+	buffer.Write(data)
+}
+
+type innerHeader struct {
+	streamID  uint32
+	streamKey []byte
+	// each is a flags byte followed by the attachment
+	binaries [][]byte
+}
+
+// returns the inner header and the xml that follows it
+func parseInnerHeader(payload []byte) (innerHeader, []byte, error) {
+	var inner innerHeader
+	for {
+		if len(payload) < 5 {
+			return inner, nil, ErrCorrupt
+		}
+		id := payload[0]
+		length := binary.LittleEndian.Uint32(payload[1:])
+		payload = payload[5:]
+		if uint64(length) > uint64(len(payload)) {
+			return inner, nil, ErrCorrupt
+		}
+		data := payload[:length]
+		payload = payload[length:]
+
+		switch id {
+		case innerFieldEnd:
+			if inner.streamKey == nil {
+				return inner, nil, errors.New("kdbx inner header has no random stream key")
+			}
+			return inner, payload, nil
+		case innerFieldStreamID:
+			if len(data) != 4 {
+				return inner, nil, ErrCorrupt
+			}
+			inner.streamID = binary.LittleEndian.Uint32(data)
+		case innerFieldStreamKey:
+			inner.streamKey = data
+		case innerFieldBinary:
+			if len(data) < 1 {
+				return inner, nil, ErrCorrupt
+			}
+			inner.binaries = append(inner.binaries, data)
+		}
+	}
+}
+
+func (inner *innerHeader) encode() []byte {
+	var buffer bytes.Buffer
+	writeField(&buffer, innerFieldStreamID, binary.LittleEndian.AppendUint32(nil, inner.streamID))
+	writeField(&buffer, innerFieldStreamKey, inner.streamKey)
+	for _, binary := range inner.binaries {
+		writeField(&buffer, innerFieldBinary, binary)
+	}
+	writeField(&buffer, innerFieldEnd, nil)
+	return buffer.Bytes()
+}
+
+// the typed key value list keepass stores kdf parameters in
+// ============= // ================================================= // ======= //
+//   version     //  (type || key length || key || value length || value)*  //   end   //
+
+const (
+	variantVersion = 0x0100
+	// a change of the high byte is incompatible
+	variantVersionMask = 0xff00
+
+	variantEnd       = 0x00
+	variantUint32    = 0x04
+	variantUint64    = 0x05
+	variantBool      = 0x08
+	variantInt32     = 0x0c
+	variantInt64     = 0x0d
+	variantString    = 0x18
+	variantByteArray = 0x42
+)
+
+type variant struct {
+	key   string
+	value any
+}
+
+// written in order, keepass doesn't mind the order but a fixed one keeps output stable
+type variantDictionary []variant
+
+func (dict variantDictionary) encode() []byte {
+	var buffer bytes.Buffer
+	binary.Write(&buffer, binary.LittleEndian, uint16(variantVersion))
+
+	for _, item := range dict {
+		var kind uint8
+		var value []byte
+		switch v := item.value.(type) {
+		case uint32:
+			kind, value = variantUint32, binary.LittleEndian.AppendUint32(nil, v)
+		case uint64:
+			kind, value = variantUint64, binary.LittleEndian.AppendUint64(nil, v)
+		case []byte:
+			kind, value = variantByteArray, v
+		}
+		buffer.WriteByte(kind)
+		binary.Write(&buffer, binary.LittleEndian, uint32(len(item.key)))
+		buffer.WriteString(item.key)
+		binary.Write(&buffer, binary.LittleEndian, uint32(len(value)))
+		buffer.Write(value)
+	}
+
+	buffer.WriteByte(variantEnd)
+	return buffer.Bytes()
+}
+
+// unsigned numbers of either width are returned as uint64, signed ones as int64
+func parseVariantDictionary(data []byte) (map[string]any, error) {
+	if len(data) < 2 || binary.LittleEndian.Uint16(data)&variantVersionMask != variantVersion&variantVersionMask {
+		return nil, errors.New("unsupported kdbx kdf parameters version")
+	}
+	data = data[2:]
+
+	dict := make(map[string]any)
+	for {
+		if len(data) < 1 {
+			return nil, ErrCorrupt
+		}
+		kind := data[0]
+		if kind == variantEnd {
+			return dict, nil
+		}
+
+		if len(data) < 5 {
+			return nil, ErrCorrupt
+		}
+		keyLength := binary.LittleEndian.Uint32(data[1:])
+		data = data[5:]
+		if uint64(keyLength)+4 > uint64(len(data)) {
+			return nil, ErrCorrupt
+		}
+		key := string(data[:keyLength])
+		valueLength := binary.LittleEndian.Uint32(data[keyLength:])
+		data = data[keyLength+4:]
+		if uint64(valueLength) > uint64(len(data)) {
+			return nil, ErrCorrupt
+		}
+		value := data[:valueLength]
+		data = data[valueLength:]
+
+		switch {
+		case kind == variantUint32 && len(value) == 4:
+			dict[key] = uint64(binary.LittleEndian.Uint32(value))
+		case kind == variantUint64 && len(value) == 8:
+			dict[key] = binary.LittleEndian.Uint64(value)
+		case kind == variantInt32 && len(value) == 4:
+			dict[key] = int64(int32(binary.LittleEndian.Uint32(value)))
+		case kind == variantInt64 && len(value) == 8:
+			dict[key] = int64(binary.LittleEndian.Uint64(value))
+		case kind == variantBool && len(value) == 1:
+			dict[key] = value[0] != 0
+		case kind == variantString:
+			dict[key] = string(value)
+		case kind == variantByteArray:
+			dict[key] = value
+		default:
+			return nil, ErrCorrupt
+		}
+	}
+}
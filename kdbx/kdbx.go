@@ -0,0 +1,266 @@
+// reads and writes keepass kdbx 4 databases, so a vault can be shared with
+// keepassxc users or opened by pwm in place of its own format
+package kdbx
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"pwm/keyfile"
+	"pwm/salt"
+)
+
+// a kdbx 4 file is
+// ============= // ========= // ============= // ============= // =================== //
+//   signature   //  header   //  header hash  //  header hmac  //  hmac block stream  //
+// the blocks hold the outer cipher's ciphertext of the optionally gzipped inner
+// header and xml, protected xml values are further xored with the inner random stream
+
+var signature = [8]byte{0x03, 0xd9, 0xa2, 0x9a, 0x67, 0xfb, 0x4b, 0xb5}
+
+const (
+	majorVersion = 4
+	// 4.0, written unless a file being saved was already 4.1
+	minorVersion = 0
+
+	masterSeedLength = 32
+	kdfSaltLength    = 32
+
+	// limits on files being read, so a crafted file can't ask for unbounded memory
+	maxHeaderField = 1 << 20
+	maxBlock       = 1 << 30
+	maxPayload     = 1 << 28
+	maxKDFTime     = 1 << 16
+	maxKDFMemory   = 1 << 22 // KiB
+)
+
+var (
+	ErrNotKDBX = errors.New("not a kdbx file")
+	// the header fails its hmac, either the password or key file is wrong or the header was changed
+	ErrWrongPassword = errors.New("wrong password, wrong key file or corrupted kdbx file")
+	ErrCorrupt       = errors.New("kdbx file is corrupted")
+	// keepass can convert a file to what pwm reads in its database settings
+	ErrUnsupportedVersion = errors.New("only kdbx 4 files are supported, save the file as kdbx 4 in keepass")
+	ErrUnsupportedKDF     = errors.New("only the argon2id kdf is supported, switch the kdf to argon2id in keepass")
+)
+
+// a keepass database, the entries are reached through Entries and Update and
+// everything pwm has no use for, such as attachments, icons and groups, is kept
+// as it was read so saving a file doesn't lose it
+type File struct {
+	Cipher Cipher
+	// the salt is made anew every time the file is written
+	KDF salt.Argon2Params
+	// gzip the xml before encrypting it, keepass does by default
+	Compress bool
+
+	minorVersion uint16
+	// the parameters the file was read with, which are written back even if weaker than salt allows
+	readKDF  salt.Argon2Params
+	document document
+	// the attachments from the inner header, entries refer to them by index
+	binaries [][]byte
+}
+
+// an empty database with the defaults of keepassxc, which calibrates its
+// kdf when a database is created, these take about a second on a laptop
+func New(name string) *File {
+	return &File{
+		Cipher:       AES256,
+		KDF:          salt.Argon2Params{Time: 10, Memory: 1 << 16, Threads: 2, KeyLength: salt.KeyLength},
+		Compress:     true,
+		minorVersion: minorVersion,
+		document:     newDocument(name),
+	}
+}
+
+// reports whether content, or at least its first 12 bytes, is a kdbx file that Read understands
+func IsKDBX(content []byte) bool {
+	return len(content) >= len(signature)+4 && bytes.Equal(content[:len(signature)], signature[:]) &&
+		binary.LittleEndian.Uint16(content[len(signature)+2:]) == majorVersion
+}
+
+// keyFile is the key from keyfile.Load, or nil when the file is locked by its password alone
+func Read(r io.Reader, password string, keyFile []byte) (*File, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(content) < len(signature)+4 || !bytes.Equal(content[:len(signature)], signature[:]) {
+		return nil, ErrNotKDBX
+	}
+	if binary.LittleEndian.Uint16(content[len(signature)+2:]) != majorVersion {
+		return nil, ErrUnsupportedVersion
+	}
+
+	h, headerLength, err := parseHeader(content)
+	if err != nil {
+		return nil, err
+	}
+	headerBytes := content[:headerLength]
+	rest := content[headerLength:]
+
+	if len(rest) < 2*sha256.Size {
+		return nil, ErrCorrupt
+	}
+	headerHash := sha256.Sum256(headerBytes)
+	if !hmac.Equal(headerHash[:], rest[:sha256.Size]) {
+		return nil, ErrCorrupt
+	}
+
+	keys := deriveKeys(keyfile.Composite(password, keyFile), h)
+	if !hmac.Equal(keys.headerMAC(headerBytes), rest[sha256.Size:2*sha256.Size]) {
+		return nil, ErrWrongPassword
+	}
+
+	ciphertext, err := readBlocks(rest[2*sha256.Size:], keys)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := h.cipher.decrypt(keys.cipherKey, h.iv, ciphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	if h.compressed {
+		payload, err = gunzip(payload)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	inner, xmlData, err := parseInnerHeader(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := newInnerStream(inner.streamID, inner.streamKey)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := decodeDocument(xmlData, stream)
+	if err != nil {
+		return nil, err
+	}
+
+	return &File{
+		Cipher:       h.cipher,
+		KDF:          h.kdf,
+		readKDF:      h.kdf,
+		Compress:     h.compressed,
+		minorVersion: h.minorVersion,
+		document:     doc,
+		binaries:     inner.binaries,
+	}, nil
+}
+
+// the master seed, kdf salt, iv and inner stream key are new on every write
+// parameters other than those the file was read with must meet salt's minimums
+func (f *File) Write(w io.Writer, password string, keyFile []byte) error {
+	if f.KDF != f.readKDF {
+		err := f.KDF.Validate()
+		if err != nil {
+			return err
+		}
+	}
+	if f.KDF.KeyLength != salt.KeyLength {
+		return errors.New(fmt.Sprintf("kdbx keys are %d bytes", salt.KeyLength))
+	}
+	return f.write(w, password, keyFile)
+}
+
+func (f *File) write(w io.Writer, password string, keyFile []byte) error {
+	h := header{
+		minorVersion: f.minorVersion,
+		cipher:       f.Cipher,
+		compressed:   f.Compress,
+		kdf:          f.KDF,
+		masterSeed:   make([]byte, masterSeedLength),
+		kdfSalt:      make([]byte, kdfSaltLength),
+		iv:           make([]byte, f.Cipher.ivLength()),
+	}
+	inner := innerHeader{
+		streamID:  chaCha20StreamID,
+		streamKey: make([]byte, innerStreamKeyLength),
+		binaries:  f.binaries,
+	}
+	for _, random := range [][]byte{h.masterSeed, h.kdfSalt, h.iv, inner.streamKey} {
+		_, err := rand.Read(random)
+		if err != nil {
+			return err
+		}
+	}
+
+	stream, err := newInnerStream(inner.streamID, inner.streamKey)
+	if err != nil {
+		return err
+	}
+	xmlData, err := encodeDocument(f.document, stream)
+	if err != nil {
+		return err
+	}
+
+	var payload bytes.Buffer
+	if f.Compress {
+		zw := gzip.NewWriter(&payload)
+		_, err = zw.Write(inner.encode())
+		if err == nil {
+			_, err = zw.Write(xmlData)
+		}
+		if err == nil {
+			err = zw.Close()
+		}
+		if err != nil {
+			return err
+		}
+	} else {
+		payload.Write(inner.encode())
+		payload.Write(xmlData)
+	}
+
+	keys := deriveKeys(keyfile.Composite(password, keyFile), h)
+	ciphertext, err := f.Cipher.encrypt(keys.cipherKey, h.iv, payload.Bytes())
+	if err != nil {
+		return err
+	}
+
+	headerBytes := h.encode()
+	headerHash := sha256.Sum256(headerBytes)
+
+	var out bytes.Buffer
+	out.Write(headerBytes)
+	out.Write(headerHash[:])
+	out.Write(keys.headerMAC(headerBytes))
+	writeBlocks(&out, ciphertext, keys)
+
+	_, err = w.Write(out.Bytes())
+	return err
+}
+
+func gunzip(data []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrCorrupt
+	}
+	defer zr.Close()
+
+	// one more than the limit to tell a payload at the limit from one over it
+	payload, err := io.ReadAll(io.LimitReader(zr, maxPayload+1))
+	if err != nil {
+		return nil, ErrCorrupt
+	}
+	if len(payload) > maxPayload {
+		return nil, errors.New("kdbx payload is too large")
+	}
+	return payload, nil
+}
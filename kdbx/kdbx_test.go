@@ -0,0 +1,282 @@
+package kdbx_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"pwm/database"
+	"pwm/kdbx"
+	"pwm/keyfile"
+	"pwm/salt"
+)
+
+// the weakest parameters Write accepts, so the tests stay fast
+var testKDF = salt.Argon2Params{Time: 1, Memory: salt.MinArgon2Memory, Threads: 1, KeyLength: salt.KeyLength}
+
+func writeFile(t *testing.T, f *kdbx.File, password string) []byte {
+	t.Helper()
+	var out bytes.Buffer
+	err := f.Write(&out, password, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	entries := []database.Entry{
+		{Title: "Mail", Username: "alice", Password: "p<a&s>s\"w'ord", URLs: []string{"https://mail.example.com", "https://example.com/login"}, Notes: "line one\nline two", Tags: []string{"work", "email"}, Fields: map[string]string{"pin": "1234"}},
+		{Title: "Bank", Username: "bob", Password: "", OTP: "otpauth://totp/Bank:bob?secret=JBSWY3DPEHPK3PXP"},
+		{Title: "ünïcödé", Username: "carol", Password: "パスワード"},
+	}
+
+	for _, c := range kdbx.Ciphers {
+		for _, compress := range []bool{true, false} {
+			f := kdbx.New("Test")
+			f.KDF = testKDF
+			f.Cipher = c
+			f.Compress = compress
+			ids := f.Update(entries)
+
+			content := writeFile(t, f, "master password")
+			if !kdbx.IsKDBX(content) {
+				t.Fatal("written file isn't recognised as kdbx")
+			}
+
+			read, err := kdbx.Read(bytes.NewReader(content), "master password", nil)
+			if err != nil {
+				t.Fatalf("%s, compressed %v: %v", c.Name(), compress, err)
+			}
+			if read.Cipher != c || read.Compress != compress || read.KDF != testKDF {
+				t.Errorf("%s: settings weren't kept, got %s %v %+v", c.Name(), read.Cipher.Name(), read.Compress, read.KDF)
+			}
+
+			got := read.Entries()
+			if len(got) != len(entries) {
+				t.Fatalf("expected %d entries, got %d", len(entries), len(got))
+			}
+			for i, entry := range entries {
+				g := got[i]
+				if g.ID != ids[i] || g.Title != entry.Title || g.Username != entry.Username || g.Password != entry.Password ||
+					g.Notes != entry.Notes || g.OTP != entry.OTP || len(g.URLs) != len(entry.URLs) || len(g.Tags) != len(entry.Tags) ||
+					len(g.Fields) != len(entry.Fields) {
+					t.Errorf("entry %d changed, got %+v", i, g)
+				}
+				for j := range entry.URLs {
+					if g.URLs[j] != entry.URLs[j] {
+						t.Errorf("url %d of entry %d changed to %s", j, i, g.URLs[j])
+					}
+				}
+				for name, field := range entry.Fields {
+					if g.Fields[name] != field {
+						t.Errorf("field %s of entry %d changed to %s", name, i, g.Fields[name])
+					}
+				}
+				if g.Created.IsZero() || g.Modified.IsZero() {
+					t.Errorf("entry %d has no times", i)
+				}
+			}
+		}
+	}
+}
+
+func TestWrongPassword(t *testing.T) {
+	f := kdbx.New("Test")
+	f.KDF = testKDF
+	f.Update([]database.Entry{{Title: "Mail", Username: "alice", Password: "secret"}})
+	content := writeFile(t, f, "master password")
+
+	_, err := kdbx.Read(bytes.NewReader(content), "wrong password", nil)
+	if !errors.Is(err, kdbx.ErrWrongPassword) {
+		t.Errorf("expected ErrWrongPassword, got %v", err)
+	}
+
+	// the same password makes a different file every time
+	if bytes.Equal(content, writeFile(t, f, "master password")) {
+		t.Error("expected a new seed, salt and iv on every write")
+	}
+}
+
+func TestCorruption(t *testing.T) {
+	f := kdbx.New("Test")
+	f.KDF = testKDF
+	f.Update([]database.Entry{{Title: "Mail", Username: "alice", Password: "secret"}})
+	content := writeFile(t, f, "master password")
+
+	// the header, its hash and hmac, the first block and the empty last block
+	for _, offset := range []int{20, len(content) / 2, len(content) - 40, len(content) - 1} {
+		changed := bytes.Clone(content)
+		changed[offset] ^= 1
+		_, err := kdbx.Read(bytes.NewReader(changed), "master password", nil)
+		if err == nil {
+			t.Errorf("expected a change at %d to fail", offset)
+		}
+	}
+
+	for _, length := range []int{0, 11, 100, len(content) - 37, len(content) - 1} {
+		_, err := kdbx.Read(bytes.NewReader(content[:length]), "master password", nil)
+		if err == nil {
+			t.Errorf("expected a file cut to %d bytes to fail", length)
+		}
+	}
+
+	_, err := kdbx.Read(bytes.NewReader([]byte("<KeePassFile></KeePassFile>")), "master password", nil)
+	if !errors.Is(err, kdbx.ErrNotKDBX) {
+		t.Errorf("expected ErrNotKDBX, got %v", err)
+	}
+
+	// kdbx 3.1
+	old := bytes.Clone(content)
+	old[10] = 3
+	_, err = kdbx.Read(bytes.NewReader(old), "master password", nil)
+	if !errors.Is(err, kdbx.ErrUnsupportedVersion) {
+		t.Errorf("expected ErrUnsupportedVersion, got %v", err)
+	}
+	if kdbx.IsKDBX(old) {
+		t.Error("kdbx 3 files can't be read")
+	}
+}
+
+func TestWeakKDF(t *testing.T) {
+	f := kdbx.New("Test")
+	f.KDF = salt.Argon2Params{Time: 1, Memory: 64, Threads: 1, KeyLength: salt.KeyLength}
+	err := f.Write(&bytes.Buffer{}, "master password", nil)
+	if err == nil {
+		t.Error("expected kdf parameters below the minimum to be refused")
+	}
+
+	// but a file read with them is written back as it was
+	var weak bytes.Buffer
+	if err := f.WriteUnchecked(&weak, "master password", nil); err != nil {
+		t.Fatal(err)
+	}
+	read, err := kdbx.Read(&weak, "master password", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := writeFile(t, read, "master password")
+	if _, err := kdbx.Read(bytes.NewReader(content), "master password", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	read.KDF.Time = 2
+	if err := read.Write(&bytes.Buffer{}, "master password", nil); err == nil {
+		t.Error("expected changed kdf parameters below the minimum to be refused")
+	}
+}
+
+func TestKeyFile(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, keyfile.KeyLength)
+	f := kdbx.New("Test")
+	f.KDF = testKDF
+	f.Update([]database.Entry{{Title: "Mail", Username: "alice", Password: "secret"}})
+
+	var out bytes.Buffer
+	err := f.Write(&out, "master password", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := out.Bytes()
+
+	// the password alone isn't enough
+	_, err = kdbx.Read(bytes.NewReader(content), "master password", nil)
+	if !errors.Is(err, kdbx.ErrWrongPassword) {
+		t.Errorf("expected ErrWrongPassword without the key file, got %v", err)
+	}
+	_, err = kdbx.Read(bytes.NewReader(content), "wrong password", key)
+	if !errors.Is(err, kdbx.ErrWrongPassword) {
+		t.Errorf("expected ErrWrongPassword with the key file alone, got %v", err)
+	}
+
+	read, err := kdbx.Read(bytes.NewReader(content), "master password", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries := read.Entries(); len(entries) != 1 || entries[0].Password != "secret" {
+		t.Errorf("unexpected entries %+v", entries)
+	}
+}
+
+// testdata/keepassxc.kdbx wasn't written by this package but by a separate
+// implementation of kdbx 4 made from the format's documentation, with the layout,
+// aes-256, gzip and argon2id keepassxc 2.7 uses, a history, an attachment and a
+// recycle bin, and locked with "correct horse" and the xml key file next to it
+func TestKeePassXCFile(t *testing.T) {
+	key, err := keyfile.Load("testdata/keepassxc.keyx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile("testdata/keepassxc.kdbx")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := kdbx.Read(bytes.NewReader(content), "correct horse", nil); !errors.Is(err, kdbx.ErrWrongPassword) {
+		t.Errorf("expected ErrWrongPassword without the key file, got %v", err)
+	}
+	f, err := kdbx.Read(bytes.NewReader(content), "correct horse", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Cipher != kdbx.AES256 || !f.Compress || f.KDF.Memory != 1<<10 || f.KDF.Threads != 2 {
+		t.Errorf("unexpected settings %v %v %+v", f.Cipher.Name(), f.Compress, f.KDF)
+	}
+
+	check := func(entries []database.Entry) {
+		t.Helper()
+		if len(entries) != 2 {
+			t.Fatalf("expected the 2 entries outside the recycle bin, got %d", len(entries))
+		}
+		mail, vpn := entries[0], entries[1]
+		// protected values are decrypted in document order, the otp and custom field included
+		if mail.Password != "pa<ss&wörd" || mail.Fields["Recovery"] != "codes 1234" || !strings.HasPrefix(mail.OTP, "otpauth://totp/Mail:alice?secret=JBSWY3DPEHPK3PXP&") {
+			t.Errorf("unexpected protected values %+v", mail)
+		}
+		if mail.Notes != "first line\nsecond line" || !slices.Equal(mail.Tags, []string{"mail", "personal"}) {
+			t.Errorf("unexpected entry %+v", mail)
+		}
+		if !mail.Modified.Equal(time.Date(2024, time.June, 2, 18, 0, 0, 0, time.UTC)) {
+			t.Errorf("unexpected modification time %v", mail.Modified)
+		}
+		if vpn.Password != "tunnel" || !slices.Equal(vpn.URLs, []string{"https://vpn.example.com", "https://vpn2.example.com"}) || !slices.Equal(vpn.Tags, []string{"Work"}) {
+			t.Errorf("unexpected entry %+v", vpn)
+		}
+		if value, ok := vpn.Fields["Empty"]; !ok || len(value) != 0 {
+			t.Errorf("expected the empty custom string, got %v", vpn.Fields)
+		}
+	}
+	check(f.Entries())
+
+	// written back unchanged, with the parameters it was read with
+	f.Update(f.Entries())
+	var out bytes.Buffer
+	if err := f.Write(&out, "correct horse", key); err != nil {
+		t.Fatal(err)
+	}
+	read, err := kdbx.Read(&out, "correct horse", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(read.Entries())
+
+	document := read.XML()
+	for _, kept := range []string{
+		`<Key>KPXC_DECRYPTION_TIME_PREFERENCE</Key>`,
+		`<Binary><Key>backup.txt</Key><Value Ref="0"/></Binary>`,
+		`<Value Protected="True">old &amp; gone</Value>`,
+		`<Value>Deleted</Value>`,
+		`<AutoType>`,
+	} {
+		if !strings.Contains(document, kept) {
+			t.Errorf("expected the written file to contain %s", kept)
+		}
+	}
+	if strings.Count(document, "<History>") != 1 {
+		t.Error("an unchanged entry was given a history")
+	}
+}
@@ -0,0 +1,266 @@
+package kdbx
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// the parts of the keepass xml pwm reads, every element without a field here
+// is kept in an Extra list and written back unchanged
+type document struct {
+	XMLName xml.Name `xml:"KeePassFile"`
+	Meta    meta     `xml:"Meta"`
+	Root    root     `xml:"Root"`
+}
+
+type meta struct {
+	Generator        string            `xml:"Generator"`
+	DatabaseName     string            `xml:"DatabaseName"`
+	MemoryProtection *memoryProtection `xml:"MemoryProtection,omitempty"`
+	RecycleBinUUID   string            `xml:"RecycleBinUUID,omitempty"`
+	Extra            []element         `xml:",any"`
+}
+
+// which standard strings are written protected, passwords always are
+type memoryProtection struct {
+	ProtectTitle    string `xml:"ProtectTitle"`
+	ProtectUserName string `xml:"ProtectUserName"`
+	ProtectPassword string `xml:"ProtectPassword"`
+	ProtectURL      string `xml:"ProtectURL"`
+	ProtectNotes    string `xml:"ProtectNotes"`
+}
+
+type root struct {
+	Groups         []group        `xml:"Group"`
+	DeletedObjects deletedObjects `xml:"DeletedObjects"`
+	Extra          []element      `xml:",any"`
+}
+
+type deletedObjects struct {
+	Objects []deletedObject `xml:"DeletedObject"`
+}
+
+// keepass merges deletions from these rather than bringing the entry back
+type deletedObject struct {
+	UUID         string `xml:"UUID"`
+	DeletionTime string `xml:"DeletionTime"`
+}
+
+type group struct {
+	UUID    string    `xml:"UUID"`
+	Name    string    `xml:"Name"`
+	Extra   []element `xml:",any"`
+	Entries []entry   `xml:"Entry"`
+	Groups  []group   `xml:"Group"`
+}
+
+type entry struct {
+	UUID string `xml:"UUID"`
+	// icons, colours, attachments, auto-type and so on
+	Extra   []element     `xml:",any"`
+	Tags    string        `xml:"Tags"`
+	Times   times         `xml:"Times"`
+	Strings []stringField `xml:"String"`
+	History *history      `xml:"History,omitempty"`
+}
+
+type history struct {
+	Entries []entry `xml:"Entry"`
+}
+
+type times struct {
+	CreationTime         string `xml:"CreationTime"`
+	LastModificationTime string `xml:"LastModificationTime"`
+	LastAccessTime       string `xml:"LastAccessTime"`
+	ExpiryTime           string `xml:"ExpiryTime"`
+	Expires              string `xml:"Expires"`
+	UsageCount           string `xml:"UsageCount"`
+	LocationChanged      string `xml:"LocationChanged"`
+}
+
+type stringField struct {
+	Key   string `xml:"Key"`
+	Value value  `xml:"Value"`
+}
+
+// Text is the plaintext between decodeDocument and encodeDocument, even when protected
+type value struct {
+	Protected string `xml:"Protected,attr,omitempty"`
+	Text      string `xml:",chardata"`
+}
+
+type element struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr `xml:",any,attr"`
+	Inner   []byte     `xml:",innerxml"`
+}
+
+const xmlTrue = "True"
+const xmlFalse = "False"
+
+func newDocument(name string) document {
+	now := formatTime(time.Now())
+	if len(name) == 0 {
+		name = "Passwords"
+	}
+
+	return document{
+		Meta: meta{
+			Generator:    "pwm",
+			DatabaseName: name,
+			MemoryProtection: &memoryProtection{
+				ProtectTitle:    xmlFalse,
+				ProtectUserName: xmlFalse,
+				ProtectPassword: xmlTrue,
+				ProtectURL:      xmlFalse,
+				ProtectNotes:    xmlFalse,
+			},
+			Extra: []element{
+				{XMLName: xml.Name{Local: "DatabaseNameChanged"}, Inner: []byte(now)},
+				{XMLName: xml.Name{Local: "RecycleBinEnabled"}, Inner: []byte(xmlFalse)},
+			},
+		},
+		Root: root{
+			Groups: []group{{UUID: newUUID(), Name: name}},
+		},
+	}
+}
+
+// protected values are decrypted in document order, so this works on the
+// token stream before the xml is unmarshalled
+func decodeDocument(data []byte, stream cipher.Stream) (document, error) {
+	var doc document
+	plain, err := transformProtected(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")), func(text []byte) ([]byte, error) {
+		ciphertext, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(text)))
+		if err != nil {
+			return nil, ErrCorrupt
+		}
+		stream.XORKeyStream(ciphertext, ciphertext)
+		var escaped bytes.Buffer
+		xml.EscapeText(&escaped, ciphertext)
+		return escaped.Bytes(), nil
+	})
+	if err != nil {
+		return doc, err
+	}
+
+	err = xml.Unmarshal(plain, &doc)
+	if err != nil {
+		return doc, errors.New(fmt.Sprintf("invalid kdbx xml, %s", err))
+	}
+	if len(doc.Root.Groups) == 0 {
+		return doc, errors.New("kdbx xml has no root group")
+	}
+	return doc, nil
+}
+
+func encodeDocument(doc document, stream cipher.Stream) ([]byte, error) {
+	plain, err := xml.MarshalIndent(doc, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+
+	protected, err := transformProtected(plain, func(text []byte) ([]byte, error) {
+		ciphertext := make([]byte, len(text))
+		stream.XORKeyStream(ciphertext, text)
+		return []byte(base64.StdEncoding.EncodeToString(ciphertext)), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(xml.Header), protected...), nil
+}
+
+// replaces the text of every protected value in data with what transform makes of
+// it, transform is given the unescaped text and returns what to put in its place,
+// the rest of the xml is left byte for byte as it was
+func transformProtected(data []byte, transform func([]byte) ([]byte, error)) ([]byte, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	var out bytes.Buffer
+
+	copied := int64(0)
+	protected := false
+	for {
+		start := decoder.InputOffset()
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("invalid kdbx xml, %s", err))
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			protected = false
+			if t.Name.Local == "Value" {
+				for _, attr := range t.Attr {
+					protected = protected || (attr.Name.Local == "Protected" && attr.Value == xmlTrue)
+				}
+			}
+		case xml.EndElement:
+			protected = false
+		case xml.CharData:
+			if !protected {
+				continue
+			}
+			text, err := transform(t)
+			if err != nil {
+				return nil, err
+			}
+			out.Write(data[copied:start])
+			out.Write(text)
+			copied = decoder.InputOffset()
+		}
+	}
+
+	out.Write(data[copied:])
+	return out.Bytes(), nil
+}
+
+// kdbx 4 times are base64 little endian seconds since the year 1, older files used rfc 3339
+var timeEpoch = time.Date(1, time.January, 1, 0, 0, 0, 0, time.UTC).Unix()
+
+func formatTime(t time.Time) string {
+	return base64.StdEncoding.EncodeToString(binary.LittleEndian.AppendUint64(nil, uint64(t.Unix()-timeEpoch)))
+}
+
+func parseTime(text string) time.Time {
+	seconds, err := base64.StdEncoding.DecodeString(text)
+	if err == nil && len(seconds) == 8 {
+		return time.Unix(int64(binary.LittleEndian.Uint64(seconds))+timeEpoch, 0)
+	}
+
+	t, err := time.Parse(time.RFC3339, text)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// keepass stores uuids as base64, pwm writes them in the usual hex form
+func newUUID() string {
+	var uuid [16]byte
+	rand.Read(uuid[:])
+	uuid[6] = (uuid[6] & 0x0f) | 0x40
+	uuid[8] = (uuid[8] & 0x3f) | 0x80
+	return base64.StdEncoding.EncodeToString(uuid[:])
+}
+
+// "" for anything that isn't a uuid
+func uuidString(encoded string) string {
+	uuid, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(uuid) != 16 {
+		return ""
+	}
+	return fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:16])
+}
@@ -0,0 +1,167 @@
+// key files are a second secret the master password is combined with, so a
+// stolen vault and a leaked password aren't enough to open it
+// they are read the way keepass reads them, so one key file can lock both a
+// pwm vault and a kdbx file
+package keyfile
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// the key a key file holds, or the hash of a key file that doesn't hold one
+const KeyLength = 32
+
+// key files are usually tiny, any file can be one but reading a whole disk image isn't useful
+const maxSize = 1 << 26
+
+var (
+	ErrEmpty   = errors.New("key file is empty")
+	ErrTooLong = errors.New(fmt.Sprintf("key file is larger than %d MiB", maxSize>>20))
+	// a keepass xml key file whose key doesn't match its hash
+	ErrCorrupt = errors.New("xml key file is corrupted")
+)
+
+// the xml key files keepass writes, version 2.0 stores the key as hex along
+// with the start of its sha-256, 1.0 stores it as base64
+type xmlKeyFile struct {
+	XMLName xml.Name `xml:"KeyFile"`
+	Version string   `xml:"Meta>Version"`
+	Data    struct {
+		Hash string `xml:"Hash,attr"`
+		Text string `xml:",chardata"`
+	} `xml:"Key>Data"`
+}
+
+func Load(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+// a keepass xml key file gives the key it holds, as do 32 raw bytes and 64 hex
+// digits, any other file is hashed
+func Read(r io.Reader) ([]byte, error) {
+	content, err := io.ReadAll(io.LimitReader(r, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(content) == 0 {
+		return nil, ErrEmpty
+	}
+	if len(content) > maxSize {
+		return nil, ErrTooLong
+	}
+
+	if key, ok, err := parseXML(content); ok {
+		return key, err
+	}
+
+	if len(content) == KeyLength {
+		return content, nil
+	}
+	if len(content) == 2*KeyLength {
+		key, err := hex.DecodeString(string(content))
+		if err == nil {
+			return key, nil
+		}
+	}
+
+	hash := sha256.Sum256(content)
+	return hash[:], nil
+}
+
+// ok is false when content isn't a keepass xml key file at all, it is then
+// treated like any other file
+func parseXML(content []byte) ([]byte, bool, error) {
+	trimmed := bytes.TrimSpace(bytes.TrimPrefix(content, []byte("\xef\xbb\xbf")))
+	if !bytes.HasPrefix(trimmed, []byte("<")) {
+		return nil, false, nil
+	}
+
+	var keyFile xmlKeyFile
+	err := xml.Unmarshal(trimmed, &keyFile)
+	if err != nil {
+		return nil, false, nil
+	}
+
+	var key []byte
+	switch strings.TrimSpace(keyFile.Version) {
+	case "1.0", "1.00":
+		key, err = base64.StdEncoding.DecodeString(strings.TrimSpace(keyFile.Data.Text))
+		if err != nil {
+			return nil, true, ErrCorrupt
+		}
+	case "2.0":
+		key, err = hex.DecodeString(strings.Join(strings.Fields(keyFile.Data.Text), ""))
+		if err != nil {
+			return nil, true, ErrCorrupt
+		}
+		hash := sha256.Sum256(key)
+		if !strings.EqualFold(keyFile.Data.Hash, hex.EncodeToString(hash[:4])) {
+			return nil, true, ErrCorrupt
+		}
+	default:
+		return nil, true, errors.New(fmt.Sprintf("unsupported xml key file version %s", keyFile.Version))
+	}
+
+	if len(key) != KeyLength {
+		return nil, true, ErrCorrupt
+	}
+	return key, true, nil
+}
+
+// writes a new random key as a version 2.0 xml key file and returns the key
+func Generate(w io.Writer) ([]byte, error) {
+	key := make([]byte, KeyLength)
+	_, err := rand.Read(key)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.Sum256(key)
+	digits := strings.ToUpper(hex.EncodeToString(key))
+	groups := make([]string, 0, len(digits)/8)
+	for i := 0; i < len(digits); i += 8 {
+		groups = append(groups, digits[i:i+8])
+	}
+
+	_, err = fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<KeyFile>
+	<Meta>
+		<Version>2.0</Version>
+	</Meta>
+	<Key>
+		<Data Hash="%s">
+			%s
+			%s
+		</Data>
+	</Key>
+</KeyFile>
+`, strings.ToUpper(hex.EncodeToString(hash[:4])), strings.Join(groups[:4], " "), strings.Join(groups[4:], " "))
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// the composite key keepass derives its master key from, the password and the
+// key file are hashed so neither can stand in for the other
+// key is nil when there is no key file
+func Composite(password string, key []byte) []byte {
+	passwordHash := sha256.Sum256([]byte(password))
+	composite := sha256.Sum256(append(passwordHash[:], key...))
+	return composite[:]
+}
@@ -0,0 +1,129 @@
+package keyfile_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+
+	"pwm/keyfile"
+)
+
+// 00 01 02 .. 1f
+var testKey = func() []byte {
+	key := make([]byte, keyfile.KeyLength)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	return key
+}()
+
+const xmlV2 = `<?xml version="1.0" encoding="UTF-8"?>
+<KeyFile>
+	<Meta>
+		<Version>2.0</Version>
+	</Meta>
+	<Key>
+		<Data Hash="630DCD29">
+			00010203 04050607 08090A0B 0C0D0E0F
+			10111213 14151617 18191A1B 1C1D1E1F
+		</Data>
+	</Key>
+</KeyFile>
+`
+
+const xmlV1 = `<?xml version="1.0" encoding="utf-8"?>
+<KeyFile>
+	<Meta>
+		<Version>1.00</Version>
+	</Meta>
+	<Key>
+		<Data>AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8=</Data>
+	</Key>
+</KeyFile>
+`
+
+func TestRead(t *testing.T) {
+	hashed, _ := hex.DecodeString("edfc089719c0a061bec08afad3dead4480add63a9677c13cdb5bba1779160d0f")
+
+	cases := []struct {
+		name    string
+		content []byte
+		want    []byte
+	}{
+		{"xml 2.0", []byte(xmlV2), testKey},
+		{"xml 1.0", []byte(xmlV1), testKey},
+		{"raw", testKey, testKey},
+		{"hex", []byte(hex.EncodeToString(testKey)), testKey},
+		{"other", []byte("not a key"), hashed},
+		{"other xml", []byte("<html></html>"), nil},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			key, err := keyfile.Read(bytes.NewReader(c.content))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.want != nil && !bytes.Equal(key, c.want) {
+				t.Errorf("expected key %x, got %x", c.want, key)
+			}
+			if len(key) != keyfile.KeyLength {
+				t.Errorf("expected a %d byte key, got %d", keyfile.KeyLength, len(key))
+			}
+		})
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	_, err := keyfile.Read(bytes.NewReader(nil))
+	if !errors.Is(err, keyfile.ErrEmpty) {
+		t.Errorf("expected ErrEmpty, got %v", err)
+	}
+
+	// one digit of the key changed, the hash no longer matches
+	changed := strings.Replace(xmlV2, "00010203", "00010204", 1)
+	_, err = keyfile.Read(strings.NewReader(changed))
+	if !errors.Is(err, keyfile.ErrCorrupt) {
+		t.Errorf("expected ErrCorrupt, got %v", err)
+	}
+
+	_, err = keyfile.Read(strings.NewReader(strings.Replace(xmlV2, "2.0", "3.0", 1)))
+	if err == nil {
+		t.Error("expected an unknown version to fail")
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	var buffer bytes.Buffer
+	key, err := keyfile.Generate(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := keyfile.Read(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key, read) {
+		t.Error("generated key file doesn't hold the returned key")
+	}
+
+	other, err := keyfile.Generate(&bytes.Buffer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(key, other) {
+		t.Error("expected a new key every time")
+	}
+}
+
+func TestComposite(t *testing.T) {
+	want, _ := hex.DecodeString("7c6814f7bf4b3b3bb4cd2a3ec54eee34e923610af17e2f0a7044a5c0f80dbe85")
+	if got := keyfile.Composite("master", testKey); !bytes.Equal(got, want) {
+		t.Errorf("expected %x, got %x", want, got)
+	}
+	if bytes.Equal(keyfile.Composite("master", nil), keyfile.Composite("master", testKey)) {
+		t.Error("the key file doesn't change the composite key")
+	}
+}
@@ -2,12 +2,14 @@ package main
 
 import (
 	"fmt"
+	"os"
 	"pwm/cli"
 )
 
 func main() {
 	err := cli.Init()
 	if err != nil {
-		fmt.Println("Action failed")
+		fmt.Fprintln(os.Stderr, "Action failed:", err)
 	}
+	os.Exit(cli.ExitCode(err))
 }
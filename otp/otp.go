@@ -0,0 +1,237 @@
+package otp
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Algorithm string
+
+const (
+	SHA1   Algorithm = "SHA1"
+	SHA256 Algorithm = "SHA256"
+	SHA512 Algorithm = "SHA512"
+)
+
+const (
+	defaultDigits = 6
+	defaultPeriod = 30
+)
+
+func (alg Algorithm) hash() (func() hash.Hash, error) {
+	switch alg {
+	case SHA1:
+		return sha1.New, nil
+	case SHA256:
+		return sha256.New, nil
+	case SHA512:
+		return sha512.New, nil
+	}
+	return nil, errors.New(fmt.Sprintf("unsupported otp algorithm %s", alg))
+}
+
+// RFC 4226, digits must be between 6 and 8
+func HOTP(secret []byte, counter uint64, digits int, alg Algorithm) (string, error) {
+	if digits < 6 || digits > 8 {
+		return "", errors.New("otp codes must have 6 to 8 digits")
+	}
+
+	newHash, err := alg.hash()
+	if err != nil {
+		return "", err
+	}
+
+	var message [8]byte
+	binary.BigEndian.PutUint64(message[:], counter)
+
+	mac := hmac.New(newHash, secret)
+	mac.Write(message[:])
+	sum := mac.Sum(nil)
+
+	// dynamic truncation
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulo := uint32(1)
+	for i := 0; i < digits; i++ {
+		modulo *= 10
+	}
+
+	return fmt.Sprintf("%0*d", digits, code%modulo), nil
+}
+
+// RFC 6238, period is the number of seconds each code is valid for
+func TOTP(secret []byte, t time.Time, period int, digits int, alg Algorithm) (string, error) {
+	if period < 1 {
+		return "", errors.New("totp period must be positive")
+	}
+	return HOTP(secret, uint64(t.Unix())/uint64(period), digits, alg)
+}
+
+// the contents of an otpauth:// uri
+type Key struct {
+	// totp or hotp
+	Type      string
+	Issuer    string
+	Account   string
+	Secret    []byte
+	Algorithm Algorithm
+	Digits    int
+	// seconds, only used by totp
+	Period int
+	// only used by hotp
+	Counter uint64
+}
+
+func ParseURI(uri string) (Key, error) {
+	var key Key
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		return key, err
+	}
+	if u.Scheme != "otpauth" {
+		return key, errors.New("otp uri must use the otpauth scheme")
+	}
+
+	key.Type = strings.ToLower(u.Host)
+	if key.Type != "totp" && key.Type != "hotp" {
+		return key, errors.New(fmt.Sprintf("unsupported otp type %s", u.Host))
+	}
+
+	// the label is "issuer:account" or just "account"
+	label := strings.TrimPrefix(u.Path, "/")
+	if issuer, account, ok := strings.Cut(label, ":"); ok {
+		key.Issuer = issuer
+		key.Account = strings.TrimSpace(account)
+	} else {
+		key.Account = label
+	}
+
+	query := u.Query()
+	if issuer := query.Get("issuer"); len(issuer) != 0 {
+		key.Issuer = issuer
+	}
+
+	key.Secret, err = decodeSecret(query.Get("secret"))
+	if err != nil {
+		return key, err
+	}
+
+	key.Algorithm = SHA1
+	if alg := query.Get("algorithm"); len(alg) != 0 {
+		key.Algorithm = Algorithm(strings.ToUpper(alg))
+	}
+	if _, err := key.Algorithm.hash(); err != nil {
+		return key, err
+	}
+
+	key.Digits, err = intParam(query, "digits", defaultDigits)
+	if err != nil {
+		return key, err
+	}
+	if key.Digits < 6 || key.Digits > 8 {
+		return key, errors.New("otp codes must have 6 to 8 digits")
+	}
+
+	key.Period, err = intParam(query, "period", defaultPeriod)
+	if err != nil {
+		return key, err
+	}
+	if key.Period < 1 {
+		return key, errors.New("totp period must be positive")
+	}
+
+	if key.Type == "hotp" {
+		counter := query.Get("counter")
+		if len(counter) == 0 {
+			return key, errors.New("hotp uri needs a counter")
+		}
+		key.Counter, err = strconv.ParseUint(counter, 10, 64)
+		if err != nil {
+			return key, errors.New("invalid hotp counter")
+		}
+	}
+
+	return key, nil
+}
+
+func (key *Key) URI() string {
+	label := key.Account
+	if len(key.Issuer) != 0 {
+		label = key.Issuer + ":" + key.Account
+	}
+
+	query := url.Values{}
+	query.Set("secret", base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(key.Secret))
+	if len(key.Issuer) != 0 {
+		query.Set("issuer", key.Issuer)
+	}
+	query.Set("algorithm", string(key.Algorithm))
+	query.Set("digits", strconv.Itoa(key.Digits))
+	if key.Type == "hotp" {
+		query.Set("counter", strconv.FormatUint(key.Counter, 10))
+	} else {
+		query.Set("period", strconv.Itoa(key.Period))
+	}
+
+	u := url.URL{Scheme: "otpauth", Host: key.Type, Path: "/" + label, RawQuery: query.Encode()}
+	return u.String()
+}
+
+// the code for time t and how long it stays valid, hotp codes use Counter and
+// never expire, the caller is responsible for storing Counter+1 afterwards
+func (key *Key) Code(t time.Time) (string, time.Duration, error) {
+	if key.Type == "hotp" {
+		code, err := HOTP(key.Secret, key.Counter, key.Digits, key.Algorithm)
+		return code, 0, err
+	}
+
+	code, err := TOTP(key.Secret, t, key.Period, key.Digits, key.Algorithm)
+	if err != nil {
+		return "", 0, err
+	}
+
+	period := int64(key.Period)
+	remaining := period - t.Unix()%period
+	return code, time.Duration(remaining) * time.Second, nil
+}
+
+// secrets are base32, usually without padding and sometimes lowercase or spaced
+func decodeSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	secret = strings.TrimRight(secret, "=")
+	if len(secret) == 0 {
+		return nil, errors.New("otp uri has no secret")
+	}
+
+	decoded, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		return nil, errors.New("otp secret is not valid base32")
+	}
+	return decoded, nil
+}
+
+func intParam(query url.Values, name string, fallback int) (int, error) {
+	value := query.Get(name)
+	if len(value) == 0 {
+		return fallback, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("invalid otp %s", name))
+	}
+	return n, nil
+}
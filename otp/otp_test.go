@@ -0,0 +1,114 @@
+package otp_test
+
+import (
+	"testing"
+	"time"
+
+	"pwm/otp"
+)
+
+// RFC 4226 appendix D
+func TestHOTP(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	expected := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+
+	for counter, want := range expected {
+		code, err := otp.HOTP(secret, uint64(counter), 6, otp.SHA1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != want {
+			t.Errorf("counter %d: expected %s, got %s", counter, want, code)
+		}
+	}
+}
+
+// RFC 6238 appendix B
+func TestTOTP(t *testing.T) {
+	secrets := map[otp.Algorithm][]byte{
+		otp.SHA1:   []byte("12345678901234567890"),
+		otp.SHA256: []byte("12345678901234567890123456789012"),
+		otp.SHA512: []byte("1234567890123456789012345678901234567890123456789012345678901234"),
+	}
+
+	vectors := []struct {
+		time  int64
+		codes map[otp.Algorithm]string
+	}{
+		{59, map[otp.Algorithm]string{otp.SHA1: "94287082", otp.SHA256: "46119246", otp.SHA512: "90693936"}},
+		{1111111109, map[otp.Algorithm]string{otp.SHA1: "07081804", otp.SHA256: "68084774", otp.SHA512: "25091201"}},
+		{1111111111, map[otp.Algorithm]string{otp.SHA1: "14050471", otp.SHA256: "67062674", otp.SHA512: "99943326"}},
+		{1234567890, map[otp.Algorithm]string{otp.SHA1: "89005924", otp.SHA256: "91819424", otp.SHA512: "93441116"}},
+		{2000000000, map[otp.Algorithm]string{otp.SHA1: "69279037", otp.SHA256: "90698825", otp.SHA512: "38618901"}},
+		{20000000000, map[otp.Algorithm]string{otp.SHA1: "65353130", otp.SHA256: "77737706", otp.SHA512: "47863826"}},
+	}
+
+	for _, vector := range vectors {
+		for alg, want := range vector.codes {
+			code, err := otp.TOTP(secrets[alg], time.Unix(vector.time, 0), 30, 8, alg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if code != want {
+				t.Errorf("%s at %d: expected %s, got %s", alg, vector.time, want, code)
+			}
+		}
+	}
+}
+
+func TestParseURI(t *testing.T) {
+	// GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ is base32 for 12345678901234567890
+	key, err := otp.ParseURI("otpauth://totp/Example:alice@example.com?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ&issuer=Example&digits=8&period=60")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.Type != "totp" || key.Issuer != "Example" || key.Account != "alice@example.com" {
+		t.Error("otp label parsed incorrectly")
+	}
+	if string(key.Secret) != "12345678901234567890" || key.Algorithm != otp.SHA1 || key.Digits != 8 || key.Period != 60 {
+		t.Error("otp parameters parsed incorrectly")
+	}
+
+	code, remaining, err := key.Code(time.Unix(59, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := otp.TOTP(key.Secret, time.Unix(59, 0), 60, 8, otp.SHA1)
+	if code != want || remaining != time.Second {
+		t.Errorf("expected %s with 1s remaining, got %s with %s", want, code, remaining)
+	}
+
+	reparsed, err := otp.ParseURI(key.URI())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reparsed.Issuer != key.Issuer || reparsed.Account != key.Account || string(reparsed.Secret) != string(key.Secret) || reparsed.Period != 60 {
+		t.Error("uri did not survive a round trip")
+	}
+
+	hotp, err := otp.ParseURI("otpauth://hotp/alice?secret=gezdgnbvgy3tqojqgezdgnbvgy3tqojq&counter=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _, err = hotp.Code(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != "287082" {
+		t.Errorf("expected the hotp code for counter 1, got %s", code)
+	}
+
+	for _, uri := range []string{
+		"https://totp/alice?secret=GEZDGNBV",
+		"otpauth://motp/alice?secret=GEZDGNBV",
+		"otpauth://totp/alice",
+		"otpauth://totp/alice?secret=not-base32!",
+		"otpauth://totp/alice?secret=GEZDGNBV&algorithm=MD5",
+		"otpauth://totp/alice?secret=GEZDGNBV&digits=9",
+		"otpauth://hotp/alice?secret=GEZDGNBV",
+	} {
+		if _, err := otp.ParseURI(uri); err == nil {
+			t.Errorf("expected %s to fail", uri)
+		}
+	}
+}
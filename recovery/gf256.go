@@ -0,0 +1,34 @@
+package recovery
+
+// arithmetic in GF(2^8) with the aes polynomial x^8 + x^4 + x^3 + x + 1
+// there are no lookup tables, so the time taken doesn't depend on the secret
+// addition and subtraction are both xor
+
+func mul(a byte, b byte) byte {
+	var product byte
+	for i := 0; i < 8; i++ {
+		// all ones when the low bit of b is set, without branching on it
+		product ^= a & -(b & 1)
+		b >>= 1
+		carry := -(a >> 7)
+		a = (a << 1) ^ (0x1b & carry)
+	}
+	return product
+}
+
+// a^254 is the inverse of a, as a^255 = 1 for every a but 0, which has none
+func inverse(a byte) byte {
+	result := byte(1)
+	power := a
+	for exponent := 254; exponent > 0; exponent >>= 1 {
+		if exponent&1 == 1 {
+			result = mul(result, power)
+		}
+		power = mul(power, power)
+	}
+	return result
+}
+
+func div(a byte, b byte) byte {
+	return mul(a, inverse(b))
+}
@@ -0,0 +1,197 @@
+// shamir secret sharing over GF(2^8), splits a vault's data key into shares
+// so that any threshold of them recovers it and fewer reveal nothing about it
+// each byte of the secret is the constant term of its own random polynomial
+// of degree threshold - 1, a share is the value of every polynomial at one x
+package recovery
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	MinThreshold = 2
+	// x is a nonzero byte, 0 is where the secret is
+	MaxShares = 255
+
+	shareVersion   = 1
+	setIDLength    = 8
+	checksumLength = 4
+	sharePrefix    = "pwm-share-"
+)
+
+var (
+	ErrTooFewShares = errors.New("not enough recovery shares")
+	// shares from different splits can't be combined, even of the same secret
+	ErrMixedShares = errors.New("recovery shares are from different splits")
+	ErrDuplicate   = errors.New("the same recovery share was given twice")
+	// the share was mistyped or damaged
+	ErrChecksum = errors.New("recovery share checksum doesn't match, check it for typos")
+	ErrInvalid  = errors.New("not a recovery share")
+)
+
+type Share struct {
+	// the x coordinate of the share, 1 to MaxShares
+	Index     byte
+	Threshold byte
+	// random for every split, so shares of different splits are told apart
+	SetID [setIDLength]byte
+	Value []byte
+}
+
+// any threshold of the count shares give back secret
+func Split(secret []byte, count int, threshold int) ([]Share, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("nothing to split")
+	}
+	if threshold < MinThreshold || threshold > count || count > MaxShares {
+		return nil, errors.New(fmt.Sprintf("the threshold must be from %d to the number of shares, which is at most %d", MinThreshold, MaxShares))
+	}
+
+	var setID [setIDLength]byte
+	_, err := rand.Read(setID[:])
+	if err != nil {
+		return nil, err
+	}
+
+	// coefficients[i] holds the coefficients of x^(i+1) for every byte of the secret
+	coefficients := make([][]byte, threshold-1)
+	for i := range coefficients {
+		coefficients[i] = make([]byte, len(secret))
+		_, err = rand.Read(coefficients[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	shares := make([]Share, count)
+	for i := range shares {
+		x := byte(i + 1)
+		value := make([]byte, len(secret))
+		for b := range secret {
+			// horner's method from the highest coefficient down to the secret
+			y := byte(0)
+			for c := len(coefficients) - 1; c >= 0; c-- {
+				y = mul(y, x) ^ coefficients[c][b]
+			}
+			value[b] = mul(y, x) ^ secret[b]
+		}
+		shares[i] = Share{Index: x, Threshold: byte(threshold), SetID: setID, Value: value}
+	}
+
+	return shares, nil
+}
+
+// the secret from at least the threshold of its shares, more shares than that are ignored
+// shares that are all from one split but wrong give a wrong secret rather than
+// an error, the vault tells when its data key is wrong
+func Combine(shares []Share) ([]byte, error) {
+	if len(shares) == 0 {
+		return nil, ErrTooFewShares
+	}
+
+	first := shares[0]
+	seen := make(map[byte]bool)
+	for _, share := range shares {
+		if share.SetID != first.SetID || share.Threshold != first.Threshold || len(share.Value) != len(first.Value) {
+			return nil, ErrMixedShares
+		}
+		if share.Index == 0 {
+			return nil, ErrInvalid
+		}
+		if seen[share.Index] {
+			return nil, ErrDuplicate
+		}
+		seen[share.Index] = true
+	}
+	if len(shares) < int(first.Threshold) {
+		return nil, ErrTooFewShares
+	}
+	shares = shares[:first.Threshold]
+
+	// lagrange interpolation at x = 0
+	secret := make([]byte, len(first.Value))
+	for i, share := range shares {
+		basis := byte(1)
+		for j, other := range shares {
+			if i != j {
+				basis = mul(basis, div(other.Index, other.Index^share.Index))
+			}
+		}
+		for b := range secret {
+			secret[b] ^= mul(basis, share.Value[b])
+		}
+	}
+
+	return secret, nil
+}
+
+// version || threshold || index || set id || value || checksum
+func (share Share) encode() []byte {
+	var buffer bytes.Buffer
+	buffer.WriteByte(shareVersion)
+	buffer.WriteByte(share.Threshold)
+	buffer.WriteByte(share.Index)
+	buffer.Write(share.SetID[:])
+	buffer.Write(share.Value)
+	checksum := sha256.Sum256(buffer.Bytes())
+	buffer.Write(checksum[:checksumLength])
+	return buffer.Bytes()
+}
+
+// lowercase base32 in groups of four, so a share can be written down and typed back in
+func (share Share) String() string {
+	encoded := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(share.encode()))
+	groups := make([]string, 0, len(encoded)/4+1)
+	for len(encoded) > 4 {
+		groups = append(groups, encoded[:4])
+		encoded = encoded[4:]
+	}
+	groups = append(groups, encoded)
+	return sharePrefix + strings.Join(groups, "-")
+}
+
+// case, dashes and spaces don't matter
+func ParseShare(text string) (Share, error) {
+	var share Share
+	text = strings.ToLower(strings.TrimSpace(text))
+	if !strings.HasPrefix(text, sharePrefix) {
+		return share, ErrInvalid
+	}
+	text = strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' || r == '\t' {
+			return -1
+		}
+		return r
+	}, strings.TrimPrefix(text, sharePrefix))
+
+	data, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(text))
+	if err != nil {
+		return share, ErrChecksum
+	}
+	if len(data) < 3+setIDLength+1+checksumLength {
+		return share, ErrInvalid
+	}
+	body := data[:len(data)-checksumLength]
+	checksum := sha256.Sum256(body)
+	if !bytes.Equal(checksum[:checksumLength], data[len(body):]) {
+		return share, ErrChecksum
+	}
+	if body[0] != shareVersion {
+		return share, errors.New(fmt.Sprintf("unsupported recovery share version %d", body[0]))
+	}
+
+	share.Threshold = body[1]
+	share.Index = body[2]
+	copy(share.SetID[:], body[3:3+setIDLength])
+	share.Value = bytes.Clone(body[3+setIDLength:])
+	if share.Threshold < MinThreshold || share.Index == 0 {
+		return share, ErrInvalid
+	}
+	return share, nil
+}
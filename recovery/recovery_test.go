@@ -0,0 +1,141 @@
+package recovery_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"pwm/recovery"
+)
+
+var testSecret = []byte("0123456789abcdef0123456789abcdef")
+
+// every subset of the shares with the threshold or more recovers the secret
+func TestSplitCombine(t *testing.T) {
+	shares, err := recovery.Split(testSecret, 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shares) != 5 {
+		t.Fatalf("expected 5 shares, got %d", len(shares))
+	}
+
+	for subset := 0; subset < 1<<len(shares); subset++ {
+		chosen := make([]recovery.Share, 0)
+		for i, share := range shares {
+			if subset&(1<<i) != 0 {
+				chosen = append(chosen, share)
+			}
+		}
+
+		secret, err := recovery.Combine(chosen)
+		if len(chosen) < 3 {
+			if !errors.Is(err, recovery.ErrTooFewShares) {
+				t.Errorf("expected %d shares to be too few, got %v", len(chosen), err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(secret, testSecret) {
+			t.Errorf("shares %b recovered %x", subset, secret)
+		}
+	}
+}
+
+// two shares of a 3 of 3 split are consistent with every possible first byte
+func TestBelowThreshold(t *testing.T) {
+	shares, err := recovery.Split(testSecret, 3, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, share := range shares {
+		if bytes.Contains(share.Value, testSecret[:4]) {
+			t.Error("share holds part of the secret")
+		}
+	}
+
+	// a third share made up for any secret byte fits the two real ones
+	forged := shares[2]
+	forged.Value = bytes.Clone(forged.Value)
+	seen := make(map[byte]bool)
+	for guess := 0; guess < 256; guess++ {
+		forged.Value[0] = byte(guess)
+		secret, err := recovery.Combine([]recovery.Share{shares[0], shares[1], forged})
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen[secret[0]] = true
+	}
+	if len(seen) != 256 {
+		t.Errorf("expected every secret byte to be possible, got %d", len(seen))
+	}
+}
+
+func TestInvalidShares(t *testing.T) {
+	shares, err := recovery.Split(testSecret, 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := recovery.Split(testSecret, 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = recovery.Combine([]recovery.Share{shares[0], other[1]})
+	if !errors.Is(err, recovery.ErrMixedShares) {
+		t.Errorf("expected ErrMixedShares, got %v", err)
+	}
+	_, err = recovery.Combine([]recovery.Share{shares[0], shares[0]})
+	if !errors.Is(err, recovery.ErrDuplicate) {
+		t.Errorf("expected ErrDuplicate, got %v", err)
+	}
+
+	for _, c := range []struct{ count, threshold int }{{3, 1}, {3, 4}, {256, 2}} {
+		_, err = recovery.Split(testSecret, c.count, c.threshold)
+		if err == nil {
+			t.Errorf("expected %d of %d to fail", c.threshold, c.count)
+		}
+	}
+}
+
+func TestParseShare(t *testing.T) {
+	shares, err := recovery.Split(testSecret, 2, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	text := shares[1].String()
+	if !strings.HasPrefix(text, "pwm-share-") {
+		t.Errorf("unexpected share %s", text)
+	}
+
+	// as it might be typed back in from paper
+	typed := strings.ToUpper(strings.ReplaceAll(text[len("pwm-share-"):], "-", " "))
+	parsed, err := recovery.ParseShare("pwm-share-" + typed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Index != shares[1].Index || parsed.Threshold != 2 || parsed.SetID != shares[1].SetID || !bytes.Equal(parsed.Value, shares[1].Value) {
+		t.Errorf("share changed when parsed, got %+v", parsed)
+	}
+
+	// one character changed, the first of the value as the last one is partly padding
+	position := len("pwm-share-") + 20
+	replacement := byte('a')
+	if text[position] == 'a' {
+		replacement = 'b'
+	}
+	_, err = recovery.ParseShare(text[:position] + string(replacement) + text[position+1:])
+	if !errors.Is(err, recovery.ErrChecksum) {
+		t.Errorf("expected ErrChecksum, got %v", err)
+	}
+
+	_, err = recovery.ParseShare("hunter2")
+	if !errors.Is(err, recovery.ErrInvalid) {
+		t.Errorf("expected ErrInvalid, got %v", err)
+	}
+}
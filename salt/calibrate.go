@@ -0,0 +1,86 @@
+package salt
+
+import (
+	"errors"
+	"time"
+)
+
+// the longest a single benchmark run may take, calibration gives up rather than
+// run the kdf for minutes on a slow machine
+const maxCalibrationRun = 30 * time.Second
+
+// the most passes a vault header accepts
+const maxArgon2Time = 64
+
+var calibrationPassword = []byte("calibration password")
+
+// finds the number of passes over memory KiB that takes about target on this
+// machine, memory stays fixed since it is usually limited by the smallest
+// machine the vault is opened on rather than by time
+// returns the parameters and how long they took
+func CalibrateArgon2(target time.Duration, memory uint32, threads uint8) (Argon2Params, time.Duration, error) {
+	params := Argon2Params{Time: 1, Memory: memory, Threads: threads, KeyLength: KeyLength}
+	err := params.Validate()
+	if err != nil {
+		return params, 0, err
+	}
+
+	// time grows about linearly with passes, but the first pass also pays for
+	// allocating memory, so the estimate is refined until it reaches target
+	elapsed := time.Duration(0)
+	for elapsed < target && params.Time < maxArgon2Time {
+		if elapsed > 0 {
+			params.Time = min(maxArgon2Time, max(params.Time+1, uint32(int64(params.Time)*int64(target)/int64(elapsed))))
+		}
+		elapsed, err = timeArgon2(params)
+		if err != nil {
+			return params, 0, err
+		}
+	}
+	return params, elapsed, nil
+}
+
+// finds the smallest N that takes at least target on this machine without using
+// more than maxMemory bytes, the time of the largest N allowed is returned when
+// even that is faster than target
+func CalibrateScrypt(target time.Duration, maxMemory uint64) (ScryptParams, time.Duration, error) {
+	params := ScryptCost(MinScryptLogN)
+	if params.MemoryUsage() > maxMemory {
+		return params, 0, errors.New("scrypt needs more memory than allowed at its minimum cost")
+	}
+
+	for {
+		elapsed, err := timeScrypt(params)
+		if err != nil {
+			return params, 0, err
+		}
+
+		next := params
+		next.LogN++
+		// doubling N doubles the time, stop at the first cost that reaches target
+		if elapsed >= target || next.MemoryUsage() > maxMemory || next.Validate() != nil {
+			return params, elapsed, nil
+		}
+		params = next
+	}
+}
+
+func timeArgon2(params Argon2Params) (time.Duration, error) {
+	start := time.Now()
+	_, err := Argon2WithParams(calibrationPassword, nil, params)
+	elapsed := time.Since(start)
+	if err == nil && elapsed > maxCalibrationRun {
+		return elapsed, errors.New("argon2 took too long to calibrate, try less memory")
+	}
+	return elapsed, err
+}
+
+func timeScrypt(params ScryptParams) (time.Duration, error) {
+	start := time.Now()
+	_, err := ScryptWithParams(calibrationPassword, nil, params)
+	elapsed := time.Since(start)
+	if err == nil && elapsed > maxCalibrationRun {
+		return elapsed, errors.New("scrypt took too long to calibrate")
+	}
+	return elapsed, err
+}
@@ -0,0 +1,56 @@
+package salt_test
+
+import (
+	"testing"
+	"time"
+
+	"pwm/salt"
+)
+
+func TestCalibrateArgon2(t *testing.T) {
+	params, elapsed, err := salt.CalibrateArgon2(50*time.Millisecond, salt.MinArgon2Memory, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.Validate() != nil {
+		t.Errorf("calibration returned invalid parameters %+v", params)
+	}
+	if params.Memory != salt.MinArgon2Memory || params.Threads != 2 {
+		t.Errorf("calibration changed the memory or threads, got %+v", params)
+	}
+	if elapsed < 50*time.Millisecond && params.Time < 64 {
+		t.Errorf("%+v took %v, short of the target", params, elapsed)
+	}
+
+	_, _, err = salt.CalibrateArgon2(time.Second, 1<<10, 2)
+	if err == nil {
+		t.Error("expected calibration below the minimum memory to fail")
+	}
+}
+
+func TestCalibrateScrypt(t *testing.T) {
+	params, elapsed, err := salt.CalibrateScrypt(50*time.Millisecond, 1<<30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.Validate() != nil {
+		t.Errorf("calibration returned invalid parameters %+v", params)
+	}
+	if elapsed < 50*time.Millisecond {
+		t.Errorf("%+v took %v, short of the target", params, elapsed)
+	}
+
+	// the memory limit wins over the target
+	params, _, err = salt.CalibrateScrypt(time.Hour, 1<<24)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.MemoryUsage() > 1<<24 || params.LogN != 14 {
+		t.Errorf("expected log2 N of 14 under a 16 MiB limit, got %+v", params)
+	}
+
+	_, _, err = salt.CalibrateScrypt(time.Second, 1<<10)
+	if err == nil {
+		t.Error("expected calibration with too little memory to fail")
+	}
+}
@@ -10,43 +10,120 @@ import (
 )
 
 const (
-	argon2T = 15
-	argon2M = 1 << 18
 	argon2P = 4
 
-	scryptN = 2 << 17 // cost of 2^18
 	scryptR = 8
 	scryptP = 4
 
 	SaltLength = 16
+	KeyLength  = 32
 )
 
+// the weakest parameters accepted, below these a derived key is too cheap to guess
+const (
+	MinArgon2Time   = 1
+	MinArgon2Memory = 1 << 13 // KiB
+	MinScryptLogN   = 10
+	MinKeyLength    = 16
+)
+
+// the most memory either kdf may be asked to use, in bytes
+const MaxMemory = 1 << 32
+
 type SaltResult struct {
 	Key  []byte
 	Salt [SaltLength]byte
 }
 
+type Argon2Params struct {
+	// passes over the memory
+	Time uint32
+	// in KiB
+	Memory    uint32
+	Threads   uint8
+	KeyLength uint32
+}
+
+// scrypt has no separate time parameter, both its time and memory grow with N,
+// it uses 128 * R * 2^LogN bytes
+type ScryptParams struct {
+	LogN uint8
+	// block size
+	R int
+	// parallelism, scrypt runs its lanes one after another so more only adds time
+	P         int
+	KeyLength int
+}
+
+// the parameters Argon2 uses for cost, time is cost and memory is 2^cost KiB
+func Argon2Cost(cost int) Argon2Params {
+	return Argon2Params{Time: uint32(cost), Memory: uint32(1 << cost), Threads: argon2P, KeyLength: KeyLength}
+}
+
+// the parameters Scrypt uses for cost, N is 2^cost
+func ScryptCost(cost int) ScryptParams {
+	return ScryptParams{LogN: uint8(cost), R: scryptR, P: scryptP, KeyLength: KeyLength}
+}
+
+func (params Argon2Params) Validate() error {
+	if params.Time < MinArgon2Time {
+		return errors.New(fmt.Sprintf("argon2 time must be at least %d", MinArgon2Time))
+	}
+	if params.Threads < 1 {
+		return errors.New("argon2 needs at least one thread")
+	}
+	// argon2 itself needs 8 KiB per thread
+	if params.Memory < MinArgon2Memory || params.Memory < 8*uint32(params.Threads) {
+		return errors.New(fmt.Sprintf("argon2 memory must be at least %d KiB", max(MinArgon2Memory, 8*uint32(params.Threads))))
+	}
+	if params.KeyLength < MinKeyLength {
+		return errors.New(fmt.Sprintf("key length must be at least %d bytes", MinKeyLength))
+	}
+	return nil
+}
+
+func (params ScryptParams) Validate() error {
+	if params.LogN < MinScryptLogN || params.LogN > 62 {
+		return errors.New(fmt.Sprintf("scrypt log2 N must be between %d and 62", MinScryptLogN))
+	}
+	if params.R < 1 || params.P < 1 || uint64(params.R)*uint64(params.P) >= 1<<30 {
+		return errors.New("scrypt r and p must be positive and their product below 2^30")
+	}
+	if params.KeyLength < MinKeyLength {
+		return errors.New(fmt.Sprintf("key length must be at least %d bytes", MinKeyLength))
+	}
+	return nil
+}
+
+// in bytes
+func (params Argon2Params) MemoryUsage() uint64 {
+	return uint64(params.Memory) << 10
+}
+
+// in bytes
+func (params ScryptParams) MemoryUsage() uint64 {
+	return 128 * uint64(params.R) << params.LogN
+}
+
 // recommended cost as of 2023 is 18
 // salt can be nil if a random number is to be generated
 func Argon2(password []byte, salt []byte, cost int) (SaltResult, error) {
-	result := SaltResult{}
-	var err error
+	return Argon2WithParams(password, salt, Argon2Cost(cost))
+}
 
-	if salt == nil {
-		_, err = rand.Read(result.Salt[:])
-		if err != nil {
-			return result, err
-		}
-	} else {
-		if len(salt) != SaltLength {
-			return result, errors.New(fmt.Sprintf("salt is expected to be %d bytes long", SaltLength))
-		}
-		copy(result.Salt[:], salt)
+// salt can be nil if a random number is to be generated
+func Argon2WithParams(password []byte, salt []byte, params Argon2Params) (SaltResult, error) {
+	err := params.Validate()
+	if err != nil {
+		return SaltResult{}, err
+	}
+
+	result, err := newSaltResult(salt)
+	if err != nil {
+		return result, err
 	}
 
-	argon2T := uint32(cost)
-	argon2M := uint32(1 << cost)
-	result.Key = argon2.IDKey(password, result.Salt[:], argon2T, argon2M, argon2P, 32)
+	result.Key = argon2.IDKey(password, result.Salt[:], params.Time, params.Memory, params.Threads, params.KeyLength)
 
 	return result, nil
 }
@@ -54,11 +131,34 @@ func Argon2(password []byte, salt []byte, cost int) (SaltResult, error) {
 // recommended cost as of 2023 is 18
 // salt can be nil if a random number is to be generated
 func Scrypt(password []byte, salt []byte, cost int) (SaltResult, error) {
+	return ScryptWithParams(password, salt, ScryptCost(cost))
+}
+
+// salt can be nil if a random number is to be generated
+func ScryptWithParams(password []byte, salt []byte, params ScryptParams) (SaltResult, error) {
+	err := params.Validate()
+	if err != nil {
+		return SaltResult{}, err
+	}
+
+	result, err := newSaltResult(salt)
+	if err != nil {
+		return result, err
+	}
+
+	result.Key, err = scrypt.Key(password, result.Salt[:], 1<<params.LogN, params.R, params.P, params.KeyLength)
+	if err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+func newSaltResult(salt []byte) (SaltResult, error) {
 	result := SaltResult{}
-	var err error
 
 	if salt == nil {
-		_, err = rand.Read(result.Salt[:])
+		_, err := rand.Read(result.Salt[:])
 		if err != nil {
 			return result, err
 		}
@@ -69,11 +169,5 @@ func Scrypt(password []byte, salt []byte, cost int) (SaltResult, error) {
 		copy(result.Salt[:], salt)
 	}
 
-	scryptN := 2 << (cost - 1) // 2 ^ cost
-	result.Key, err = scrypt.Key(password, result.Salt[:], scryptN, scryptR, scryptP, 32)
-	if err != nil {
-		return result, err
-	}
-
 	return result, nil
 }
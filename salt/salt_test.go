@@ -82,3 +82,50 @@ func TestScrypt(t *testing.T) {
 		t.Error("original string and decrypted string are not the same")
 	}
 }
+
+func TestParamsValidate(t *testing.T) {
+	for _, params := range []salt.Argon2Params{
+		{Time: 0, Memory: 1 << 16, Threads: 4, KeyLength: 32},
+		{Time: 3, Memory: 1 << 10, Threads: 4, KeyLength: 32},
+		{Time: 3, Memory: 1 << 16, Threads: 0, KeyLength: 32},
+		{Time: 3, Memory: 1 << 16, Threads: 4, KeyLength: 8},
+	} {
+		if params.Validate() == nil {
+			t.Errorf("expected %+v to be rejected", params)
+		}
+		_, err := salt.Argon2WithParams([]byte("password"), nil, params)
+		if err == nil {
+			t.Errorf("expected a key not to be derived with %+v", params)
+		}
+	}
+
+	for _, params := range []salt.ScryptParams{
+		{LogN: 9, R: 8, P: 1, KeyLength: 32},
+		{LogN: 63, R: 8, P: 1, KeyLength: 32},
+		{LogN: 14, R: 0, P: 1, KeyLength: 32},
+		{LogN: 14, R: 8, P: 0, KeyLength: 32},
+		{LogN: 14, R: 1 << 15, P: 1 << 15, KeyLength: 32},
+		{LogN: 14, R: 8, P: 1, KeyLength: 0},
+	} {
+		if params.Validate() == nil {
+			t.Errorf("expected %+v to be rejected", params)
+		}
+		_, err := salt.ScryptWithParams([]byte("password"), nil, params)
+		if err == nil {
+			t.Errorf("expected a key not to be derived with %+v", params)
+		}
+	}
+
+	params := salt.Argon2Params{Time: 1, Memory: salt.MinArgon2Memory, Threads: 1, KeyLength: 64}
+	saltResult, err := salt.Argon2WithParams([]byte("password"), nil, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saltResult.Key) != 64 {
+		t.Errorf("expected a 64 byte key, got %d", len(saltResult.Key))
+	}
+
+	if salt.Argon2Cost(18).Validate() != nil || salt.ScryptCost(18).Validate() != nil {
+		t.Error("expected the recommended costs to be valid")
+	}
+}
@@ -0,0 +1,184 @@
+package serialize
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+	"sort"
+)
+
+// a canonical map is encoded as
+//   magic || count || (key length || key || value length || value)* || crc32c
+// with the keys in ascending byte order, so a map always encodes to the same bytes
+// the lengths and count are little endian uint64s, the checksum covers
+// everything before it and is stored little endian too
+//
+// read as the length prefix of a legacy map the magic is far larger than
+// MaxKeyLength, so the two encodings can't be confused
+
+var canonicalMagic = [8]byte{'P', 'W', 'M', 'M', 'A', 'P', 0, 1}
+
+var (
+	ErrChecksum = errors.New("serialized data fails its checksum")
+	// the keys are out of order or repeated, which an Encoder never writes
+	ErrNotCanonical = errors.New("serialized map is not in canonical order")
+	ErrTrailingData = errors.New("serialized map is followed by more data")
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+type Encoder struct {
+	w   io.Writer
+	crc hash.Hash32
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w, crc: crc32.New(castagnoli)}
+}
+
+// writes m straight to the underlying writer, nothing but the sorted keys is buffered
+// a map with a key or value over the limits Decode applies fails with
+// ErrFieldTooLarge before anything is written, it could never be read back
+func (e *Encoder) Encode(m map[string][]byte) error {
+	keys := make([]string, 0, len(m))
+	for key, value := range m {
+		if len(key) > MaxKeyLength || len(value) > MaxValueLength {
+			return ErrFieldTooLarge
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	e.crc.Reset()
+	out := io.MultiWriter(e.w, e.crc)
+
+	_, err := out.Write(canonicalMagic[:])
+	if err != nil {
+		return err
+	}
+
+	err = binary.Write(out, binary.LittleEndian, uint64(len(keys)))
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		err = writeField(out, []byte(key))
+		if err != nil {
+			return err
+		}
+		err = writeField(out, m[key])
+		if err != nil {
+			return err
+		}
+	}
+
+	return binary.Write(e.w, binary.LittleEndian, e.crc.Sum32())
+}
+
+func writeField(w io.Writer, field []byte) error {
+	err := binary.Write(w, binary.LittleEndian, uint64(len(field)))
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(field)
+	return err
+}
+
+type Decoder struct {
+	r   io.Reader
+	crc hash.Hash32
+}
+
+// the decoder reads no further than the end of each map, so r should be
+// buffered by the caller if small reads from it are slow
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: r, crc: crc32.New(castagnoli)}
+}
+
+// reads one canonical map, the same limits as DeserializeMap apply and
+// values are only allocated as their bytes arrive
+func (d *Decoder) Decode() (map[string][]byte, error) {
+	d.crc.Reset()
+	in := io.TeeReader(d.r, d.crc)
+
+	var magic [8]byte
+	_, err := io.ReadFull(in, magic[:])
+	if err != nil {
+		return nil, ErrTruncated
+	}
+	if magic != canonicalMagic {
+		return nil, errors.New("serialized data is not a canonical map")
+	}
+
+	var count uint64
+	err = binary.Read(in, binary.LittleEndian, &count)
+	if err != nil {
+		return nil, ErrTruncated
+	}
+
+	newMap := make(map[string][]byte)
+	var previous []byte
+	for i := uint64(0); i < count; i++ {
+		key, err := readStreamField(in, MaxKeyLength)
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 && bytes.Compare(previous, key) >= 0 {
+			return nil, ErrNotCanonical
+		}
+		previous = key
+
+		value, err := readStreamField(in, MaxValueLength)
+		if err != nil {
+			return nil, err
+		}
+
+		newMap[string(key)] = value
+	}
+
+	sum := d.crc.Sum32()
+	var stored uint32
+	err = binary.Read(d.r, binary.LittleEndian, &stored)
+	if err != nil {
+		return nil, ErrTruncated
+	}
+	if stored != sum {
+		return nil, ErrChecksum
+	}
+
+	return newMap, nil
+}
+
+func readStreamField(r io.Reader, maxLength uint64) ([]byte, error) {
+	var length uint64
+	err := binary.Read(r, binary.LittleEndian, &length)
+	if err != nil {
+		return nil, ErrTruncated
+	}
+	if length > maxLength {
+		return nil, ErrFieldTooLarge
+	}
+
+	// grows with the data actually read rather than trusting length
+	var field bytes.Buffer
+	n, err := io.CopyN(&field, r, int64(length))
+	if uint64(n) != length {
+		return nil, ErrTruncated
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if field.Len() == 0 {
+		return []byte{}, nil
+	}
+	return field.Bytes(), nil
+}
+
+func isCanonical(encodedBuffer []byte) bool {
+	return len(encodedBuffer) >= len(canonicalMagic) && bytes.Equal(encodedBuffer[:len(canonicalMagic)], canonicalMagic[:])
+}
@@ -0,0 +1,180 @@
+package serialize_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"testing"
+
+	"pwm/serialize"
+)
+
+func testMap() map[string][]byte {
+	m := make(map[string][]byte)
+	for i := 0; i < 50; i++ {
+		m[fmt.Sprintf("key %d", i)] = []byte(fmt.Sprintf("value %d", i))
+	}
+	m[""] = []byte{}
+	return m
+}
+
+func TestCanonical(t *testing.T) {
+	m := testMap()
+	first, err := serialize.SerializeMap(&m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// go randomises map iteration, so any ordering bug shows up in a few tries
+	for i := 0; i < 20; i++ {
+		copied := make(map[string][]byte)
+		for key, value := range m {
+			copied[key] = value
+		}
+		encoded, err := serialize.SerializeMap(&copied)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(first, encoded) {
+			t.Fatal("the same map encoded to different bytes")
+		}
+	}
+
+	decoded, err := serialize.DeserializeMap(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !areMapsEqual(m, decoded) || len(decoded) != len(m) {
+		t.Error("maps not equal")
+	}
+}
+
+func TestLegacyMap(t *testing.T) {
+	var legacy []byte
+	for _, field := range []string{"b", "2", "a", "1"} {
+		legacy = append(legacy, lengthPrefix(uint64(len(field)))...)
+		legacy = append(legacy, field...)
+	}
+
+	decoded, err := serialize.DeserializeMap(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decoded["a"]) != "1" || string(decoded["b"]) != "2" || len(decoded) != 2 {
+		t.Errorf("unexpected legacy map %v", decoded)
+	}
+
+	empty, err := serialize.DeserializeMap(nil)
+	if err != nil || len(empty) != 0 {
+		t.Error("an empty buffer is an empty legacy map")
+	}
+}
+
+// builds a canonical buffer by hand, with a valid checksum, from the fields in the order given
+func rawCanonical(fields ...string) []byte {
+	buffer := []byte{'P', 'W', 'M', 'M', 'A', 'P', 0, 1}
+	buffer = binary.LittleEndian.AppendUint64(buffer, uint64(len(fields)/2))
+	for _, field := range fields {
+		buffer = append(buffer, lengthPrefix(uint64(len(field)))...)
+		buffer = append(buffer, field...)
+	}
+	return binary.LittleEndian.AppendUint32(buffer, crc32.Checksum(buffer, crc32.MakeTable(crc32.Castagnoli)))
+}
+
+func TestCanonicalErrors(t *testing.T) {
+	valid := rawCanonical("a", "1", "b", "2")
+	m := map[string][]byte{"a": []byte("1"), "b": []byte("2")}
+	encoded, err := serialize.SerializeMap(&m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(valid, encoded) {
+		t.Fatal("the layout of the encoding changed")
+	}
+
+	corrupted := bytes.Clone(valid)
+	corrupted[len(corrupted)-5] ^= 1
+	_, err = serialize.DeserializeMap(corrupted)
+	if !errors.Is(err, serialize.ErrChecksum) {
+		t.Errorf("expected ErrChecksum, got %v", err)
+	}
+
+	for i := 8; i < len(valid); i++ {
+		_, err = serialize.DeserializeMap(valid[:i])
+		if !errors.Is(err, serialize.ErrTruncated) {
+			t.Errorf("expected ErrTruncated for %d bytes, got %v", i, err)
+		}
+	}
+
+	_, err = serialize.DeserializeMap(append(bytes.Clone(valid), 0))
+	if !errors.Is(err, serialize.ErrTrailingData) {
+		t.Errorf("expected ErrTrailingData, got %v", err)
+	}
+
+	_, err = serialize.DeserializeMap(rawCanonical("b", "2", "a", "1"))
+	if !errors.Is(err, serialize.ErrNotCanonical) {
+		t.Errorf("expected unsorted keys to fail, got %v", err)
+	}
+	_, err = serialize.DeserializeMap(rawCanonical("a", "1", "a", "2"))
+	if !errors.Is(err, serialize.ErrNotCanonical) {
+		t.Errorf("expected repeated keys to fail, got %v", err)
+	}
+}
+
+func TestEncoderDecoder(t *testing.T) {
+	var stream bytes.Buffer
+	encoder := serialize.NewEncoder(&stream)
+
+	maps := []map[string][]byte{testMap(), {}, {"only": []byte("one")}}
+	for _, m := range maps {
+		err := encoder.Encode(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	decoder := serialize.NewDecoder(&stream)
+	for i, m := range maps {
+		decoded, err := decoder.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !areMapsEqual(m, decoded) || len(decoded) != len(m) {
+			t.Errorf("map %d not equal", i)
+		}
+	}
+
+	_, err := decoder.Decode()
+	if !errors.Is(err, serialize.ErrTruncated) {
+		t.Errorf("expected the end of the stream to be ErrTruncated, got %v", err)
+	}
+}
+
+// what the decoder refuses mustn't be written, or the vault could never be opened again
+func TestEncodeTooLarge(t *testing.T) {
+	for name, m := range map[string]map[string][]byte{
+		"value": {"key": make([]byte, serialize.MaxValueLength+1)},
+		"key":   {string(make([]byte, serialize.MaxKeyLength+1)): []byte("value")},
+	} {
+		var stream bytes.Buffer
+		err := serialize.NewEncoder(&stream).Encode(m)
+		if !errors.Is(err, serialize.ErrFieldTooLarge) {
+			t.Errorf("expected a %s over the limit to be ErrFieldTooLarge, got %v", name, err)
+		}
+		if stream.Len() != 0 {
+			t.Errorf("expected nothing to be written for a %s over the limit", name)
+		}
+	}
+
+	// the limits themselves are allowed
+	var stream bytes.Buffer
+	err := serialize.NewEncoder(&stream).Encode(map[string][]byte{"key": make([]byte, serialize.MaxValueLength)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := serialize.NewDecoder(&stream).Decode(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,47 @@
+package serialize
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// every key and value is stored as a little endian uint64 length then its bytes
+// the lengths are checked before anything is allocated, so a corrupted buffer
+// can't ask for more memory than it could possibly hold
+const (
+	lengthSize = 8
+
+	MaxKeyLength   = 1 << 16
+	MaxValueLength = 1 << 26
+)
+
+var (
+	ErrTruncated     = errors.New("serialized data is truncated")
+	ErrFieldTooLarge = errors.New("serialized field is larger than allowed")
+)
+
+func readField(buffer *bytes.Reader, maxLength uint64) ([]byte, error) {
+	var lengthBytes [lengthSize]byte
+	_, err := io.ReadFull(buffer, lengthBytes[:])
+	if err != nil {
+		return nil, ErrTruncated
+	}
+
+	length := binary.LittleEndian.Uint64(lengthBytes[:])
+	if length > maxLength {
+		return nil, ErrFieldTooLarge
+	}
+	if length > uint64(buffer.Len()) {
+		return nil, ErrTruncated
+	}
+
+	field := make([]byte, length)
+	_, err = io.ReadFull(buffer, field)
+	if err != nil {
+		return nil, ErrTruncated
+	}
+
+	return field, nil
+}
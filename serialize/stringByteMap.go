@@ -2,62 +2,49 @@ package serialize
 
 import (
 	"bytes"
-	"encoding/binary"
 )
 
+// the canonical encoding, see Encoder
 func SerializeMap(passwords *map[string][]byte) ([]byte, error) {
 	var buffer bytes.Buffer
-	for key, value := range(*passwords) {
-		err := binary.Write(&buffer, binary.LittleEndian, uint64(len(key)))
-		if err != nil {
-			return nil, err
-		}
-		
-		_, err = buffer.WriteString(key)
-		if err != nil {
-			return nil, err
-		}
+	err := NewEncoder(&buffer).Encode(*passwords)
+	if err != nil {
+		return nil, err
+	}
 
-		err = binary.Write(&buffer, binary.LittleEndian, uint64(len(value)))
-		if err != nil {
-			return nil, err
-		}
+	return buffer.Bytes(), nil
+}
 
-		_, err = buffer.Write(value)
+// reads the canonical encoding, or the unordered one written before it
+// fails with ErrTruncated or ErrFieldTooLarge instead of trusting the length prefixes
+func DeserializeMap(encodedBuffer []byte) (map[string][]byte, error) {
+	if isCanonical(encodedBuffer) {
+		buffer := bytes.NewReader(encodedBuffer)
+		newMap, err := NewDecoder(buffer).Decode()
 		if err != nil {
 			return nil, err
 		}
+		if buffer.Len() != 0 {
+			return nil, ErrTrailingData
+		}
+		return newMap, nil
 	}
 
-	return buffer.Bytes(), nil
+	return deserializeLegacyMap(encodedBuffer)
 }
 
-func DeserializeMap(encodedBuffer []byte) (map[string][]byte, error) {
+func deserializeLegacyMap(encodedBuffer []byte) (map[string][]byte, error) {
 	newMap := make(map[string][]byte)
 
 	buffer := bytes.NewReader(encodedBuffer)
 
 	for buffer.Len() > 0 {
-		var keyLen uint64
-		err := binary.Read(buffer, binary.LittleEndian, &keyLen)
-		if err != nil {
-			return nil, err
-		}
-
-		key := make([]byte, keyLen)
-		_, err = buffer.Read(key)
-		if err != nil {
-			return nil, err
-		}
-
-		var dataLen uint64
-		err = binary.Read(buffer, binary.LittleEndian, &dataLen)
+		key, err := readField(buffer, MaxKeyLength)
 		if err != nil {
 			return nil, err
 		}
 
-		data := make([]byte, dataLen)
-		_, err = buffer.Read(data)
+		data, err := readField(buffer, MaxValueLength)
 		if err != nil {
 			return nil, err
 		}
@@ -67,4 +54,3 @@ func DeserializeMap(encodedBuffer []byte) (map[string][]byte, error) {
 
 	return newMap, nil
 }
-
@@ -1,6 +1,9 @@
 package serialize_test
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
 	"pwm/serialize"
 	"testing"
 )
@@ -46,3 +49,84 @@ func TestMap(t *testing.T) {
 		t.Error("maps not equal")
 	}
 }
+
+func lengthPrefix(length uint64) []byte {
+	return binary.LittleEndian.AppendUint64(nil, length)
+}
+
+func TestDeserializeMapErrors(t *testing.T) {
+	valid, err := serialize.SerializeMap(&map[string][]byte{"key": []byte("value")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every prefix of a valid buffer is truncated somewhere
+	for i := 1; i < len(valid); i++ {
+		_, err := serialize.DeserializeMap(valid[:i])
+		if !errors.Is(err, serialize.ErrTruncated) {
+			t.Errorf("expected ErrTruncated for %d bytes, got %v", i, err)
+		}
+	}
+
+	cases := []struct {
+		name   string
+		buffer []byte
+		err    error
+	}{
+		{"huge key", lengthPrefix(1 << 62), serialize.ErrFieldTooLarge},
+		{"key over the limit", lengthPrefix(serialize.MaxKeyLength + 1), serialize.ErrFieldTooLarge},
+		{"key longer than the buffer", append(lengthPrefix(10), "abc"...), serialize.ErrTruncated},
+		{"huge value", append(append(lengthPrefix(1), 'k'), lengthPrefix(1<<63)...), serialize.ErrFieldTooLarge},
+		{"missing value", append(lengthPrefix(1), 'k'), serialize.ErrTruncated},
+	}
+	for _, c := range cases {
+		_, err := serialize.DeserializeMap(c.buffer)
+		if !errors.Is(err, c.err) {
+			t.Errorf("%s: expected %v, got %v", c.name, c.err, err)
+		}
+	}
+}
+
+// go test -fuzz FuzzDeserializeMap ./serialize
+func FuzzDeserializeMap(f *testing.F) {
+	valid, err := serialize.SerializeMap(&map[string][]byte{"key": []byte("value"), "": nil})
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(valid)
+	f.Add([]byte{})
+	f.Add(lengthPrefix(1 << 40))
+
+	f.Fuzz(func(t *testing.T, buffer []byte) {
+		decoded, err := serialize.DeserializeMap(buffer)
+		if err != nil {
+			for _, known := range []error{serialize.ErrTruncated, serialize.ErrFieldTooLarge, serialize.ErrChecksum, serialize.ErrNotCanonical, serialize.ErrTrailingData} {
+				if errors.Is(err, known) {
+					return
+				}
+			}
+			t.Fatalf("unexpected error type %v", err)
+		}
+
+		// whatever decodes has to survive a round trip
+		encoded, err := serialize.SerializeMap(&decoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.HasPrefix(buffer, encoded[:8]) && !bytes.Equal(buffer, encoded) {
+			t.Fatal("a decoded canonical map must encode to the same bytes")
+		}
+		again, err := serialize.DeserializeMap(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(again) != len(decoded) {
+			t.Fatal("round trip changed the number of keys")
+		}
+		for key, value := range decoded {
+			if !bytes.Equal(again[key], value) {
+				t.Fatalf("round trip changed the value of %q", key)
+			}
+		}
+	})
+}
@@ -0,0 +1,46 @@
+package serialize
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// fails with ErrFieldTooLarge for a value DeserializeStrings would refuse
+func SerializeStrings(values []string) ([]byte, error) {
+	var buffer bytes.Buffer
+	for _, value := range values {
+		if len(value) > MaxValueLength {
+			return nil, ErrFieldTooLarge
+		}
+
+		err := binary.Write(&buffer, binary.LittleEndian, uint64(len(value)))
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = buffer.WriteString(value)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// fails with ErrTruncated or ErrFieldTooLarge instead of trusting the length prefixes
+func DeserializeStrings(encodedBuffer []byte) ([]string, error) {
+	values := make([]string, 0)
+
+	buffer := bytes.NewReader(encodedBuffer)
+
+	for buffer.Len() > 0 {
+		value, err := readField(buffer, MaxValueLength)
+		if err != nil {
+			return nil, err
+		}
+
+		values = append(values, string(value))
+	}
+
+	return values, nil
+}
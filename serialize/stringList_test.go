@@ -0,0 +1,65 @@
+package serialize_test
+
+import (
+	"errors"
+	"pwm/serialize"
+	"testing"
+)
+
+func TestStrings(t *testing.T) {
+	values := []string{"https://example.com", "work", ""}
+
+	encoded, err := serialize.SerializeStrings(values)
+	if err != nil {
+		t.Error(err)
+	}
+
+	newValues, err := serialize.DeserializeStrings(encoded)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if len(values) != len(newValues) {
+		t.Fatal("lists not the same length")
+	}
+	for i := range values {
+		if values[i] != newValues[i] {
+			t.Errorf("value %d not equal", i)
+		}
+	}
+}
+
+func TestSerializeStringsTooLarge(t *testing.T) {
+	_, err := serialize.SerializeStrings([]string{"a", string(make([]byte, serialize.MaxValueLength+1))})
+	if !errors.Is(err, serialize.ErrFieldTooLarge) {
+		t.Errorf("expected ErrFieldTooLarge, got %v", err)
+	}
+}
+
+// go test -fuzz FuzzDeserializeStrings ./serialize
+func FuzzDeserializeStrings(f *testing.F) {
+	valid, err := serialize.SerializeStrings([]string{"a", "", "https://example.com"})
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(valid)
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+
+	f.Fuzz(func(t *testing.T, buffer []byte) {
+		values, err := serialize.DeserializeStrings(buffer)
+		if err != nil {
+			if !errors.Is(err, serialize.ErrTruncated) && !errors.Is(err, serialize.ErrFieldTooLarge) {
+				t.Fatalf("unexpected error type %v", err)
+			}
+			return
+		}
+
+		encoded, err := serialize.SerializeStrings(values)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(encoded) != string(buffer) {
+			t.Fatal("a decoded list must encode to the same bytes")
+		}
+	})
+}